@@ -1,8 +1,11 @@
 package gallerypb
 
 import (
+	"fmt"
+
 	imagepb "github.com/modernice/media-entity/api/proto/gen/image/v0"
 	"github.com/modernice/media-entity/gallery"
+	"github.com/modernice/media-entity/image"
 	"github.com/modernice/media-entity/internal/slicex"
 )
 
@@ -34,6 +37,22 @@ func (g *Gallery) AsGallery() gallery.DTO[StringID, StringID] {
 	return AsGallery(g, newStringID, newStringID)
 }
 
+// ResolveURLs sets the URLs of all images in the gallery to the URLs that are
+// resolved from their storage locations by the provided resolver.
+func (g *Gallery) ResolveURLs(r image.URLResolver) error {
+	for _, stack := range g.GetStacks() {
+		for _, variant := range stack.GetVariants() {
+			if variant.GetImage() == nil {
+				continue
+			}
+			if err := variant.GetImage().ResolveURL(r); err != nil {
+				return fmt.Errorf("stack %s: variant %s: %w", stack.GetId(), variant.GetId(), err)
+			}
+		}
+	}
+	return nil
+}
+
 func NewStack[StackID, ImageID gallery.ID](s gallery.Stack[StackID, ImageID]) *Stack {
 	return &Stack{
 		Id:       s.ID.String(),
@@ -1,6 +1,9 @@
 package imagepb
 
 import (
+	"fmt"
+	"time"
+
 	filepb "github.com/modernice/media-entity/api/proto/gen/file/v0"
 	"github.com/modernice/media-entity/image"
 	"github.com/modernice/media-entity/internal/mapx"
@@ -16,6 +19,10 @@ func New(img image.Image) *Image {
 		Names:        mapx.Ensure(img.Names),
 		Descriptions: mapx.Ensure(img.Descriptions),
 		Tags:         slicex.Ensure(img.Tags),
+		Hash:         img.Hash,
+		Url:          img.URL,
+		Source:       img.Source,
+		Metadata:     NewMetadata(img.Metadata),
 	}
 }
 
@@ -28,7 +35,22 @@ func (img *Image) AsImage() image.Image {
 		Names:        mapx.Ensure(img.GetNames()),
 		Descriptions: mapx.Ensure(img.GetDescriptions()),
 		Tags:         slicex.Ensure(img.GetTags()),
+		Hash:         img.GetHash(),
+		URL:          img.GetUrl(),
+		Source:       img.GetSource(),
+		Metadata:     img.GetMetadata().AsMetadata(),
+	}
+}
+
+// ResolveURL sets the URL of the image to the URL that is resolved from its
+// storage location by the provided resolver.
+func (img *Image) ResolveURL(r image.URLResolver) error {
+	u, err := r.ResolveURL(img.GetStorage().AsStorage())
+	if err != nil {
+		return fmt.Errorf("resolve url of %q: %w", img.GetStorage().GetPath(), err)
 	}
+	img.Url = u
+	return nil
 }
 
 func NewDimensions(d image.Dimensions) *Dimensions {
@@ -41,3 +63,79 @@ func NewDimensions(d image.Dimensions) *Dimensions {
 func (d *Dimensions) AsDimensions() image.Dimensions {
 	return image.Dimensions{int(d.GetWidth()), int(d.GetHeight())}
 }
+
+// NewMetadata converts image metadata to its protobuf representation. A nil
+// *image.Metadata is converted to a nil *Metadata.
+func NewMetadata(m *image.Metadata) *Metadata {
+	if m == nil {
+		return nil
+	}
+
+	out := &Metadata{
+		Make:         m.Make,
+		Model:        m.Model,
+		Lens:         m.Lens,
+		Orientation:  int64(m.Orientation),
+		ExposureTime: m.ExposureTime,
+		FNumber:      m.FNumber,
+		Iso:          int64(m.ISO),
+		FocalLength:  m.FocalLength,
+		Creator:      m.Creator,
+		Copyright:    m.Copyright,
+		Title:        m.Title,
+		Caption:      m.Caption,
+		Keywords:     slicex.Ensure(m.Keywords),
+	}
+
+	if m.CapturedAt != nil {
+		out.CapturedAt = m.CapturedAt.Format(time.RFC3339Nano)
+	}
+
+	if m.GPS != nil {
+		out.Gps = &GPS{
+			Latitude:  m.GPS.Latitude,
+			Longitude: m.GPS.Longitude,
+			Altitude:  m.GPS.Altitude,
+		}
+	}
+
+	return out
+}
+
+// AsMetadata converts the protobuf metadata to image metadata. A nil *Metadata
+// is converted to a nil *image.Metadata.
+func (m *Metadata) AsMetadata() *image.Metadata {
+	if m == nil {
+		return nil
+	}
+
+	out := &image.Metadata{
+		Make:         m.GetMake(),
+		Model:        m.GetModel(),
+		Lens:         m.GetLens(),
+		Orientation:  int(m.GetOrientation()),
+		ExposureTime: m.GetExposureTime(),
+		FNumber:      m.GetFNumber(),
+		ISO:          int(m.GetIso()),
+		FocalLength:  m.GetFocalLength(),
+		Creator:      m.GetCreator(),
+		Copyright:    m.GetCopyright(),
+		Title:        m.GetTitle(),
+		Caption:      m.GetCaption(),
+		Keywords:     m.GetKeywords(),
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, m.GetCapturedAt()); err == nil {
+		out.CapturedAt = &t
+	}
+
+	if gps := m.GetGps(); gps != nil {
+		out.GPS = &image.GPS{
+			Latitude:  gps.GetLatitude(),
+			Longitude: gps.GetLongitude(),
+			Altitude:  gps.GetAltitude(),
+		}
+	}
+
+	return out
+}
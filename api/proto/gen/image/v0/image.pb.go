@@ -34,6 +34,10 @@ type Image struct {
 	Names        map[string]string `protobuf:"bytes,5,rep,name=names,proto3" json:"names,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
 	Descriptions map[string]string `protobuf:"bytes,6,rep,name=descriptions,proto3" json:"descriptions,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
 	Tags         []string          `protobuf:"bytes,7,rep,name=tags,proto3" json:"tags,omitempty"`
+	Hash         string            `protobuf:"bytes,8,opt,name=hash,proto3" json:"hash,omitempty"`
+	Url          string            `protobuf:"bytes,9,opt,name=url,proto3" json:"url,omitempty"`
+	Source       string            `protobuf:"bytes,10,opt,name=source,proto3" json:"source,omitempty"`
+	Metadata     *Metadata         `protobuf:"bytes,11,opt,name=metadata,proto3" json:"metadata,omitempty"`
 }
 
 func (x *Image) Reset() {
@@ -117,6 +121,34 @@ func (x *Image) GetTags() []string {
 	return nil
 }
 
+func (x *Image) GetHash() string {
+	if x != nil {
+		return x.Hash
+	}
+	return ""
+}
+
+func (x *Image) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *Image) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *Image) GetMetadata() *Metadata {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
 // Dimensions are the width and height of an image.
 type Dimensions struct {
 	state         protoimpl.MessageState
@@ -173,6 +205,231 @@ func (x *Dimensions) GetHeight() int64 {
 	return 0
 }
 
+// Metadata is the EXIF, IPTC, and XMP metadata of an image.
+type Metadata struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Make        string `protobuf:"bytes,1,opt,name=make,proto3" json:"make,omitempty"`
+	Model       string `protobuf:"bytes,2,opt,name=model,proto3" json:"model,omitempty"`
+	Lens        string `protobuf:"bytes,3,opt,name=lens,proto3" json:"lens,omitempty"`
+	Orientation int64  `protobuf:"varint,4,opt,name=orientation,proto3" json:"orientation,omitempty"`
+	// RFC 3339 date and time at which the image was captured.
+	CapturedAt   string   `protobuf:"bytes,5,opt,name=captured_at,json=capturedAt,proto3" json:"captured_at,omitempty"`
+	ExposureTime string   `protobuf:"bytes,6,opt,name=exposure_time,json=exposureTime,proto3" json:"exposure_time,omitempty"`
+	FNumber      float64  `protobuf:"fixed64,7,opt,name=f_number,json=fNumber,proto3" json:"f_number,omitempty"`
+	Iso          int64    `protobuf:"varint,8,opt,name=iso,proto3" json:"iso,omitempty"`
+	FocalLength  float64  `protobuf:"fixed64,9,opt,name=focal_length,json=focalLength,proto3" json:"focal_length,omitempty"`
+	Gps          *GPS     `protobuf:"bytes,10,opt,name=gps,proto3" json:"gps,omitempty"`
+	Creator      string   `protobuf:"bytes,11,opt,name=creator,proto3" json:"creator,omitempty"`
+	Copyright    string   `protobuf:"bytes,12,opt,name=copyright,proto3" json:"copyright,omitempty"`
+	Title        string   `protobuf:"bytes,13,opt,name=title,proto3" json:"title,omitempty"`
+	Caption      string   `protobuf:"bytes,14,opt,name=caption,proto3" json:"caption,omitempty"`
+	Keywords     []string `protobuf:"bytes,15,rep,name=keywords,proto3" json:"keywords,omitempty"`
+}
+
+func (x *Metadata) Reset() {
+	*x = Metadata{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_mediaentity_image_v0_image_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Metadata) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Metadata) ProtoMessage() {}
+
+func (x *Metadata) ProtoReflect() protoreflect.Message {
+	mi := &file_mediaentity_image_v0_image_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Metadata.ProtoReflect.Descriptor instead.
+func (*Metadata) Descriptor() ([]byte, []int) {
+	return file_mediaentity_image_v0_image_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Metadata) GetMake() string {
+	if x != nil {
+		return x.Make
+	}
+	return ""
+}
+
+func (x *Metadata) GetModel() string {
+	if x != nil {
+		return x.Model
+	}
+	return ""
+}
+
+func (x *Metadata) GetLens() string {
+	if x != nil {
+		return x.Lens
+	}
+	return ""
+}
+
+func (x *Metadata) GetOrientation() int64 {
+	if x != nil {
+		return x.Orientation
+	}
+	return 0
+}
+
+func (x *Metadata) GetCapturedAt() string {
+	if x != nil {
+		return x.CapturedAt
+	}
+	return ""
+}
+
+func (x *Metadata) GetExposureTime() string {
+	if x != nil {
+		return x.ExposureTime
+	}
+	return ""
+}
+
+func (x *Metadata) GetFNumber() float64 {
+	if x != nil {
+		return x.FNumber
+	}
+	return 0
+}
+
+func (x *Metadata) GetIso() int64 {
+	if x != nil {
+		return x.Iso
+	}
+	return 0
+}
+
+func (x *Metadata) GetFocalLength() float64 {
+	if x != nil {
+		return x.FocalLength
+	}
+	return 0
+}
+
+func (x *Metadata) GetGps() *GPS {
+	if x != nil {
+		return x.Gps
+	}
+	return nil
+}
+
+func (x *Metadata) GetCreator() string {
+	if x != nil {
+		return x.Creator
+	}
+	return ""
+}
+
+func (x *Metadata) GetCopyright() string {
+	if x != nil {
+		return x.Copyright
+	}
+	return ""
+}
+
+func (x *Metadata) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *Metadata) GetCaption() string {
+	if x != nil {
+		return x.Caption
+	}
+	return ""
+}
+
+func (x *Metadata) GetKeywords() []string {
+	if x != nil {
+		return x.Keywords
+	}
+	return nil
+}
+
+// GPS is a location in decimal degrees.
+type GPS struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Latitude  float64 `protobuf:"fixed64,1,opt,name=latitude,proto3" json:"latitude,omitempty"`
+	Longitude float64 `protobuf:"fixed64,2,opt,name=longitude,proto3" json:"longitude,omitempty"`
+	Altitude  float64 `protobuf:"fixed64,3,opt,name=altitude,proto3" json:"altitude,omitempty"`
+}
+
+func (x *GPS) Reset() {
+	*x = GPS{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_mediaentity_image_v0_image_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *GPS) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GPS) ProtoMessage() {}
+
+func (x *GPS) ProtoReflect() protoreflect.Message {
+	mi := &file_mediaentity_image_v0_image_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GPS.ProtoReflect.Descriptor instead.
+func (*GPS) Descriptor() ([]byte, []int) {
+	return file_mediaentity_image_v0_image_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *GPS) GetLatitude() float64 {
+	if x != nil {
+		return x.Latitude
+	}
+	return 0
+}
+
+func (x *GPS) GetLongitude() float64 {
+	if x != nil {
+		return x.Longitude
+	}
+	return 0
+}
+
+func (x *GPS) GetAltitude() float64 {
+	if x != nil {
+		return x.Altitude
+	}
+	return 0
+}
+
 var File_mediaentity_image_v0_image_proto protoreflect.FileDescriptor
 
 var file_mediaentity_image_v0_image_proto_rawDesc = []byte{
@@ -181,7 +438,7 @@ var file_mediaentity_image_v0_image_proto_rawDesc = []byte{
 	0x74, 0x6f, 0x12, 0x14, 0x6d, 0x65, 0x64, 0x69, 0x61, 0x65, 0x6e, 0x74, 0x69, 0x74, 0x79, 0x2e,
 	0x69, 0x6d, 0x61, 0x67, 0x65, 0x2e, 0x76, 0x30, 0x1a, 0x21, 0x6d, 0x65, 0x64, 0x69, 0x61, 0x65,
 	0x6e, 0x74, 0x69, 0x74, 0x79, 0x2f, 0x66, 0x69, 0x6c, 0x65, 0x2f, 0x76, 0x30, 0x2f, 0x73, 0x74,
-	0x6f, 0x72, 0x61, 0x67, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0xd9, 0x03, 0x0a, 0x05,
+	0x6f, 0x72, 0x61, 0x67, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0xd3, 0x04, 0x0a, 0x05,
 	0x49, 0x6d, 0x61, 0x67, 0x65, 0x12, 0x36, 0x0a, 0x07, 0x73, 0x74, 0x6f, 0x72, 0x61, 0x67, 0x65,
 	0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1c, 0x2e, 0x6d, 0x65, 0x64, 0x69, 0x61, 0x65, 0x6e,
 	0x74, 0x69, 0x74, 0x79, 0x2e, 0x66, 0x69, 0x6c, 0x65, 0x2e, 0x76, 0x30, 0x2e, 0x53, 0x74, 0x6f,
@@ -203,23 +460,64 @@ var file_mediaentity_image_v0_image_proto_rawDesc = []byte{
 	0x76, 0x30, 0x2e, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x2e, 0x44, 0x65, 0x73, 0x63, 0x72, 0x69, 0x70,
 	0x74, 0x69, 0x6f, 0x6e, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x0c, 0x64, 0x65, 0x73, 0x63,
 	0x72, 0x69, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x61, 0x67, 0x73,
-	0x18, 0x07, 0x20, 0x03, 0x28, 0x09, 0x52, 0x04, 0x74, 0x61, 0x67, 0x73, 0x1a, 0x38, 0x0a, 0x0a,
-	0x4e, 0x61, 0x6d, 0x65, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65,
-	0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05,
-	0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x76, 0x61, 0x6c,
-	0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x1a, 0x3f, 0x0a, 0x11, 0x44, 0x65, 0x73, 0x63, 0x72, 0x69,
-	0x70, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b,
-	0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a,
-	0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x76, 0x61,
-	0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x3a, 0x0a, 0x0a, 0x44, 0x69, 0x6d, 0x65, 0x6e,
-	0x73, 0x69, 0x6f, 0x6e, 0x73, 0x12, 0x14, 0x0a, 0x05, 0x77, 0x69, 0x64, 0x74, 0x68, 0x18, 0x01,
-	0x20, 0x01, 0x28, 0x03, 0x52, 0x05, 0x77, 0x69, 0x64, 0x74, 0x68, 0x12, 0x16, 0x0a, 0x06, 0x68,
-	0x65, 0x69, 0x67, 0x68, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x68, 0x65, 0x69,
-	0x67, 0x68, 0x74, 0x42, 0x42, 0x5a, 0x40, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f,
-	0x6d, 0x2f, 0x6d, 0x6f, 0x64, 0x65, 0x72, 0x6e, 0x69, 0x63, 0x65, 0x2f, 0x6d, 0x65, 0x64, 0x69,
-	0x61, 0x2d, 0x65, 0x6e, 0x74, 0x69, 0x74, 0x79, 0x2f, 0x61, 0x70, 0x69, 0x2f, 0x70, 0x72, 0x6f,
-	0x74, 0x6f, 0x2f, 0x67, 0x65, 0x6e, 0x2f, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x2f, 0x76, 0x30, 0x3b,
-	0x69, 0x6d, 0x61, 0x67, 0x65, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x18, 0x07, 0x20, 0x03, 0x28, 0x09, 0x52, 0x04, 0x74, 0x61, 0x67, 0x73, 0x12, 0x12, 0x0a, 0x04,
+	0x68, 0x61, 0x73, 0x68, 0x18, 0x08, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x68, 0x61, 0x73, 0x68,
+	0x12, 0x10, 0x0a, 0x03, 0x75, 0x72, 0x6c, 0x18, 0x09, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x75,
+	0x72, 0x6c, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x18, 0x0a, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x12, 0x3a, 0x0a, 0x08, 0x6d, 0x65,
+	0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1e, 0x2e, 0x6d,
+	0x65, 0x64, 0x69, 0x61, 0x65, 0x6e, 0x74, 0x69, 0x74, 0x79, 0x2e, 0x69, 0x6d, 0x61, 0x67, 0x65,
+	0x2e, 0x76, 0x30, 0x2e, 0x4d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x52, 0x08, 0x6d, 0x65,
+	0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x1a, 0x38, 0x0a, 0x0a, 0x4e, 0x61, 0x6d, 0x65, 0x73, 0x45,
+	0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01,
+	0x1a, 0x3f, 0x0a, 0x11, 0x44, 0x65, 0x73, 0x63, 0x72, 0x69, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x73,
+	0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38,
+	0x01, 0x22, 0x3a, 0x0a, 0x0a, 0x44, 0x69, 0x6d, 0x65, 0x6e, 0x73, 0x69, 0x6f, 0x6e, 0x73, 0x12,
+	0x14, 0x0a, 0x05, 0x77, 0x69, 0x64, 0x74, 0x68, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x05,
+	0x77, 0x69, 0x64, 0x74, 0x68, 0x12, 0x16, 0x0a, 0x06, 0x68, 0x65, 0x69, 0x67, 0x68, 0x74, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x68, 0x65, 0x69, 0x67, 0x68, 0x74, 0x22, 0xb1, 0x03,
+	0x0a, 0x08, 0x4d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x12, 0x12, 0x0a, 0x04, 0x6d, 0x61,
+	0x6b, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6d, 0x61, 0x6b, 0x65, 0x12, 0x14,
+	0x0a, 0x05, 0x6d, 0x6f, 0x64, 0x65, 0x6c, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x6d,
+	0x6f, 0x64, 0x65, 0x6c, 0x12, 0x12, 0x0a, 0x04, 0x6c, 0x65, 0x6e, 0x73, 0x18, 0x03, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x04, 0x6c, 0x65, 0x6e, 0x73, 0x12, 0x20, 0x0a, 0x0b, 0x6f, 0x72, 0x69, 0x65,
+	0x6e, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x04, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0b, 0x6f,
+	0x72, 0x69, 0x65, 0x6e, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x1f, 0x0a, 0x0b, 0x63, 0x61,
+	0x70, 0x74, 0x75, 0x72, 0x65, 0x64, 0x5f, 0x61, 0x74, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x0a, 0x63, 0x61, 0x70, 0x74, 0x75, 0x72, 0x65, 0x64, 0x41, 0x74, 0x12, 0x23, 0x0a, 0x0d, 0x65,
+	0x78, 0x70, 0x6f, 0x73, 0x75, 0x72, 0x65, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x06, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x0c, 0x65, 0x78, 0x70, 0x6f, 0x73, 0x75, 0x72, 0x65, 0x54, 0x69, 0x6d, 0x65,
+	0x12, 0x19, 0x0a, 0x08, 0x66, 0x5f, 0x6e, 0x75, 0x6d, 0x62, 0x65, 0x72, 0x18, 0x07, 0x20, 0x01,
+	0x28, 0x01, 0x52, 0x07, 0x66, 0x4e, 0x75, 0x6d, 0x62, 0x65, 0x72, 0x12, 0x10, 0x0a, 0x03, 0x69,
+	0x73, 0x6f, 0x18, 0x08, 0x20, 0x01, 0x28, 0x03, 0x52, 0x03, 0x69, 0x73, 0x6f, 0x12, 0x21, 0x0a,
+	0x0c, 0x66, 0x6f, 0x63, 0x61, 0x6c, 0x5f, 0x6c, 0x65, 0x6e, 0x67, 0x74, 0x68, 0x18, 0x09, 0x20,
+	0x01, 0x28, 0x01, 0x52, 0x0b, 0x66, 0x6f, 0x63, 0x61, 0x6c, 0x4c, 0x65, 0x6e, 0x67, 0x74, 0x68,
+	0x12, 0x2b, 0x0a, 0x03, 0x67, 0x70, 0x73, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x19, 0x2e,
+	0x6d, 0x65, 0x64, 0x69, 0x61, 0x65, 0x6e, 0x74, 0x69, 0x74, 0x79, 0x2e, 0x69, 0x6d, 0x61, 0x67,
+	0x65, 0x2e, 0x76, 0x30, 0x2e, 0x47, 0x50, 0x53, 0x52, 0x03, 0x67, 0x70, 0x73, 0x12, 0x18, 0x0a,
+	0x07, 0x63, 0x72, 0x65, 0x61, 0x74, 0x6f, 0x72, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07,
+	0x63, 0x72, 0x65, 0x61, 0x74, 0x6f, 0x72, 0x12, 0x1c, 0x0a, 0x09, 0x63, 0x6f, 0x70, 0x79, 0x72,
+	0x69, 0x67, 0x68, 0x74, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x63, 0x6f, 0x70, 0x79,
+	0x72, 0x69, 0x67, 0x68, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x74, 0x69, 0x74, 0x6c, 0x65, 0x18, 0x0d,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x74, 0x69, 0x74, 0x6c, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x63,
+	0x61, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x0e, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x63, 0x61,
+	0x70, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x1a, 0x0a, 0x08, 0x6b, 0x65, 0x79, 0x77, 0x6f, 0x72, 0x64,
+	0x73, 0x18, 0x0f, 0x20, 0x03, 0x28, 0x09, 0x52, 0x08, 0x6b, 0x65, 0x79, 0x77, 0x6f, 0x72, 0x64,
+	0x73, 0x22, 0x5b, 0x0a, 0x03, 0x47, 0x50, 0x53, 0x12, 0x1a, 0x0a, 0x08, 0x6c, 0x61, 0x74, 0x69,
+	0x74, 0x75, 0x64, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x01, 0x52, 0x08, 0x6c, 0x61, 0x74, 0x69,
+	0x74, 0x75, 0x64, 0x65, 0x12, 0x1c, 0x0a, 0x09, 0x6c, 0x6f, 0x6e, 0x67, 0x69, 0x74, 0x75, 0x64,
+	0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x09, 0x6c, 0x6f, 0x6e, 0x67, 0x69, 0x74, 0x75,
+	0x64, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x61, 0x6c, 0x74, 0x69, 0x74, 0x75, 0x64, 0x65, 0x18, 0x03,
+	0x20, 0x01, 0x28, 0x01, 0x52, 0x08, 0x61, 0x6c, 0x74, 0x69, 0x74, 0x75, 0x64, 0x65, 0x42, 0x42,
+	0x5a, 0x40, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6d, 0x6f, 0x64,
+	0x65, 0x72, 0x6e, 0x69, 0x63, 0x65, 0x2f, 0x6d, 0x65, 0x64, 0x69, 0x61, 0x2d, 0x65, 0x6e, 0x74,
+	0x69, 0x74, 0x79, 0x2f, 0x61, 0x70, 0x69, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x67, 0x65,
+	0x6e, 0x2f, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x2f, 0x76, 0x30, 0x3b, 0x69, 0x6d, 0x61, 0x67, 0x65,
+	0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -234,24 +532,28 @@ func file_mediaentity_image_v0_image_proto_rawDescGZIP() []byte {
 	return file_mediaentity_image_v0_image_proto_rawDescData
 }
 
-var file_mediaentity_image_v0_image_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_mediaentity_image_v0_image_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
 var file_mediaentity_image_v0_image_proto_goTypes = []interface{}{
 	(*Image)(nil),      // 0: mediaentity.image.v0.Image
 	(*Dimensions)(nil), // 1: mediaentity.image.v0.Dimensions
-	nil,                // 2: mediaentity.image.v0.Image.NamesEntry
-	nil,                // 3: mediaentity.image.v0.Image.DescriptionsEntry
-	(*v0.Storage)(nil), // 4: mediaentity.file.v0.Storage
+	(*Metadata)(nil),   // 2: mediaentity.image.v0.Metadata
+	(*GPS)(nil),        // 3: mediaentity.image.v0.GPS
+	nil,                // 4: mediaentity.image.v0.Image.NamesEntry
+	nil,                // 5: mediaentity.image.v0.Image.DescriptionsEntry
+	(*v0.Storage)(nil), // 6: mediaentity.file.v0.Storage
 }
 var file_mediaentity_image_v0_image_proto_depIdxs = []int32{
-	4, // 0: mediaentity.image.v0.Image.storage:type_name -> mediaentity.file.v0.Storage
+	6, // 0: mediaentity.image.v0.Image.storage:type_name -> mediaentity.file.v0.Storage
 	1, // 1: mediaentity.image.v0.Image.dimensions:type_name -> mediaentity.image.v0.Dimensions
-	2, // 2: mediaentity.image.v0.Image.names:type_name -> mediaentity.image.v0.Image.NamesEntry
-	3, // 3: mediaentity.image.v0.Image.descriptions:type_name -> mediaentity.image.v0.Image.DescriptionsEntry
-	4, // [4:4] is the sub-list for method output_type
-	4, // [4:4] is the sub-list for method input_type
-	4, // [4:4] is the sub-list for extension type_name
-	4, // [4:4] is the sub-list for extension extendee
-	0, // [0:4] is the sub-list for field type_name
+	4, // 2: mediaentity.image.v0.Image.names:type_name -> mediaentity.image.v0.Image.NamesEntry
+	5, // 3: mediaentity.image.v0.Image.descriptions:type_name -> mediaentity.image.v0.Image.DescriptionsEntry
+	2, // 4: mediaentity.image.v0.Image.metadata:type_name -> mediaentity.image.v0.Metadata
+	3, // 5: mediaentity.image.v0.Metadata.gps:type_name -> mediaentity.image.v0.GPS
+	6, // [6:6] is the sub-list for method output_type
+	6, // [6:6] is the sub-list for method input_type
+	6, // [6:6] is the sub-list for extension type_name
+	6, // [6:6] is the sub-list for extension extendee
+	0, // [0:6] is the sub-list for field type_name
 }
 
 func init() { file_mediaentity_image_v0_image_proto_init() }
@@ -284,6 +586,30 @@ func file_mediaentity_image_v0_image_proto_init() {
 				return nil
 			}
 		}
+		file_mediaentity_image_v0_image_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Metadata); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_mediaentity_image_v0_image_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*GPS); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -291,7 +617,7 @@ func file_mediaentity_image_v0_image_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_mediaentity_image_v0_image_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   4,
+			NumMessages:   6,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
@@ -0,0 +1,83 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ErrUnknownProvider is returned by a [URLResolver] if it cannot resolve URLs
+// for the provider of a file.
+var ErrUnknownProvider = errors.New("unknown storage provider")
+
+var (
+	_ URLResolver = BaseURL("")
+	_ URLResolver = URLTemplate("")
+	_ URLResolver = ProviderURLs(nil)
+	_ URLResolver = URLResolverFunc(nil)
+)
+
+// URLResolver resolves the public URL of a file from its [Storage] location.
+type URLResolver interface {
+	// ResolveURL returns the public URL of the file at the given location.
+	ResolveURL(Storage) (string, error)
+}
+
+// URLResolverFunc allows a function to be used as a [URLResolver].
+type URLResolverFunc func(Storage) (string, error)
+
+// ResolveURL returns fn(s).
+func (fn URLResolverFunc) ResolveURL(s Storage) (string, error) {
+	return fn(s)
+}
+
+// BaseURL is a [URLResolver] that joins a base URL and the path of a file.
+// The base URL may be the absolute URL of a CDN, for example
+// "https://cdn.example.com/images", or a static prefix, for example "/static".
+type BaseURL string
+
+// ResolveURL implements [URLResolver].
+func (base BaseURL) ResolveURL(s Storage) (string, error) {
+	return strings.TrimSuffix(string(base), "/") + "/" + escapePath(s.Path), nil
+}
+
+// URLTemplate is a [URLResolver] that replaces the "{provider}" and "{path}"
+// placeholders of a template with the provider and path of a file, for
+// example "https://{provider}.example.com/{path}". The path is URL-escaped.
+type URLTemplate string
+
+// ResolveURL implements [URLResolver].
+func (tmpl URLTemplate) ResolveURL(s Storage) (string, error) {
+	return strings.NewReplacer(
+		"{provider}", url.PathEscape(s.Provider),
+		"{path}", escapePath(s.Path),
+	).Replace(string(tmpl)), nil
+}
+
+// ProviderURLs is a [URLResolver] that resolves URLs using the [URLResolver]
+// of the provider of a file. If no resolver is configured for a provider,
+// ResolveURL returns an error that satisfies errors.Is(err, ErrUnknownProvider).
+//
+//	resolver := file.ProviderURLs{
+//		"s3": file.BaseURL("https://cdn.example.com"),
+//		"fs": file.BaseURL("/static"),
+//	}
+type ProviderURLs map[string]URLResolver
+
+// ResolveURL implements [URLResolver].
+func (urls ProviderURLs) ResolveURL(s Storage) (string, error) {
+	resolver, ok := urls[s.Provider]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownProvider, s.Provider)
+	}
+	return resolver.ResolveURL(s)
+}
+
+func escapePath(p string) string {
+	segments := strings.Split(strings.TrimPrefix(p, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
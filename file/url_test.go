@@ -0,0 +1,63 @@
+package file_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/modernice/media-entity/file"
+)
+
+func TestURLResolver(t *testing.T) {
+	storage := file.Storage{Provider: "s3", Path: "galleries/foo bar/image.jpg"}
+
+	tests := []struct {
+		name     string
+		resolver file.URLResolver
+		want     string
+	}{
+		{
+			name:     "BaseURL",
+			resolver: file.BaseURL("https://cdn.example.com/images/"),
+			want:     "https://cdn.example.com/images/galleries/foo%20bar/image.jpg",
+		},
+		{
+			name:     "BaseURL (static prefix)",
+			resolver: file.BaseURL("/static"),
+			want:     "/static/galleries/foo%20bar/image.jpg",
+		},
+		{
+			name:     "URLTemplate",
+			resolver: file.URLTemplate("https://{provider}.example.com/{path}?v=1"),
+			want:     "https://s3.example.com/galleries/foo%20bar/image.jpg?v=1",
+		},
+		{
+			name: "ProviderURLs",
+			resolver: file.ProviderURLs{
+				"fs": file.BaseURL("/static"),
+				"s3": file.BaseURL("https://cdn.example.com"),
+			},
+			want: "https://cdn.example.com/galleries/foo%20bar/image.jpg",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.resolver.ResolveURL(storage)
+			if err != nil {
+				t.Fatalf("ResolveURL() failed: %v", err)
+			}
+
+			if got != tt.want {
+				t.Fatalf("ResolveURL() should return %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestProviderURLs_unknownProvider(t *testing.T) {
+	resolver := file.ProviderURLs{"fs": file.BaseURL("/static")}
+
+	if _, err := resolver.ResolveURL(file.Storage{Provider: "s3", Path: "foo.jpg"}); !errors.Is(err, file.ErrUnknownProvider) {
+		t.Fatalf("ResolveURL() should fail with %q; got %v", file.ErrUnknownProvider, err)
+	}
+}
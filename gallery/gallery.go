@@ -54,6 +54,23 @@ func (dto DTO[StackID, ImageID]) Stack(id StackID) (Stack[StackID, ImageID], boo
 	return zeroStack[StackID, ImageID](), false
 }
 
+// ResolveURLs returns a deep-copy of the DTO with the URLs of all images
+// resolved by the provided [image.URLResolver].
+//
+//	resolver := file.ProviderURLs{"s3": file.BaseURL("https://cdn.example.com")}
+//	dto, err := g.DTO.ResolveURLs(resolver)
+func (dto DTO[StackID, ImageID]) ResolveURLs(r image.URLResolver) (DTO[StackID, ImageID], error) {
+	out := DTO[StackID, ImageID]{Stacks: make([]Stack[StackID, ImageID], len(dto.Stacks))}
+	for i, stack := range dto.Stacks {
+		resolved, err := stack.ResolveURLs(r)
+		if err != nil {
+			return dto, fmt.Errorf("stack %v: %w", stack.ID, err)
+		}
+		out.Stacks[i] = resolved
+	}
+	return out, nil
+}
+
 // New returns a new gallery [*Base] that can be embedded into structs build
 // galleries. The ID type of the gallery's stacks is specified by the StackID
 // type parameter.
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/google/uuid"
+	"github.com/modernice/media-entity/file"
 	"github.com/modernice/media-entity/gallery"
 	"github.com/modernice/media-entity/internal/galleryx"
 	"github.com/modernice/media-entity/internal/testcmp"
@@ -265,6 +266,32 @@ func TestGallery_Sort(t *testing.T) {
 	expectStackSorting(t, []uuid.UUID{stackIDs[0], stackIDs[2], stackIDs[3], stackIDs[1]}, g.Stacks)
 }
 
+func TestDTO_ResolveURLs(t *testing.T) {
+	g := gallery.New[uuid.UUID, uuid.UUID]()
+
+	stack, err := g.NewStack(uuid.New(), galleryx.NewImage(uuid.New()))
+	if err != nil {
+		t.Fatalf("add stack: %v", err)
+	}
+
+	resolved, err := g.DTO.ResolveURLs(file.ProviderURLs{"fs": file.BaseURL("https://cdn.example.com")})
+	if err != nil {
+		t.Fatalf("ResolveURLs() failed: %v", err)
+	}
+
+	if url := resolved.Stacks[0].Variants[0].URL; url != "https://cdn.example.com/foo/bar/baz.jpg" {
+		t.Fatalf("URL should be %q; is %q", "https://cdn.example.com/foo/bar/baz.jpg", url)
+	}
+
+	if original, _ := g.Stack(stack.ID); original.Variants[0].URL != "" {
+		t.Fatalf("ResolveURLs() should not modify the gallery")
+	}
+
+	if _, err := g.DTO.ResolveURLs(file.ProviderURLs{}); !errors.Is(err, file.ErrUnknownProvider) {
+		t.Fatalf("ResolveURLs() should fail with %q; got %v", file.ErrUnknownProvider, err)
+	}
+}
+
 func expectStackSorting[StackID, ImageID gallery.ID](t *testing.T, sorting []StackID, stacks []gallery.Stack[StackID, ImageID]) {
 	if len(sorting) != len(stacks) {
 		t.Fatalf("sorting and stacks should have the same length; sorting has %d, stacks has %d", len(sorting), len(stacks))
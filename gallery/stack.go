@@ -64,6 +64,20 @@ func (s Stack[StackID, ImageID]) Clone() Stack[StackID, ImageID] {
 	return s
 }
 
+// ResolveURLs returns a deep-copy of the Stack with the URLs of all variants
+// resolved by the provided [image.URLResolver].
+func (s Stack[StackID, ImageID]) ResolveURLs(r image.URLResolver) (Stack[StackID, ImageID], error) {
+	s = s.Clone()
+	for i, variant := range s.Variants {
+		img, err := variant.Image.ResolveURL(r)
+		if err != nil {
+			return s, fmt.Errorf("variant %v: %w", variant.ID, err)
+		}
+		s.Variants[i].Image = img
+	}
+	return s, nil
+}
+
 // Original returns the original image of the stack, or the zero [Image] if the
 // stack does not contain an original image.
 func (s Stack[StackID, ImageID]) Original() Image[ImageID] {
@@ -0,0 +1,312 @@
+package esgallery
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/modernice/media-entity/gallery"
+	"github.com/modernice/media-entity/image"
+)
+
+// DefaultBulkConcurrency is the default number of images that are uploaded
+// concurrently by [*Uploader.ImportFS].
+const DefaultBulkConcurrency = 4
+
+// SidecarSuffix is the suffix of sidecar metadata files. The sidecar of
+// "beach.jpg" is "beach.jpg.json".
+const SidecarSuffix = ".json"
+
+// Sidecar is the optional metadata of an image in a bulk import. Sidecars are
+// JSON files next to the image, named like the image with the [SidecarSuffix]:
+//
+//	{
+//		"names": {"en": "Beach", "de": "Strand"},
+//		"descriptions": {"en": "A sunny beach"},
+//		"tags": ["summer"]
+//	}
+type Sidecar struct {
+	// Names are the localized names of the image.
+	Names map[string]string `json:"names"`
+
+	// Descriptions are the localized descriptions of the image.
+	Descriptions map[string]string `json:"descriptions"`
+
+	// Tags are added to the [gallery.Stack] of the image.
+	Tags []string `json:"tags"`
+}
+
+// BulkImportOption is an option for [*Uploader.ImportFS] and
+// [*Uploader.ImportZip].
+type BulkImportOption func(*bulkImportConfig)
+
+type bulkImportConfig struct {
+	concurrency int
+}
+
+// BulkConcurrency returns a [BulkImportOption] that uploads up to n images
+// concurrently. The default is [DefaultBulkConcurrency].
+func BulkConcurrency(n int) BulkImportOption {
+	return func(cfg *bulkImportConfig) {
+		cfg.concurrency = n
+	}
+}
+
+// BulkImportReport is the report of a bulk import.
+type BulkImportReport[StackID, ImageID ID] struct {
+	// Files are the results of the imported files, in the order of their
+	// paths.
+	Files []BulkImportFile[StackID, ImageID]
+}
+
+// BulkImportFile is the result of a single file of a bulk import.
+type BulkImportFile[StackID, ImageID ID] struct {
+	// Path is the path of the file in the imported archive or directory.
+	Path string
+
+	// Stack is the created [gallery.Stack], if the import succeeded.
+	Stack gallery.Stack[StackID, ImageID]
+
+	// Err is the error of the import, if it failed.
+	Err error
+}
+
+// Succeeded returns the files that were imported successfully.
+func (r BulkImportReport[StackID, ImageID]) Succeeded() []BulkImportFile[StackID, ImageID] {
+	return r.filter(func(f BulkImportFile[StackID, ImageID]) bool { return f.Err == nil })
+}
+
+// Failed returns the files that could not be imported.
+func (r BulkImportReport[StackID, ImageID]) Failed() []BulkImportFile[StackID, ImageID] {
+	return r.filter(func(f BulkImportFile[StackID, ImageID]) bool { return f.Err != nil })
+}
+
+func (r BulkImportReport[StackID, ImageID]) filter(fn func(BulkImportFile[StackID, ImageID]) bool) []BulkImportFile[StackID, ImageID] {
+	var out []BulkImportFile[StackID, ImageID]
+	for _, f := range r.Files {
+		if fn(f) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// ImportZip imports the images of a zip archive into the provided gallery.
+// Read the documentation of [*Uploader.ImportFS] for more information.
+func (u *Uploader[StackID, ImageID]) ImportZip(
+	ctx context.Context,
+	g ProcessableGallery[StackID, ImageID],
+	r io.ReaderAt,
+	size int64,
+	newStackID func() StackID,
+	newImageID func() ImageID,
+	opts ...BulkImportOption,
+) (BulkImportReport[StackID, ImageID], error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return BulkImportReport[StackID, ImageID]{}, fmt.Errorf("open zip archive: %w", err)
+	}
+	return u.ImportFS(ctx, g, archive, newStackID, newImageID, opts...)
+}
+
+// ImportFS imports the images of a filesystem, for example a directory that is
+// opened with [os.DirFS], into the provided gallery. One [gallery.Stack] is
+// created for each image, using the provided functions to generate the ids of
+// the stacks and images. Files are considered images if their extension has
+// an "image/*" MIME type. Hidden files and directories are skipped.
+//
+// Images are uploaded concurrently, like with [*Uploader.UploadNew], but the
+// stacks are added to the gallery in the order of the file paths after all
+// images were uploaded. The names, descriptions, and tags of each image are
+// read from its optional [Sidecar].
+//
+// ImportFS returns a report with the result of each file. Files that cannot be
+// imported do not fail the import. An error is only returned if the filesystem
+// cannot be read, or if the context is canceled. If the context is canceled,
+// no stacks are added to the gallery, and the uploaded files are deleted.
+func (u *Uploader[StackID, ImageID]) ImportFS(
+	ctx context.Context,
+	g ProcessableGallery[StackID, ImageID],
+	fsys fs.FS,
+	newStackID func() StackID,
+	newImageID func() ImageID,
+	opts ...BulkImportOption,
+) (BulkImportReport[StackID, ImageID], error) {
+	cfg := bulkImportConfig{concurrency: DefaultBulkConcurrency}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.concurrency < 1 {
+		cfg.concurrency = 1
+	}
+
+	paths, err := imagePaths(fsys)
+	if err != nil {
+		return BulkImportReport[StackID, ImageID]{}, err
+	}
+
+	type upload struct {
+		stackID  StackID
+		original gallery.Image[ImageID]
+		sidecar  Sidecar
+		err      error
+	}
+
+	uploads := make([]upload, len(paths))
+	for i := range uploads {
+		uploads[i].stackID = newStackID()
+		uploads[i].original.ID = newImageID()
+	}
+
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(cfg.concurrency)
+	for i := 0; i < cfg.concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				up := &uploads[i]
+				up.original, up.sidecar, up.err = u.importFile(ctx, g, fsys, paths[i], up.stackID, up.original.ID)
+			}
+		}()
+	}
+
+dispatch:
+	for i := range paths {
+		select {
+		case <-ctx.Done():
+			break dispatch
+		case queue <- i:
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		for _, up := range uploads {
+			if up.err == nil && up.original.Storage.Path != "" {
+				u.discard(context.Background(), up.original.Image)
+			}
+		}
+		return BulkImportReport[StackID, ImageID]{}, err
+	}
+
+	var report BulkImportReport[StackID, ImageID]
+	for i, up := range uploads {
+		file := BulkImportFile[StackID, ImageID]{Path: paths[i], Err: up.err}
+		if up.err == nil {
+			file.Stack, file.Err = u.addImported(ctx, g, up.stackID, up.original, up.sidecar)
+		}
+		report.Files = append(report.Files, file)
+	}
+
+	return report, nil
+}
+
+// importFile uploads a single file of a bulk import.
+func (u *Uploader[StackID, ImageID]) importFile(
+	ctx context.Context,
+	g ProcessableGallery[StackID, ImageID],
+	fsys fs.FS,
+	p string,
+	stackID StackID,
+	imageID ImageID,
+) (gallery.Image[ImageID], Sidecar, error) {
+	sidecar, err := readSidecar(fsys, p)
+	if err != nil {
+		return gallery.Image[ImageID]{}, Sidecar{}, err
+	}
+
+	f, err := fsys.Open(p)
+	if err != nil {
+		return gallery.Image[ImageID]{}, Sidecar{}, fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+
+	original, err := u.uploadOriginal(ctx, g, stackID, imageID, f, image.Image{
+		Filename:     path.Base(p),
+		Names:        sidecar.Names,
+		Descriptions: sidecar.Descriptions,
+	})
+	if err != nil {
+		return gallery.Image[ImageID]{}, Sidecar{}, err
+	}
+
+	return original, sidecar, nil
+}
+
+// addImported adds an uploaded image of a bulk import to the gallery.
+func (u *Uploader[StackID, ImageID]) addImported(
+	ctx context.Context,
+	g ProcessableGallery[StackID, ImageID],
+	stackID StackID,
+	original gallery.Image[ImageID],
+	sidecar Sidecar,
+) (gallery.Stack[StackID, ImageID], error) {
+	stack, err := g.NewStack(stackID, original)
+	if err != nil {
+		u.discard(ctx, original.Image)
+		return gallery.Stack[StackID, ImageID]{}, fmt.Errorf("create stack: %w", err)
+	}
+
+	if len(sidecar.Tags) > 0 {
+		if stack, err = g.Tag(stackID, sidecar.Tags...); err != nil {
+			return stack, fmt.Errorf("tag stack: %w", err)
+		}
+	}
+
+	return stack, nil
+}
+
+// imagePaths returns the paths of all images in fsys, in lexical order.
+func imagePaths(fsys fs.FS) ([]string, error) {
+	var paths []string
+	if err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if p != "." && (strings.HasPrefix(d.Name(), ".") || d.Name() == "__MACOSX") {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if d.Type().IsRegular() && strings.HasPrefix(mime.TypeByExtension(path.Ext(p)), "image/") {
+			paths = append(paths, p)
+		}
+
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("read files: %w", err)
+	}
+	return paths, nil
+}
+
+// readSidecar reads the sidecar of the image at the given path. If the image
+// has no sidecar, an empty [Sidecar] is returned.
+func readSidecar(fsys fs.FS, p string) (Sidecar, error) {
+	b, err := fs.ReadFile(fsys, p+SidecarSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Sidecar{}, nil
+	}
+	if err != nil {
+		return Sidecar{}, fmt.Errorf("read sidecar: %w", err)
+	}
+
+	var sidecar Sidecar
+	if err := json.Unmarshal(b, &sidecar); err != nil {
+		return Sidecar{}, fmt.Errorf("decode sidecar: %w", err)
+	}
+
+	return sidecar, nil
+}
@@ -0,0 +1,133 @@
+package esgallery_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+	"github.com/modernice/media-entity/goes/esgallery"
+)
+
+func TestUploader_ImportZip(t *testing.T) {
+	archive := newZip(t, map[string][]byte{
+		"album/a.jpg":      example,
+		"album/a.jpg.json": []byte(`{"names": {"en": "A", "de": "Ä"}, "descriptions": {"en": "Foo"}, "tags": ["summer"]}`),
+		"album/b.jpg":      example,
+		"broken.jpg":       []byte("not an image"),
+		"readme.txt":       []byte("not imported"),
+		".hidden.jpg":      example,
+		"__MACOSX/._a.jpg": example,
+	})
+
+	var storage esgallery.MemoryStorage
+	up := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
+	g := NewTestGallery(uuid.New())
+
+	report, err := up.ImportZip(context.Background(), g, bytes.NewReader(archive), int64(len(archive)), uuid.New, uuid.New, esgallery.BulkConcurrency(2))
+	if err != nil {
+		t.Fatalf("ImportZip() failed: %v", err)
+	}
+
+	var paths []string
+	for _, file := range report.Files {
+		paths = append(paths, file.Path)
+	}
+	if diff := cmp.Diff([]string{"album/a.jpg", "album/b.jpg", "broken.jpg"}, paths); diff != "" {
+		t.Fatalf("report should contain the images in the order of their paths (-want +got):\n%s", diff)
+	}
+
+	if len(report.Succeeded()) != 2 {
+		t.Fatalf("2 files should be imported; got %d", len(report.Succeeded()))
+	}
+
+	if failed := report.Failed(); len(failed) != 1 || !errors.Is(failed[0].Err, esgallery.ErrUnsupportedType) {
+		t.Fatalf("broken.jpg should fail with %q; got %+v", esgallery.ErrUnsupportedType, failed)
+	}
+
+	a := report.Files[0].Stack
+	if a.Original().Filename != "a.jpg" || a.Original().Names["de"] != "Ä" || a.Original().Descriptions["en"] != "Foo" {
+		t.Fatalf("metadata should be read from the sidecar; got %+v", a.Original().Image)
+	}
+	if !a.Tags.Contains("summer") {
+		t.Fatalf("stack should be tagged with the tags of the sidecar; got %v", a.Tags)
+	}
+
+	stacks := g.Stacks
+	if len(stacks) != 2 || stacks[0].ID != report.Files[0].Stack.ID || stacks[1].ID != report.Files[1].Stack.ID {
+		t.Fatalf("stacks should be added to the gallery in the order of their paths")
+	}
+
+	if len(storage.Files()) != 2 {
+		t.Fatalf("storage should contain 2 files; got %d", len(storage.Files()))
+	}
+}
+
+func TestUploader_ImportFS_invalidSidecar(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.jpg":      {Data: example},
+		"a.jpg.json": {Data: []byte("{")},
+	}
+
+	var storage esgallery.MemoryStorage
+	up := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
+	g := NewTestGallery(uuid.New())
+
+	report, err := up.ImportFS(context.Background(), g, fsys, uuid.New, uuid.New)
+	if err != nil {
+		t.Fatalf("ImportFS() failed: %v", err)
+	}
+
+	if len(report.Failed()) != 1 {
+		t.Fatalf("file with an invalid sidecar should fail; got %+v", report.Files)
+	}
+
+	if len(storage.Files()) != 0 || len(g.Stacks) != 0 {
+		t.Fatalf("file with an invalid sidecar should not be imported")
+	}
+}
+
+func TestUploader_ImportFS_canceled(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.jpg": {Data: example},
+		"b.jpg": {Data: example},
+	}
+
+	var storage esgallery.MemoryStorage
+	up := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
+	g := NewTestGallery(uuid.New())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := up.ImportFS(ctx, g, fsys, uuid.New, uuid.New); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ImportFS() should fail with %q; got %v", context.Canceled, err)
+	}
+
+	if len(storage.Files()) != 0 || len(g.Stacks) != 0 {
+		t.Fatalf("canceled import should not add any stacks or files")
+	}
+}
+
+func newZip(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, contents := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatalf("create %q in zip: %v", name, err)
+		}
+		f.Write(contents)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close zip: %v", err)
+	}
+
+	return buf.Bytes()
+}
@@ -22,13 +22,43 @@ const (
 
 // Non-aggregate events
 const (
-	StackProcessed = "esgallery.stack_processed"
+	StackProcessingStarted = "esgallery.stack_processing_started"
+	StackProcessingFailed  = "esgallery.stack_processing_failed"
+	StackProcessed         = "esgallery.stack_processed"
 )
 
+// Events are all events of the [*Gallery] aggregate.
+var Events = []string{
+	StackAdded,
+	StackRemoved,
+	StackCleared,
+	VariantsAdded,
+	VariantAdded,
+	VariantRemoved,
+	VariantReplaced,
+	StackTagged,
+	StackUntagged,
+	Sorted,
+	Cleared,
+	StackProcessingStarted,
+	StackProcessingFailed,
+	StackProcessed,
+}
+
 // ProcessorTriggerEvents are the events that can trigger a [*Processor].
 var ProcessorTriggerEvents = []string{
 	StackAdded,
-	// VariantReplaced,
+	VariantReplaced,
+}
+
+// GarbageEvents are the events that can leave files in storage unreferenced.
+// A [*GarbageCollector] collects files after these events.
+var GarbageEvents = []string{
+	StackRemoved,
+	StackCleared,
+	VariantRemoved,
+	VariantReplaced,
+	Cleared,
 }
 
 type VariantsAddedData[StackID, ImageID ID] struct {
@@ -49,6 +79,11 @@ type VariantRemovedData[StackID, ImageID ID] struct {
 type VariantReplacedData[StackID, ImageID ID] struct {
 	StackID StackID
 	Variant gallery.Image[ImageID]
+
+	// Moved is true if only the storage location of the variant changed,
+	// for example because it was migrated to another storage by a [*Migrator].
+	// Moving the original image does not trigger the post-processor.
+	Moved bool
 }
 
 type StackTaggedData[StackID ID] struct {
@@ -61,6 +96,17 @@ type StackUntaggedData[StackID ID] struct {
 	Tags    gallery.Tags
 }
 
+type StackProcessingStartedData[StackID ID] struct {
+	StackID StackID
+	Attempt int
+}
+
+type StackProcessingFailedData[StackID ID] struct {
+	StackID StackID
+	Reason  string
+	Attempt int
+}
+
 // RegisterEvents registers the [*Gallery] events into an event registry.
 func RegisterEvents[StackID, ImageID ID](r codec.Registerer) {
 	codec.Register[gallery.Stack[StackID, ImageID]](r, StackAdded)
@@ -74,5 +120,7 @@ func RegisterEvents[StackID, ImageID ID](r codec.Registerer) {
 	codec.Register[StackUntaggedData[StackID]](r, StackUntagged)
 	codec.Register[[]StackID](r, Sorted)
 	codec.Register[struct{}](r, Cleared)
+	codec.Register[StackProcessingStartedData[StackID]](r, StackProcessingStarted)
+	codec.Register[StackProcessingFailedData[StackID]](r, StackProcessingFailed)
 	codec.Register[StackID](r, StackProcessed)
 }
@@ -0,0 +1,266 @@
+package esgallery
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/modernice/media-entity/gallery"
+	"github.com/modernice/media-entity/image"
+)
+
+const (
+	// ManifestName is the name of the manifest file in a gallery archive.
+	ManifestName = "manifest.json"
+
+	// ManifestVersion is the current version of the [ExportManifest] format.
+	ManifestVersion = 1
+)
+
+// ErrInvalidArchive is returned by [*Uploader.ImportGallery] if an archive is
+// not a valid gallery archive.
+var ErrInvalidArchive = errors.New("invalid gallery archive")
+
+// ExportManifest is the manifest of a gallery archive that is written by
+// [ExportGallery]. The Storage of each image in the manifest is rewritten to
+// the path of its file in the archive, and has no provider.
+type ExportManifest[StackID, ImageID ID] struct {
+	// Version is the version of the manifest format.
+	Version int `json:"version"`
+
+	// Gallery contains the stacks of the gallery, including the names,
+	// descriptions, and tags of all images, in the order of the gallery.
+	Gallery gallery.DTO[StackID, ImageID] `json:"gallery"`
+}
+
+// ExportGallery writes a self-contained zip archive of the provided gallery to
+// w. The archive contains the files of all variants of all stacks, which are
+// fetched from the provided [Storage], and an [ExportManifest] that is stored
+// as [ManifestName]. Use [*Uploader.ImportGallery] to restore the archive.
+func ExportGallery[StackID, ImageID ID](ctx context.Context, w io.Writer, storage Storage, dto gallery.DTO[StackID, ImageID]) error {
+	archive := zip.NewWriter(w)
+
+	manifest := ExportManifest[StackID, ImageID]{
+		Version: ManifestVersion,
+		Gallery: gallery.DTO[StackID, ImageID]{Stacks: make([]gallery.Stack[StackID, ImageID], len(dto.Stacks))},
+	}
+
+	for i, stack := range dto.Stacks {
+		stack = stack.Clone()
+		for j, variant := range stack.Variants {
+			p := archivePath(stack.ID, variant)
+			if err := exportFile(ctx, archive, storage, variant.Storage, p); err != nil {
+				return fmt.Errorf("stack %v: variant %v: %w", stack.ID, variant.ID, err)
+			}
+			stack.Variants[j].Storage = image.Storage{Path: p}
+			stack.Variants[j].URL = ""
+		}
+		manifest.Gallery.Stacks[i] = stack
+	}
+
+	mw, err := archive.Create(ManifestName)
+	if err != nil {
+		return fmt.Errorf("create manifest: %w", err)
+	}
+
+	enc := json.NewEncoder(mw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return fmt.Errorf("encode manifest: %w", err)
+	}
+
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("close archive: %w", err)
+	}
+
+	return nil
+}
+
+func exportFile(ctx context.Context, archive *zip.Writer, storage Storage, file image.Storage, p string) error {
+	r, err := getFile(ctx, storage, file)
+	if err != nil {
+		return fmt.Errorf("get %q from storage: %w", file.Path, err)
+	}
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	// Images are already compressed.
+	w, err := archive.CreateHeader(&zip.FileHeader{Name: p, Method: zip.Store})
+	if err != nil {
+		return fmt.Errorf("create %q in archive: %w", p, err)
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		return fmt.Errorf("write %q to archive: %w", p, err)
+	}
+
+	return nil
+}
+
+// archivePath returns the path of the file of a variant in a gallery archive.
+func archivePath[StackID, ImageID ID](stackID StackID, variant gallery.Image[ImageID]) string {
+	filename := cleanFilename(variant.Filename)
+	if filename == "" {
+		filename = "image"
+	}
+	return path.Join("files", stackID.String(), variant.ID.String(), filename)
+}
+
+// ImportGallery restores a gallery archive that was written by [ExportGallery]
+// into the provided gallery, which should be a new, empty gallery. The stacks
+// and images keep their ids, names, descriptions, tags, and order. The files
+// are uploaded to the storage of the Uploader, at new storage paths that are
+// determined by the [PathStrategy] of the Uploader. Original images are
+// validated against the [UploadPolicy] of the Uploader.
+//
+// Stacks that were processed by a post-processor keep their processed variants
+// and are marked as processed. A running [*PostProcessor] still processes every
+// added stack, including restored stacks; use [FilterEvents] to skip them.
+//
+// Each stack in the archive must contain an original image, which is restored
+// first. Archives that contain a stack without an original are rejected with
+// [ErrInvalidArchive].
+//
+// If the import fails, the uploaded files are deleted, and the gallery must
+// not be saved.
+func (u *Uploader[StackID, ImageID]) ImportGallery(
+	ctx context.Context,
+	g ProcessableGallery[StackID, ImageID],
+	r io.ReaderAt,
+	size int64,
+) (_ []gallery.Stack[StackID, ImageID], err error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+
+	var manifest ExportManifest[StackID, ImageID]
+	if err := readManifest(archive, &manifest); err != nil {
+		return nil, err
+	}
+
+	var uploaded []image.Image
+	defer func() {
+		if err != nil {
+			for _, img := range uploaded {
+				u.discard(ctx, img)
+			}
+		}
+	}()
+
+	stacks := make([]gallery.Stack[StackID, ImageID], 0, len(manifest.Gallery.Stacks))
+	for _, stack := range manifest.Gallery.Stacks {
+		if len(stack.Variants) == 0 {
+			continue
+		}
+
+		variants, err := originalFirst(stack)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, variant := range variants {
+			img, err := u.importVariant(ctx, g, archive, stack.ID, variant)
+			if err != nil {
+				return nil, fmt.Errorf("stack %v: variant %v: %w", stack.ID, variant.ID, err)
+			}
+			uploaded = append(uploaded, img.Image)
+
+			if i == 0 {
+				_, err = g.NewStack(stack.ID, img)
+			} else {
+				_, err = g.AddVariant(stack.ID, img)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("stack %v: add variant %v: %w", stack.ID, variant.ID, err)
+			}
+		}
+
+		if len(stack.Tags) > 0 {
+			if _, err := g.Tag(stack.ID, stack.Tags...); err != nil {
+				return nil, fmt.Errorf("stack %v: tag stack: %w", stack.ID, err)
+			}
+		}
+
+		if WasProcessed(stack) {
+			g.MarkAsProcessed(stack.ID)
+		}
+
+		restored, _ := g.Stack(stack.ID)
+		stacks = append(stacks, restored)
+	}
+
+	return stacks, nil
+}
+
+// importVariant uploads the file of a variant from a gallery archive.
+func (u *Uploader[StackID, ImageID]) importVariant(
+	ctx context.Context,
+	g ProcessableGallery[StackID, ImageID],
+	archive *zip.Reader,
+	stackID StackID,
+	variant gallery.Image[ImageID],
+) (gallery.Image[ImageID], error) {
+	f, err := archive.Open(variant.Storage.Path)
+	if err != nil {
+		return variant, fmt.Errorf("%w: open %q: %v", ErrInvalidArchive, variant.Storage.Path, err)
+	}
+	defer f.Close()
+
+	var policy *UploadPolicy
+	if variant.Original {
+		policy = &u.cfg.policy
+	}
+
+	storage, info, err := u.upload(ctx, u.path(g, stackID, variant.ID, variant.Filename), f, policy)
+	if err != nil {
+		return variant, err
+	}
+
+	variant.Image = variant.Image.Clone()
+	variant.Storage = storage
+	variant.Filesize = info.size
+	variant.Dimensions = info.dimensions
+	variant.Hash = info.hash
+	variant.Metadata = info.metadata
+
+	return variant, nil
+}
+
+// originalFirst returns the variants of a stack from a gallery archive, with
+// the original image first.
+func originalFirst[StackID, ImageID ID](stack gallery.Stack[StackID, ImageID]) ([]gallery.Image[ImageID], error) {
+	for i, variant := range stack.Variants {
+		if !variant.Original {
+			continue
+		}
+		variants := make([]gallery.Image[ImageID], 0, len(stack.Variants))
+		variants = append(variants, variant)
+		variants = append(variants, stack.Variants[:i]...)
+		return append(variants, stack.Variants[i+1:]...), nil
+	}
+	return nil, fmt.Errorf("%w: stack %v has no original image", ErrInvalidArchive, stack.ID)
+}
+
+func readManifest[StackID, ImageID ID](archive *zip.Reader, manifest *ExportManifest[StackID, ImageID]) error {
+	f, err := archive.Open(ManifestName)
+	if err != nil {
+		return fmt.Errorf("%w: open manifest: %v", ErrInvalidArchive, err)
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(manifest); err != nil {
+		return fmt.Errorf("%w: decode manifest: %v", ErrInvalidArchive, err)
+	}
+
+	if manifest.Version != ManifestVersion {
+		return fmt.Errorf("%w: unsupported manifest version %d", ErrInvalidArchive, manifest.Version)
+	}
+
+	return nil
+}
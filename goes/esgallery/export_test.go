@@ -0,0 +1,163 @@
+package esgallery_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+	"github.com/modernice/media-entity/goes/esgallery"
+)
+
+func TestExportGallery(t *testing.T) {
+	var storage esgallery.MemoryStorage
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
+	g := NewTestGallery(uuid.New())
+
+	a, _ := uploadStackWithVariant(t, uploader, g)
+	b, _ := uploadStackWithVariant(t, uploader, g)
+
+	if _, err := g.Tag(b.ID, "summer", esgallery.ProcessedTag); err != nil {
+		t.Fatalf("tag stack: %v", err)
+	}
+	g.Sort([]uuid.UUID{b.ID, a.ID})
+
+	original := a.Original()
+	original.Names = map[string]string{"en": "Beach"}
+	original.Descriptions = map[string]string{"en": "A sunny beach"}
+	if _, err := g.ReplaceVariant(a.ID, original); err != nil {
+		t.Fatalf("replace original: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := esgallery.ExportGallery(context.Background(), &buf, &storage, g.DTO); err != nil {
+		t.Fatalf("ExportGallery() failed: %v", err)
+	}
+
+	var target esgallery.MemoryStorage
+	importer := esgallery.NewUploader[uuid.UUID, uuid.UUID](&target)
+	restored := NewTestGallery(uuid.New())
+
+	stacks, err := importer.ImportGallery(context.Background(), restored, bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("ImportGallery() failed: %v", err)
+	}
+
+	if diff := cmp.Diff(restored.Stacks, stacks); diff != "" {
+		t.Fatalf("ImportGallery() should return the restored stacks (-want +got):\n%s", diff)
+	}
+
+	if len(restored.Stacks) != 2 || restored.Stacks[0].ID != b.ID || restored.Stacks[1].ID != a.ID {
+		t.Fatalf("stacks should be restored in the order of the gallery")
+	}
+
+	for i, stack := range restored.Stacks {
+		want := g.Stacks[i]
+
+		if diff := cmp.Diff(want.Tags, stack.Tags); diff != "" {
+			t.Fatalf("tags of stack %v should be restored (-want +got):\n%s", stack.ID, diff)
+		}
+
+		if len(stack.Variants) != len(want.Variants) {
+			t.Fatalf("stack %v should have %d variants; has %d", stack.ID, len(want.Variants), len(stack.Variants))
+		}
+
+		for j, variant := range stack.Variants {
+			wantVariant := want.Variants[j]
+			if variant.ID != wantVariant.ID || variant.Original != wantVariant.Original {
+				t.Fatalf("variant #%d of stack %v should be %v; is %v", j, stack.ID, wantVariant.ID, variant.ID)
+			}
+
+			if diff := cmp.Diff(wantVariant.Names, variant.Names); diff != "" {
+				t.Fatalf("names of variant %v should be restored (-want +got):\n%s", variant.ID, diff)
+			}
+
+			if diff := cmp.Diff(wantVariant.Descriptions, variant.Descriptions); diff != "" {
+				t.Fatalf("descriptions of variant %v should be restored (-want +got):\n%s", variant.ID, diff)
+			}
+
+			if variant.Storage.Path == wantVariant.Storage.Path {
+				t.Fatalf("variant %v should be uploaded to a new path; got %q", variant.ID, variant.Storage.Path)
+			}
+
+			if _, err := target.Get(context.Background(), variant.Storage.Path); err != nil {
+				t.Fatalf("variant %v should be in the target storage: %v", variant.ID, err)
+			}
+		}
+	}
+
+	if diff := cmp.Diff([]uuid.UUID{b.ID}, restored.ProcessedStacks()); diff != "" {
+		t.Fatalf("only processed stacks should be marked as processed (-want +got):\n%s", diff)
+	}
+}
+
+func TestUploader_ImportGallery_invalidArchive(t *testing.T) {
+	archive := newZip(t, map[string][]byte{
+		esgallery.ManifestName: []byte(`{"version": 1, "gallery": {"stacks": [{"id": "` + uuid.NewString() + `", "variants": [{"id": "` + uuid.NewString() + `", "original": true, "storage": {"path": "missing.jpg"}}]}]}}`),
+	})
+
+	var storage esgallery.MemoryStorage
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
+	g := NewTestGallery(uuid.New())
+
+	if _, err := uploader.ImportGallery(context.Background(), g, bytes.NewReader(archive), int64(len(archive))); !errors.Is(err, esgallery.ErrInvalidArchive) {
+		t.Fatalf("ImportGallery() should fail with %q; got %v", esgallery.ErrInvalidArchive, err)
+	}
+
+	if len(g.Stacks) != 0 {
+		t.Fatalf("no stacks should be added to the gallery")
+	}
+}
+
+func TestUploader_ImportGallery_originalNotFirst(t *testing.T) {
+	stackID, variantID, originalID := uuid.NewString(), uuid.NewString(), uuid.NewString()
+	archive := newZip(t, map[string][]byte{
+		esgallery.ManifestName: []byte(`{"version": 1, "gallery": {"stacks": [{"id": "` + stackID + `", "variants": [` +
+			`{"id": "` + variantID + `", "storage": {"path": "variant.jpg"}},` +
+			`{"id": "` + originalID + `", "original": true, "storage": {"path": "original.jpg"}}]}]}}`),
+		"variant.jpg":  example,
+		"original.jpg": example,
+	})
+
+	var storage esgallery.MemoryStorage
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
+	g := NewTestGallery(uuid.New())
+
+	stacks, err := uploader.ImportGallery(context.Background(), g, bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatalf("ImportGallery() failed: %v", err)
+	}
+
+	if len(stacks) != 1 || len(stacks[0].Variants) != 2 {
+		t.Fatalf("ImportGallery() should restore 1 stack with 2 variants; got %v", stacks)
+	}
+
+	if original := stacks[0].Original(); original.ID.String() != originalID {
+		t.Fatalf("original of restored stack should be %s; is %s", originalID, original.ID)
+	}
+
+	if _, ok := stacks[0].Variant(uuid.MustParse(variantID)); !ok {
+		t.Fatalf("variant %s should be restored", variantID)
+	}
+}
+
+func TestUploader_ImportGallery_noOriginal(t *testing.T) {
+	archive := newZip(t, map[string][]byte{
+		esgallery.ManifestName: []byte(`{"version": 1, "gallery": {"stacks": [{"id": "` + uuid.NewString() + `", "variants": [{"id": "` + uuid.NewString() + `", "storage": {"path": "variant.jpg"}}]}]}}`),
+		"variant.jpg":          example,
+	})
+
+	var storage esgallery.MemoryStorage
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
+	g := NewTestGallery(uuid.New())
+
+	if _, err := uploader.ImportGallery(context.Background(), g, bytes.NewReader(archive), int64(len(archive))); !errors.Is(err, esgallery.ErrInvalidArchive) {
+		t.Fatalf("ImportGallery() should fail with %q; got %v", esgallery.ErrInvalidArchive, err)
+	}
+
+	if len(g.Stacks) != 0 || len(storage.Files()) != 0 {
+		t.Fatalf("no stacks or files should be added")
+	}
+}
@@ -0,0 +1,260 @@
+package esgallery
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/modernice/media-entity/image"
+)
+
+// DefaultCacheControl is the default Cache-Control header of a [*FileServer].
+const DefaultCacheControl = "public, max-age=3600"
+
+// FileServer is an [http.Handler] that serves files from a [Storage]. The
+// path of the request URL is used as the storage path. Use [http.StripPrefix]
+// to serve files under a path prefix:
+//
+//	http.Handle("/images/", http.StripPrefix("/images/", esgallery.NewFileServer(storage)))
+//
+// If the storage is a [ProviderStorage], the provider of a file can be passed
+// in the "provider" query parameter.
+//
+// FileServer supports conditional requests (If-None-Match, If-Modified-Since)
+// and range requests. If the storage implements [ManagedStorage], the ETag,
+// Last-Modified, and Content-Length headers are determined using Stat, and
+// conditional requests are answered without reading the file. Otherwise, the
+// file is buffered in memory to compute its ETag.
+type FileServer struct {
+	storage      Storage
+	cacheControl string
+}
+
+// FileServerOption is an option for [NewFileServer].
+type FileServerOption func(*FileServer)
+
+// CacheControl returns a [FileServerOption] that sets the Cache-Control header
+// of served files. An empty value omits the header. The default is
+// [DefaultCacheControl].
+func CacheControl(value string) FileServerOption {
+	return func(s *FileServer) {
+		s.cacheControl = value
+	}
+}
+
+// NewFileServer returns a [*FileServer] that serves files from the provided
+// [Storage].
+func NewFileServer(storage Storage, opts ...FileServerOption) *FileServer {
+	s := &FileServer{
+		storage:      storage,
+		cacheControl: DefaultCacheControl,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// ServeHTTP implements [http.Handler].
+func (s *FileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	file := image.Storage{
+		Provider: r.URL.Query().Get("provider"),
+		Path:     strings.TrimPrefix(r.URL.Path, "/"),
+	}
+	s.serveFile(w, r, file, s.cacheControl)
+}
+
+func (s *FileServer) serveFile(w http.ResponseWriter, r *http.Request, file image.Storage, cacheControl string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Paths that could escape the root of the storage are never passed to it.
+	if file.Path == "" || path.IsAbs(file.Path) || checkPath(file.Path) != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	content, info, err := s.open(r.Context(), file)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) || errors.Is(err, ErrUnknownProvider) || errors.Is(err, ErrInvalidPath) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+
+	contentType := mime.TypeByExtension(path.Ext(file.Path))
+	if contentType == "" {
+		contentType = info.ContentType
+	}
+	if contentType == "" {
+		if contentType, err = sniffContentType(content); err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", info.etag)
+	if cacheControl != "" {
+		w.Header().Set("Cache-Control", cacheControl)
+	}
+
+	http.ServeContent(w, r, path.Base(file.Path), info.ModTime, content)
+}
+
+type servedFileInfo struct {
+	FileInfo
+	etag string
+}
+
+// open returns the contents of the file at the given storage location, and
+// information about the file. The contents are only read from storage when
+// they are needed.
+func (s *FileServer) open(ctx context.Context, file image.Storage) (io.ReadSeekCloser, servedFileInfo, error) {
+	if managed, ok := s.storage.(ManagedStorage); ok {
+		info, err := statFile(ctx, managed, file)
+		if err == nil {
+			content := &storageFile{ctx: ctx, storage: s.storage, file: file, size: info.Size}
+			return content, servedFileInfo{
+				FileInfo: info,
+				etag:     fmt.Sprintf(`"%x-%x"`, info.ModTime.UnixNano(), info.Size),
+			}, nil
+		}
+		if !errors.Is(err, ErrNotSupported) {
+			return nil, servedFileInfo{}, err
+		}
+	}
+
+	r, err := getFile(ctx, s.storage, file)
+	if err != nil {
+		return nil, servedFileInfo{}, err
+	}
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, servedFileInfo{}, fmt.Errorf("read file: %w", err)
+	}
+	sum := sha256.Sum256(b)
+
+	return nopSeekCloser{bytes.NewReader(b)}, servedFileInfo{
+		FileInfo: FileInfo{Path: file.Path, Size: int64(len(b))},
+		etag:     `"` + hex.EncodeToString(sum[:16]) + `"`,
+	}, nil
+}
+
+// sniffContentType detects the content type of a file from its first bytes,
+// and rewinds the file.
+func sniffContentType(content io.ReadSeeker) (string, error) {
+	var detect detectContentType
+	if _, err := io.CopyN(&detect, content, 512); err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return detect.ContentType(), nil
+}
+
+// storageFile is a lazy [io.ReadSeekCloser] for a file in storage with a
+// known size. The file is only fetched from storage when it is read. If the
+// file is read from an offset, the fetched contents are seeked to the offset
+// if the storage returns an [io.Seeker], and skipped otherwise.
+type storageFile struct {
+	ctx     context.Context
+	storage Storage
+	file    image.Storage
+	size    int64
+
+	offset int64
+	r      io.Reader
+	pos    int64
+}
+
+func (f *storageFile) Read(p []byte) (int, error) {
+	if f.offset >= f.size {
+		return 0, io.EOF
+	}
+
+	if f.r == nil || f.pos != f.offset {
+		if err := f.fetch(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.r.Read(p)
+	f.pos += int64(n)
+	f.offset = f.pos
+	return n, err
+}
+
+func (f *storageFile) fetch() error {
+	f.Close()
+
+	r, err := getFile(f.ctx, f.storage, f.file)
+	if err != nil {
+		return err
+	}
+	f.r = r
+
+	if seeker, ok := r.(io.Seeker); ok {
+		if _, err := seeker.Seek(f.offset, io.SeekStart); err != nil {
+			return fmt.Errorf("seek file: %w", err)
+		}
+	} else if _, err := io.CopyN(io.Discard, r, f.offset); err != nil {
+		return fmt.Errorf("skip to offset %d: %w", f.offset, err)
+	}
+	f.pos = f.offset
+
+	return nil
+}
+
+func (f *storageFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset %d", offset)
+	}
+	f.offset = offset
+
+	return offset, nil
+}
+
+func (f *storageFile) Close() error {
+	var err error
+	if c, ok := f.r.(io.Closer); ok {
+		err = c.Close()
+	}
+	f.r = nil
+	return err
+}
+
+type nopSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopSeekCloser) Close() error { return nil }
@@ -0,0 +1,169 @@
+package esgallery_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/modernice/media-entity/goes/esgallery"
+	"github.com/modernice/media-entity/image"
+)
+
+func TestFileServer(t *testing.T) {
+	var storage esgallery.MemoryStorage
+	if _, err := storage.Put(context.Background(), "foo/bar.jpg", newExample()); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+
+	server := httptest.NewServer(http.StripPrefix("/images/", esgallery.NewFileServer(&storage)))
+	defer server.Close()
+
+	resp := get(t, server.URL+"/images/foo/bar.jpg")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("file should be served with status %d; got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if !bytes.Equal(body, example) {
+		t.Fatalf("response has wrong contents")
+	}
+
+	if ct := resp.Header.Get("Content-Type"); ct != "image/jpeg" {
+		t.Fatalf("Content-Type should be %q; is %q", "image/jpeg", ct)
+	}
+
+	if cc := resp.Header.Get("Cache-Control"); cc != esgallery.DefaultCacheControl {
+		t.Fatalf("Cache-Control should be %q; is %q", esgallery.DefaultCacheControl, cc)
+	}
+
+	if resp.Header.Get("Last-Modified") == "" {
+		t.Fatalf("Last-Modified header should be set")
+	}
+
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatalf("ETag header should be set")
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/images/foo/bar.jpg", nil)
+	req.Header.Set("If-None-Match", etag)
+	notModified, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET with If-None-Match: %v", err)
+	}
+	defer notModified.Body.Close()
+
+	if notModified.StatusCode != http.StatusNotModified {
+		t.Fatalf("matching ETag should be answered with status %d; got %d", http.StatusNotModified, notModified.StatusCode)
+	}
+}
+
+func TestFileServer_range(t *testing.T) {
+	tests := []struct {
+		name    string
+		storage func(*esgallery.MemoryStorage) esgallery.Storage
+	}{
+		{
+			name:    "managed storage",
+			storage: func(s *esgallery.MemoryStorage) esgallery.Storage { return s },
+		},
+		{
+			name:    "unmanaged storage",
+			storage: func(s *esgallery.MemoryStorage) esgallery.Storage { return unmanagedStorage{s} },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var storage esgallery.MemoryStorage
+			if _, err := storage.Put(context.Background(), "foo.jpg", newExample()); err != nil {
+				t.Fatalf("Put() failed: %v", err)
+			}
+
+			server := httptest.NewServer(esgallery.NewFileServer(tt.storage(&storage)))
+			defer server.Close()
+
+			req, _ := http.NewRequest(http.MethodGet, server.URL+"/foo.jpg", nil)
+			req.Header.Set("Range", "bytes=100-199")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("GET with Range: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusPartialContent {
+				t.Fatalf("range request should be answered with status %d; got %d", http.StatusPartialContent, resp.StatusCode)
+			}
+
+			body, _ := io.ReadAll(resp.Body)
+			if !bytes.Equal(body, example[100:200]) {
+				t.Fatalf("response should contain the requested range")
+			}
+
+			if ct := resp.Header.Get("Content-Type"); ct != "image/jpeg" {
+				t.Fatalf("Content-Type should be %q; is %q", "image/jpeg", ct)
+			}
+		})
+	}
+}
+
+func TestFileServer_errors(t *testing.T) {
+	var storage esgallery.MemoryStorage
+	server := httptest.NewServer(esgallery.NewFileServer(&storage, esgallery.CacheControl("")))
+	defer server.Close()
+
+	if resp := get(t, server.URL+"/foo.jpg"); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("missing file should be answered with status %d; got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	resp, err := http.Post(server.URL+"/foo.jpg", "image/jpeg", newExample())
+	if err != nil {
+		t.Fatalf("POST: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("POST should be answered with status %d; got %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+}
+
+func TestFileServer_traversal(t *testing.T) {
+	var storage recordingStorage
+	server := esgallery.NewFileServer(&storage)
+
+	for _, target := range []string{"/../secret.jpg", "/foo/../../secret.jpg", "//etc/secret.jpg", `/foo\..\..\secret.jpg`} {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("%s should be answered with status %d; got %d", target, http.StatusNotFound, rec.Code)
+		}
+	}
+
+	if len(storage.paths) > 0 {
+		t.Fatalf("invalid paths should not be passed to the storage; got %v", storage.paths)
+	}
+}
+
+// recordingStorage records the requested paths, and serves the example image
+// for every path.
+type recordingStorage struct {
+	paths []string
+}
+
+func (s *recordingStorage) Put(_ context.Context, path string, _ io.Reader) (image.Storage, error) {
+	return image.Storage{Provider: "recording", Path: path}, nil
+}
+
+func (s *recordingStorage) Get(_ context.Context, path string) (io.Reader, error) {
+	s.paths = append(s.paths, path)
+	return newExample(), nil
+}
+
+// unmanagedStorage hides the [esgallery.ManagedStorage] methods of a storage.
+type unmanagedStorage struct {
+	esgallery.Storage
+}
@@ -0,0 +1,189 @@
+package esgallery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/modernice/media-entity/image"
+)
+
+var _ ManagedStorage = (*FileStorage)(nil)
+
+// FileStorage is a [Storage] that stores images in a directory of the local
+// filesystem. Files are written to a temporary file first, and then renamed
+// to their final path, so that readers never see partially written files.
+// The provider name of stored images is "fs".
+type FileStorage struct {
+	root string
+	mode fs.FileMode
+}
+
+// DefaultFileMode is the default permission mode of files that are written by
+// a [*FileStorage]. Stored files are readable by everyone, so that they can be
+// served by a separately running web server.
+const DefaultFileMode fs.FileMode = 0o644
+
+// FileStorageOption is an option for [NewFileStorage].
+type FileStorageOption func(*FileStorage)
+
+// FileMode returns a [FileStorageOption] that sets the permission mode of
+// stored files. The default is [DefaultFileMode].
+func FileMode(mode fs.FileMode) FileStorageOption {
+	return func(s *FileStorage) {
+		s.mode = mode
+	}
+}
+
+// NewFileStorage returns a [*FileStorage] that stores images under the given
+// root directory. The root directory is created when the first file is written.
+func NewFileStorage(root string, opts ...FileStorageOption) *FileStorage {
+	s := &FileStorage{root: root, mode: DefaultFileMode}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Root returns the root directory of the storage.
+func (s *FileStorage) Root() string {
+	return s.root
+}
+
+// Put implements [Storage].
+func (s *FileStorage) Put(_ context.Context, p string, contents io.Reader) (image.Storage, error) {
+	joined, err := s.join(p)
+	if err != nil {
+		return image.Storage{}, err
+	}
+
+	dir := filepath.Dir(joined)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return image.Storage{}, fmt.Errorf("create directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(joined)+".*.tmp")
+	if err != nil {
+		return image.Storage{}, fmt.Errorf("create temporary file: %w", err)
+	}
+
+	// Remove the temporary file if anything goes wrong. After a successful
+	// rename, the temporary file does not exist anymore.
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, contents); err != nil {
+		tmp.Close()
+		return image.Storage{}, fmt.Errorf("write file: %w", err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return image.Storage{}, fmt.Errorf("sync file: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return image.Storage{}, fmt.Errorf("close file: %w", err)
+	}
+
+	// Temporary files are created with mode 0600.
+	if err := os.Chmod(tmp.Name(), s.mode); err != nil {
+		return image.Storage{}, fmt.Errorf("change file mode: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), joined); err != nil {
+		return image.Storage{}, fmt.Errorf("rename temporary file: %w", err)
+	}
+
+	return image.Storage{
+		Provider: "fs",
+		Path:     p,
+	}, nil
+}
+
+// Get implements [Storage]. The returned reader is an [*os.File] that must be
+// closed by the caller.
+func (s *FileStorage) Get(_ context.Context, p string) (io.Reader, error) {
+	joined, err := s.join(p)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(joined)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %q [provider=fs]", ErrNotFound, p)
+		}
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Delete implements [ManagedStorage].
+func (s *FileStorage) Delete(_ context.Context, p string) error {
+	joined, err := s.join(p)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(joined); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// Stat implements [ManagedStorage]. If the file extension is unknown, the
+// content type is detected from the first 512 bytes of the file.
+func (s *FileStorage) Stat(_ context.Context, p string) (FileInfo, error) {
+	joined, err := s.join(p)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	f, err := os.Open(joined)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return FileInfo{}, fmt.Errorf("%w: %q [provider=fs]", ErrNotFound, p)
+		}
+		return FileInfo{}, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return FileInfo{}, fmt.Errorf("read file: %w", err)
+	}
+
+	return FileInfo{
+		Path:        p,
+		Size:        stat.Size(),
+		ContentType: contentTypeOf(p, head[:n]),
+		ModTime:     stat.ModTime(),
+	}, nil
+}
+
+// join returns the filesystem path of the given storage path. Storage paths
+// that contain ".." segments are rejected.
+func (s *FileStorage) join(p string) (string, error) {
+	if p == "" {
+		return "", fmt.Errorf("%w: empty path", ErrInvalidPath)
+	}
+
+	if err := checkPath(p); err != nil {
+		return "", err
+	}
+
+	return filepath.Join(s.root, filepath.FromSlash(path.Clean("/"+p))), nil
+}
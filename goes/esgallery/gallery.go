@@ -1,6 +1,8 @@
 package esgallery
 
 import (
+	"fmt"
+
 	"github.com/modernice/goes/aggregate"
 	"github.com/modernice/goes/command"
 	"github.com/modernice/goes/event"
@@ -43,15 +45,131 @@ type Gallery[StackID, ImageID ID, T Target] struct {
 
 	target          T
 	processedStacks []StackID
+	processing      map[StackID]ProcessingStatus
+}
+
+// ProcessingState is the post-processing state of a [gallery.Stack].
+type ProcessingState string
+
+// Processing states
+const (
+	// Unprocessed is the state of a [gallery.Stack] that was not processed yet,
+	// or whose original image was replaced since it was processed.
+	Unprocessed = ProcessingState("")
+
+	// Processing is the state of a [gallery.Stack] that is currently being
+	// processed by a post-processor.
+	Processing = ProcessingState("processing")
+
+	// ProcessingFailed is the state of a [gallery.Stack] whose last processing
+	// attempt failed.
+	ProcessingFailed = ProcessingState("failed")
+
+	// Processed is the state of a [gallery.Stack] that was processed successfully.
+	Processed = ProcessingState("processed")
+)
+
+// ProcessingStatus is the post-processing status of a [gallery.Stack].
+type ProcessingStatus struct {
+	State ProcessingState `json:"state"`
+
+	// Attempt is the number of the current or last processing attempt,
+	// starting at 1. Attempt is 0 if the state was not reported by a retrying
+	// post-processor.
+	Attempt int `json:"attempt"`
+
+	// Reason is the error message of the last failed attempt. Reason is only
+	// set if State is ProcessingFailed.
+	Reason string `json:"reason,omitempty"`
+}
+
+// ProcessingStatus returns the post-processing status of the given [gallery.Stack].
+func (g *Gallery[StackID, ImageID, T]) ProcessingStatus(stackID StackID) ProcessingStatus {
+	if status, ok := g.processing[stackID]; ok {
+		return status
+	}
+	if slices.Contains(g.processedStacks, stackID) {
+		return ProcessingStatus{State: Processed}
+	}
+	return ProcessingStatus{State: Unprocessed}
+}
+
+// DTO is the JSON representation of a [*Gallery] for clients. It provides the
+// same fields as [gallery.DTO], and the [ProcessingStatus] of each stack, so
+// that clients can show which stacks are still being processed, or failed.
+type DTO[StackID, ImageID ID] struct {
+	Stacks []StackDTO[StackID, ImageID] `json:"stacks"`
+}
+
+// StackDTO is a [gallery.Stack] with its [ProcessingStatus].
+type StackDTO[StackID, ImageID ID] struct {
+	gallery.Stack[StackID, ImageID]
+
+	Processing ProcessingStatus `json:"processing"`
+}
+
+// StatusDTO returns the [DTO] of the gallery, including the processing status
+// of each stack.
+func (g *Gallery[StackID, ImageID, T]) StatusDTO() DTO[StackID, ImageID] {
+	out := DTO[StackID, ImageID]{Stacks: make([]StackDTO[StackID, ImageID], len(g.Stacks))}
+	for i, stack := range g.Stacks {
+		out.Stacks[i] = StackDTO[StackID, ImageID]{
+			Stack:      stack.Clone(),
+			Processing: g.ProcessingStatus(stack.ID),
+		}
+	}
+	return out
+}
+
+// ResolveURLs returns a deep-copy of the DTO with the URLs of all images
+// resolved by the provided [image.URLResolver].
+func (dto DTO[StackID, ImageID]) ResolveURLs(r image.URLResolver) (DTO[StackID, ImageID], error) {
+	out := DTO[StackID, ImageID]{Stacks: make([]StackDTO[StackID, ImageID], len(dto.Stacks))}
+	for i, stack := range dto.Stacks {
+		resolved, err := stack.Stack.ResolveURLs(r)
+		if err != nil {
+			return dto, fmt.Errorf("stack %v: %w", stack.ID, err)
+		}
+		out.Stacks[i] = StackDTO[StackID, ImageID]{Stack: resolved, Processing: stack.Processing}
+	}
+	return out, nil
 }
 
-// ProcessedStacks returns ids of the stacks that have been processed by a post-processor.
+// ProcessedStacks returns ids of the stacks that have been processed by a
+// post-processor. When the original image of a processed stack is replaced by
+// a different file, the stack is removed from the returned ids until it is
+// processed again.
 func (g *Gallery[StackID, ImageID, T]) ProcessedStacks() []StackID {
 	out := make([]StackID, len(g.processedStacks))
 	copy(out, g.processedStacks)
 	return out
 }
 
+// StackIDs returns the ids of all [gallery.Stack]s in the gallery, in order.
+func (g *Gallery[StackID, ImageID, T]) StackIDs() []StackID {
+	out := make([]StackID, len(g.Stacks))
+	for i, stack := range g.Stacks {
+		out[i] = stack.ID
+	}
+	return out
+}
+
+// StorageFiles returns the storage locations of all images in the gallery.
+// A storage location that is referenced by multiple images (for example, a
+// content-addressed file that was uploaded multiple times) is returned once for
+// every image.
+func (g *Gallery[StackID, ImageID, T]) StorageFiles() []image.Storage {
+	var out []image.Storage
+	for _, stack := range g.Stacks {
+		for _, variant := range stack.Variants {
+			if variant.Storage.Path != "" {
+				out = append(out, variant.Storage)
+			}
+		}
+	}
+	return out
+}
+
 // New returns a new [*Gallery] that applies events and commands to the provided
 // target aggregate. Typically, the target aggregate should embed [*Gallery] and
 // initialize it within its constructor.
@@ -83,6 +201,8 @@ func New[StackID, ImageID ID, T Target](target T) *Gallery[StackID, ImageID, T]
 	event.ApplyWith(target, g.untag, StackUntagged)
 	event.ApplyWith(target, g.sort, Sorted)
 	event.ApplyWith(target, g.clear, Cleared)
+	event.ApplyWith(target, g.stackProcessingStarted, StackProcessingStarted)
+	event.ApplyWith(target, g.stackProcessingFailed, StackProcessingFailed)
 	event.ApplyWith(target, g.stackProcessed, StackProcessed)
 
 	command.ApplyWith(target, func(load addStack[StackID, ImageID]) error {
@@ -182,6 +302,7 @@ func (g *Gallery[StackID, ImageID, Target]) RemoveStack(id StackID) (gallery.Sta
 
 func (g *Gallery[StackID, ImageID, Target]) removeStack(evt event.Of[StackID]) {
 	g.Base.RemoveStack(evt.Data())
+	g.unmarkProcessed(evt.Data())
 }
 
 // ClearStacks removes all variants from a [gallery.Stack] except the original.
@@ -347,8 +468,49 @@ func (g *Gallery[StackID, ImageID, Target]) ReplaceVariant(stackID StackID, vari
 	return stack, nil
 }
 
+// MoveVariant replaces the storage location of a variant, without changing
+// the file itself. MoveVariant raises a [VariantReplaced] event that is marked
+// as Moved, which does not trigger the post-processor, even if the original
+// image was moved.
+func (g *Gallery[StackID, ImageID, Target]) MoveVariant(stackID StackID, variantID ImageID, storage image.Storage) (gallery.Stack[StackID, ImageID], error) {
+	stack, ok := g.Stack(stackID)
+	if !ok {
+		return stack, gallery.ErrStackNotFound
+	}
+
+	variant, ok := stack.Variant(variantID)
+	if !ok {
+		return stack, gallery.ErrVariantNotFound
+	}
+	variant.Storage = storage
+
+	if err := g.DryRun(func(g *gallery.Base[StackID, ImageID]) error {
+		var err error
+		stack, err = g.ReplaceVariant(stackID, variant)
+		return err
+	}); err != nil {
+		return stack, err
+	}
+
+	aggregate.Next(g.target, VariantReplaced, VariantReplacedData[StackID, ImageID]{
+		StackID: stackID,
+		Variant: variant,
+		Moved:   true,
+	})
+
+	return stack, nil
+}
+
 func (g *Gallery[StackID, ImageID, Target]) replaceVariant(evt event.Of[VariantReplacedData[StackID, ImageID]]) {
 	data := evt.Data()
+
+	// If the file of the original image changes, the stack must be processed again.
+	if stack, ok := g.Stack(data.StackID); ok && !data.Moved {
+		if old, ok := stack.Variant(data.Variant.ID); ok && (old.Original || data.Variant.Original) && fileChanged(old.Image, data.Variant.Image) {
+			g.unmarkProcessed(data.StackID)
+		}
+	}
+
 	g.Base.ReplaceVariant(data.StackID, data.Variant)
 }
 
@@ -428,15 +590,90 @@ func (g *Gallery[StackID, ImageID, T]) Clear() {
 
 func (g *Gallery[StackID, ImageID, T]) clear(event.Of[struct{}]) {
 	g.Base.Clear()
+	g.processedStacks = nil
+	g.processing = nil
+}
+
+// MarkAsProcessing marks a [gallery.Stack] as currently being processed by a
+// post-processor. The provided attempt is the number of the processing attempt,
+// starting at 1. If the gallery does not contain the [gallery.Stack], no event
+// is raised.
+func (g *Gallery[StackID, ImageID, T]) MarkAsProcessing(stackID StackID, attempt int) {
+	if _, ok := g.Stack(stackID); !ok {
+		return
+	}
+
+	aggregate.Next(g.target, StackProcessingStarted, StackProcessingStartedData[StackID]{
+		StackID: stackID,
+		Attempt: attempt,
+	})
+}
+
+func (g *Gallery[StackID, ImageID, T]) stackProcessingStarted(evt event.Of[StackProcessingStartedData[StackID]]) {
+	data := evt.Data()
+	g.setProcessingStatus(data.StackID, ProcessingStatus{
+		State:   Processing,
+		Attempt: data.Attempt,
+	})
+}
+
+// MarkAsFailed marks the processing of a [gallery.Stack] as failed. The
+// provided reason should describe the error of the failed attempt. If the
+// gallery does not contain the [gallery.Stack], no event is raised.
+func (g *Gallery[StackID, ImageID, T]) MarkAsFailed(stackID StackID, reason string, attempt int) {
+	if _, ok := g.Stack(stackID); !ok {
+		return
+	}
+
+	aggregate.Next(g.target, StackProcessingFailed, StackProcessingFailedData[StackID]{
+		StackID: stackID,
+		Reason:  reason,
+		Attempt: attempt,
+	})
+}
+
+func (g *Gallery[StackID, ImageID, T]) stackProcessingFailed(evt event.Of[StackProcessingFailedData[StackID]]) {
+	data := evt.Data()
+	g.setProcessingStatus(data.StackID, ProcessingStatus{
+		State:   ProcessingFailed,
+		Attempt: data.Attempt,
+		Reason:  data.Reason,
+	})
 }
 
 // MarkAsProcessed marks a [gallery.Stack] as being processed by a post-processor.
 func (g *Gallery[StackID, ImageID, T]) MarkAsProcessed(stackID StackID) {
-	if !slices.Contains(g.ProcessedStacks(), stackID) {
+	if g.ProcessingStatus(stackID).State != Processed {
 		aggregate.Next(g.target, StackProcessed, stackID)
 	}
 }
 
 func (g *Gallery[StackID, ImageID, T]) stackProcessed(evt event.Of[StackID]) {
-	g.processedStacks = append(g.processedStacks, evt.Data())
+	if !slices.Contains(g.processedStacks, evt.Data()) {
+		g.processedStacks = append(g.processedStacks, evt.Data())
+	}
+	delete(g.processing, evt.Data())
+}
+
+func (g *Gallery[StackID, ImageID, T]) unmarkProcessed(stackID StackID) {
+	g.processedStacks = slicex.Filter(g.processedStacks, func(id StackID) bool {
+		return id != stackID
+	})
+	delete(g.processing, stackID)
+}
+
+func (g *Gallery[StackID, ImageID, T]) setProcessingStatus(stackID StackID, status ProcessingStatus) {
+	if g.processing == nil {
+		g.processing = make(map[StackID]ProcessingStatus)
+	}
+	g.processing[stackID] = status
+}
+
+// fileChanged returns whether a and b refer to different files. Changes to
+// names, descriptions, and tags are not considered.
+func fileChanged(a, b image.Image) bool {
+	return a.Storage != b.Storage ||
+		a.Filename != b.Filename ||
+		a.Filesize != b.Filesize ||
+		a.Dimensions != b.Dimensions
 }
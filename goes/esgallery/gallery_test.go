@@ -1,6 +1,7 @@
 package esgallery_test
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/google/uuid"
@@ -271,3 +272,97 @@ func TestGallery_Clear(t *testing.T) {
 
 	test.Change(t, g, esgallery.Cleared)
 }
+
+func TestGallery_ProcessingStatus(t *testing.T) {
+	g := NewTestGallery(uuid.New())
+
+	stack, _ := g.NewStack(uuid.New(), galleryx.NewImage(uuid.New()))
+
+	testcmp.Equal(t, "fresh stack should be unprocessed", esgallery.ProcessingStatus{State: esgallery.Unprocessed}, g.ProcessingStatus(stack.ID))
+
+	g.MarkAsProcessing(stack.ID, 1)
+
+	testcmp.Equal(t, "stack should be processing", esgallery.ProcessingStatus{
+		State:   esgallery.Processing,
+		Attempt: 1,
+	}, g.ProcessingStatus(stack.ID))
+
+	test.Change(t, g, esgallery.StackProcessingStarted, test.EventData(esgallery.StackProcessingStartedData[uuid.UUID]{
+		StackID: stack.ID,
+		Attempt: 1,
+	}))
+
+	g.MarkAsFailed(stack.ID, "storage unavailable", 1)
+
+	testcmp.Equal(t, "stack processing should have failed", esgallery.ProcessingStatus{
+		State:   esgallery.ProcessingFailed,
+		Attempt: 1,
+		Reason:  "storage unavailable",
+	}, g.ProcessingStatus(stack.ID))
+
+	test.Change(t, g, esgallery.StackProcessingFailed, test.EventData(esgallery.StackProcessingFailedData[uuid.UUID]{
+		StackID: stack.ID,
+		Reason:  "storage unavailable",
+		Attempt: 1,
+	}))
+
+	g.MarkAsProcessing(stack.ID, 2)
+	g.MarkAsProcessed(stack.ID)
+
+	testcmp.Equal(t, "stack should be processed", esgallery.ProcessingStatus{State: esgallery.Processed}, g.ProcessingStatus(stack.ID))
+
+	test.Change(t, g, esgallery.StackProcessed, test.EventData(stack.ID))
+
+	// Replacing the original image resets the status.
+	replacement := stack.Original()
+	replacement.Filesize++
+	g.ReplaceVariant(stack.ID, replacement)
+
+	testcmp.Equal(t, "stack with replaced original should be unprocessed", esgallery.ProcessingStatus{State: esgallery.Unprocessed}, g.ProcessingStatus(stack.ID))
+}
+
+func TestGallery_StatusDTO(t *testing.T) {
+	g := NewTestGallery(uuid.New())
+
+	a, _ := g.NewStack(uuid.New(), galleryx.NewImage(uuid.New()))
+	b, _ := g.NewStack(uuid.New(), galleryx.NewImage(uuid.New()))
+
+	g.MarkAsFailed(b.ID, "storage unavailable", 2)
+
+	data, err := json.Marshal(g.StatusDTO())
+	if err != nil {
+		t.Fatalf("marshal dto: %v", err)
+	}
+
+	var dto struct {
+		Stacks []struct {
+			ID         uuid.UUID                  `json:"id"`
+			Processing esgallery.ProcessingStatus `json:"processing"`
+		} `json:"stacks"`
+	}
+	if err := json.Unmarshal(data, &dto); err != nil {
+		t.Fatalf("unmarshal dto: %v", err)
+	}
+
+	if len(dto.Stacks) != 2 || dto.Stacks[0].ID != a.ID || dto.Stacks[1].ID != b.ID {
+		t.Fatalf("dto should contain the stacks of the gallery in order; got %+v", dto.Stacks)
+	}
+
+	testcmp.Equal(t, "first stack should be unprocessed", esgallery.ProcessingStatus{State: esgallery.Unprocessed}, dto.Stacks[0].Processing)
+	testcmp.Equal(t, "second stack should have failed", esgallery.ProcessingStatus{
+		State:   esgallery.ProcessingFailed,
+		Attempt: 2,
+		Reason:  "storage unavailable",
+	}, dto.Stacks[1].Processing)
+}
+
+func TestGallery_MarkAsProcessing_stackNotFound(t *testing.T) {
+	g := NewTestGallery(uuid.New())
+
+	g.MarkAsProcessing(uuid.New(), 1)
+	g.MarkAsFailed(uuid.New(), "foo", 1)
+
+	if len(g.AggregateChanges()) != 0 {
+		t.Fatalf("no events should be raised for unknown stacks; got %d", len(g.AggregateChanges()))
+	}
+}
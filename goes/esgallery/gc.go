@@ -0,0 +1,639 @@
+package esgallery
+
+import (
+	"container/heap"
+	"container/list"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/modernice/goes/aggregate"
+	"github.com/modernice/goes/event"
+	"github.com/modernice/goes/helper/pick"
+	"github.com/modernice/goes/helper/streams"
+	"github.com/modernice/media-entity/image"
+	"golang.org/x/exp/slices"
+)
+
+// CollectableGallery is a gallery whose unreferenced files can be deleted by a
+// [*GarbageCollector]. [*Gallery] implements CollectableGallery.
+type CollectableGallery interface {
+	pick.AggregateProvider
+
+	// StorageFiles returns the storage locations of all images in the gallery,
+	// once for every image that references a location.
+	StorageFiles() []image.Storage
+}
+
+// GalleryFetcher fetches gallery aggregates from an event store.
+// [*repository.TypedRepository] implements GalleryFetcher.
+type GalleryFetcher[Gallery any] interface {
+	// Fetch fetches the current version of a gallery.
+	Fetch(context.Context, uuid.UUID) (Gallery, error)
+
+	// FetchVersion fetches a gallery at the given version.
+	FetchVersion(context.Context, uuid.UUID, int) (Gallery, error)
+}
+
+// GarbageCollector deletes files from storage that are no longer referenced by
+// their gallery. The garbage collector subscribes to the [Events] of galleries
+// and keeps the latest state of recently changed galleries in memory, so that
+// every event is applied only once. The number of cached galleries is limited
+// by the [CacheGalleries] option. For each of the [GarbageEvents], it compares the files of
+// the gallery before and after the event was applied. Files that were
+// referenced before, but not after the event, are deleted from the
+// [ManagedStorage]. Events that are received out of order are buffered until
+// the previous events of the gallery are received. If they are not received
+// within a second, the gallery is fetched from the event store instead.
+//
+// Deletion can be delayed using the [GracePeriod] option. Pending deletions are
+// scheduled in a single queue. When the grace period has passed, the garbage
+// collector fetches the current version of the gallery and only deletes the
+// file if it is still unreferenced. Use the [DryRun] option to only report
+// unreferenced files without deleting them.
+//
+// Files that are stored at content-addressed paths may be referenced by
+// multiple galleries. Pass the [ReferenceCounter] of the [*Uploader] using the
+// [References] option, so that such files are only deleted when their last
+// reference is removed. The garbage collector is the only component that
+// removes references from the ReferenceCounter. Without the References option,
+// content-addressed files are never deleted.
+//
+// Deletions that are pending when the garbage collector is stopped are
+// discarded, and the files remain in storage.
+type GarbageCollector[Gallery CollectableGallery] struct {
+	storage   ManagedStorage
+	bus       event.Bus
+	galleries GalleryFetcher[Gallery]
+}
+
+// Garbage is a file that is no longer referenced by its gallery.
+type Garbage struct {
+	// Gallery is the gallery aggregate that referenced the file.
+	Gallery aggregate.Ref
+
+	// Storage is the storage location of the file.
+	Storage image.Storage
+
+	// Trigger is the event that removed the last reference to the file.
+	Trigger event.Event
+
+	// Deleted reports whether the file was deleted from storage. Deleted is
+	// always false if the garbage collector runs in dry-run mode.
+	Deleted bool
+}
+
+// NewGarbageCollector returns a new garbage collector for gallery images.
+// Read the documentation of [GarbageCollector] for more information.
+func NewGarbageCollector[Gallery CollectableGallery](
+	storage ManagedStorage,
+	bus event.Bus,
+	galleries GalleryFetcher[Gallery],
+) *GarbageCollector[Gallery] {
+	return &GarbageCollector[Gallery]{
+		storage:   storage,
+		bus:       bus,
+		galleries: galleries,
+	}
+}
+
+// RunGCOption is an option for [*GarbageCollector.Run].
+type RunGCOption func(*runGCConfig)
+
+type runGCConfig struct {
+	gracePeriod time.Duration
+	dryRun      bool
+	refs        ReferenceCounter
+	cacheSize   int
+}
+
+// DefaultGCCacheSize is the default number of galleries whose latest state is
+// cached by a [*GarbageCollector].
+const DefaultGCCacheSize = 1000
+
+// CacheGalleries returns a [RunGCOption] that limits the number of galleries
+// whose latest state is cached in memory to n. When the limit is exceeded, the
+// state of the least recently changed gallery is dropped, and the gallery is
+// fetched from the event store again when its next event is received.
+// Galleries that wait for missing events are not dropped. If n is zero or
+// negative, [DefaultGCCacheSize] is used.
+func CacheGalleries(n int) RunGCOption {
+	return func(cfg *runGCConfig) {
+		cfg.cacheSize = n
+	}
+}
+
+// GracePeriod returns a [RunGCOption] that delays the deletion of unreferenced
+// files by the given duration, measured from the time of the event that
+// removed the last reference.
+func GracePeriod(d time.Duration) RunGCOption {
+	return func(cfg *runGCConfig) {
+		cfg.gracePeriod = d
+	}
+}
+
+// DryRun returns a [RunGCOption] that disables the deletion of files. The
+// garbage collector still reports the unreferenced files as [Garbage].
+func DryRun(dryRun bool) RunGCOption {
+	return func(cfg *runGCConfig) {
+		cfg.dryRun = dryRun
+	}
+}
+
+// References returns a [RunGCOption] that removes the references of collected
+// files from the provided [ReferenceCounter]. Files that are tracked by the
+// ReferenceCounter are only deleted when they have no references left. In
+// dry-run mode, references are not removed.
+func References(refs ReferenceCounter) RunGCOption {
+	return func(cfg *runGCConfig) {
+		cfg.refs = refs
+	}
+}
+
+// Run runs the garbage collector in the background and returns a channel of
+// collected [Garbage] and a channel of errors. Both channels must be received
+// from. Garbage collection stops when the provided Context is canceled. If
+// the underlying event bus fails to subscribe to the [Events], nil
+// channels and the event bus error are returned.
+func (gc *GarbageCollector[Gallery]) Run(ctx context.Context, opts ...RunGCOption) (<-chan Garbage, <-chan error, error) {
+	var cfg runGCConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.cacheSize <= 0 {
+		cfg.cacheSize = DefaultGCCacheSize
+	}
+
+	events, errs, err := gc.bus.Subscribe(ctx, Events...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("subscribe to %v events: %w", Events, err)
+	}
+
+	out := make(chan Garbage)
+	gcErrs := make(chan error)
+
+	run := gcRun[Gallery]{
+		ctx:       ctx,
+		cfg:       cfg,
+		gc:        gc,
+		out:       out,
+		errs:      gcErrs,
+		galleries: make(map[uuid.UUID]*galleryState[Gallery]),
+		recent:    list.New(),
+		gaps:      make(map[uuid.UUID]struct{}),
+	}
+
+	go run.run(events)
+
+	return out, streams.FanInAll(errs, gcErrs), nil
+}
+
+// gapTimeout is how long the garbage collector waits for missing events of a
+// gallery, which may be delivered out of order, before it fetches the gallery
+// from the event store.
+const gapTimeout = time.Second
+
+type gcRun[Gallery CollectableGallery] struct {
+	ctx  context.Context
+	cfg  runGCConfig
+	gc   *GarbageCollector[Gallery]
+	out  chan<- Garbage
+	errs chan<- error
+
+	// galleries are the latest known states of the cached galleries, by id.
+	galleries map[uuid.UUID]*galleryState[Gallery]
+
+	// recent are the ids of the cached galleries, most recently changed first.
+	recent *list.List
+
+	// gaps are the galleries that wait for missing events.
+	gaps map[uuid.UUID]struct{}
+
+	// pending are the scheduled deletions, ordered by their due time.
+	pending deletionQueue
+}
+
+// galleryState is the latest known state of a gallery.
+type galleryState[Gallery CollectableGallery] struct {
+	gallery Gallery
+
+	// elem is the element of the gallery in gcRun.recent.
+	elem *list.Element
+
+	// buffered are the received events that cannot be applied yet, because
+	// previous events are missing, by version.
+	buffered map[int]event.Event
+
+	// deadline is the time until which the missing events are waited for.
+	deadline time.Time
+}
+
+func (r *gcRun[Gallery]) run(events <-chan event.Event) {
+	defer close(r.errs)
+	defer close(r.out)
+
+	for events != nil || len(r.pending) > 0 || len(r.gaps) > 0 {
+		var (
+			timer *time.Timer
+			wake  <-chan time.Time
+		)
+		if next, ok := r.next(); ok {
+			timer = time.NewTimer(time.Until(next))
+			wake = timer.C
+		}
+
+		select {
+		case <-r.ctx.Done():
+			return
+		case evt, ok := <-events:
+			if !ok {
+				events = nil
+				break
+			}
+			r.handle(evt)
+		case <-wake:
+			r.fillGaps()
+			r.collectDue()
+		}
+		r.evict()
+
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// next returns the time of the next scheduled deletion or gap deadline.
+func (r *gcRun[Gallery]) next() (time.Time, bool) {
+	var next time.Time
+	if len(r.pending) > 0 {
+		next = r.pending[0].due
+	}
+	for id := range r.gaps {
+		if deadline := r.galleries[id].deadline; next.IsZero() || deadline.Before(next) {
+			next = deadline
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// handle applies the given event to the latest known state of its gallery.
+// Events that are received before previous events of the gallery are buffered
+// until the previous events are received.
+func (r *gcRun[Gallery]) handle(evt event.Event) {
+	id, _, version := evt.Aggregate()
+
+	state, ok := r.galleries[id]
+	if ok {
+		r.recent.MoveToFront(state.elem)
+	} else {
+		g, err := r.gc.galleries.FetchVersion(r.ctx, id, version-1)
+		if err != nil {
+			r.fail(fmt.Errorf("fetch gallery at version %d [id=%s]: %w", version-1, id, err))
+			return
+		}
+		state = &galleryState[Gallery]{gallery: g, elem: r.recent.PushFront(id)}
+		r.galleries[id] = state
+	}
+
+	current := pick.AggregateVersion(state.gallery)
+
+	switch {
+	case version <= current:
+		// The event was skipped when the gallery was fetched after a gap.
+		r.handleSkipped(evt)
+	case version > current+1:
+		if state.buffered == nil {
+			state.buffered = make(map[int]event.Event)
+			state.deadline = time.Now().Add(gapTimeout)
+			r.gaps[id] = struct{}{}
+		}
+		state.buffered[version] = evt
+	default:
+		r.advance(state, evt)
+	}
+}
+
+// advance applies the given event and the buffered events that follow it.
+func (r *gcRun[Gallery]) advance(state *galleryState[Gallery], evt event.Event) {
+	for {
+		if !r.step(state, evt) {
+			return
+		}
+
+		next, ok := state.buffered[pick.AggregateVersion(state.gallery)+1]
+		if !ok {
+			break
+		}
+		delete(state.buffered, pick.AggregateVersion(next))
+		evt = next
+	}
+
+	if len(state.buffered) == 0 {
+		state.buffered = nil
+		delete(r.gaps, pick.AggregateID(state.gallery))
+	}
+}
+
+// step applies a single event to the gallery, and schedules the deletion of
+// the files whose references were removed by the event. Only [GarbageEvents]
+// can remove references.
+func (r *gcRun[Gallery]) step(state *galleryState[Gallery], evt event.Event) bool {
+	collectable := slices.Contains(GarbageEvents, evt.Name())
+
+	var before []image.Storage
+	if collectable {
+		before = state.gallery.StorageFiles()
+	}
+
+	after, err := r.apply(state.gallery, evt)
+	if err != nil {
+		id, _, version := evt.Aggregate()
+		r.forget(id)
+		r.fail(fmt.Errorf("fetch gallery at version %d [id=%s]: %w", version, id, err))
+		return false
+	}
+	state.gallery = after
+
+	if collectable {
+		r.schedule(evt, r.removed(before, after.StorageFiles()))
+	}
+
+	return true
+}
+
+// apply applies the given event to the gallery. If the gallery cannot apply the
+// event, the gallery is fetched at the version of the event instead.
+func (r *gcRun[Gallery]) apply(g Gallery, evt event.Event) (Gallery, error) {
+	if a, ok := any(g).(aggregate.Aggregate); ok {
+		if err := aggregate.ApplyHistory(a, []event.Event{evt}); err == nil {
+			return g, nil
+		}
+	}
+
+	id, _, version := evt.Aggregate()
+
+	return r.gc.galleries.FetchVersion(r.ctx, id, version)
+}
+
+// fillGaps fetches the galleries whose missing events were not received before
+// their deadline, and applies their buffered events.
+func (r *gcRun[Gallery]) fillGaps() {
+	now := time.Now()
+	for id := range r.gaps {
+		state := r.galleries[id]
+		if state.deadline.After(now) {
+			continue
+		}
+
+		first := -1
+		for version := range state.buffered {
+			if first < 0 || version < first {
+				first = version
+			}
+		}
+
+		g, err := r.gc.galleries.FetchVersion(r.ctx, id, first-1)
+		if err != nil {
+			r.forget(id)
+			r.fail(fmt.Errorf("fetch gallery at version %d [id=%s]: %w", first-1, id, err))
+			continue
+		}
+		state.gallery = g
+
+		evt := state.buffered[first]
+		delete(state.buffered, first)
+		r.advance(state, evt)
+
+		if len(state.buffered) > 0 {
+			state.deadline = now.Add(gapTimeout)
+		}
+	}
+}
+
+// evict drops the states of the least recently changed galleries that exceed
+// the cache size. Galleries that wait for missing events are kept.
+func (r *gcRun[Gallery]) evict() {
+	for elem := r.recent.Back(); elem != nil && len(r.galleries) > r.cfg.cacheSize; {
+		id := elem.Value.(uuid.UUID)
+		elem = elem.Prev()
+		if _, ok := r.gaps[id]; !ok {
+			r.forget(id)
+		}
+	}
+}
+
+// forget drops the state of a gallery.
+func (r *gcRun[Gallery]) forget(id uuid.UUID) {
+	if state, ok := r.galleries[id]; ok {
+		r.recent.Remove(state.elem)
+		delete(r.galleries, id)
+	}
+	delete(r.gaps, id)
+}
+
+// handleSkipped schedules the deletion of the files whose references were
+// removed by an event that was not applied to the latest known state of its
+// gallery.
+func (r *gcRun[Gallery]) handleSkipped(evt event.Event) {
+	if !slices.Contains(GarbageEvents, evt.Name()) {
+		return
+	}
+
+	id, _, version := evt.Aggregate()
+
+	before, err := r.gc.galleries.FetchVersion(r.ctx, id, version-1)
+	if err != nil {
+		r.fail(fmt.Errorf("fetch gallery at version %d [id=%s]: %w", version-1, id, err))
+		return
+	}
+
+	after, err := r.gc.galleries.FetchVersion(r.ctx, id, version)
+	if err != nil {
+		r.fail(fmt.Errorf("fetch gallery at version %d [id=%s]: %w", version, id, err))
+		return
+	}
+
+	r.schedule(evt, r.removed(before.StorageFiles(), after.StorageFiles()))
+}
+
+// schedule schedules the deletion of files whose references were removed by
+// the given event, after the grace period.
+func (r *gcRun[Gallery]) schedule(evt event.Event, removed []removedRef) {
+	due := evt.Time().Add(r.cfg.gracePeriod)
+	for _, ref := range removed {
+		heap.Push(&r.pending, deletion{due: due, evt: evt, ref: ref})
+	}
+}
+
+// collectDue collects the files whose grace period has passed.
+func (r *gcRun[Gallery]) collectDue() {
+	// Deletions that are due at the same time share the current gallery.
+	current := make(map[uuid.UUID]Gallery)
+
+	now := time.Now()
+	for len(r.pending) > 0 && !r.pending[0].due.After(now) {
+		d := heap.Pop(&r.pending).(deletion)
+		r.collect(d.evt, d.ref, current)
+	}
+}
+
+// removedRef is a file whose references were removed from a gallery.
+type removedRef struct {
+	file image.Storage
+
+	// before and after are the numbers of references from the gallery before
+	// and after the event was applied.
+	before, after int
+}
+
+// removed returns the files that were referenced more often by the gallery
+// before an event was applied than after.
+func (r *gcRun[Gallery]) removed(before, after []image.Storage) []removedRef {
+	var out []removedRef
+	for i, file := range before {
+		// Only count every file once.
+		if slices.Index(before, file) != i {
+			continue
+		}
+
+		ref := removedRef{
+			file:   file,
+			before: r.countFile(before, file),
+			after:  r.countFile(after, file),
+		}
+
+		if ref.after < ref.before {
+			out = append(out, ref)
+		}
+	}
+	return out
+}
+
+// collect deletes a file whose references were removed by the given event,
+// unless it is referenced again. current caches the current versions of the
+// galleries.
+func (r *gcRun[Gallery]) collect(evt event.Event, ref removedRef, current map[uuid.UUID]Gallery) {
+	id, name, _ := evt.Aggregate()
+
+	// The file may have been referenced again during the grace period.
+	g, ok := current[id]
+	if !ok {
+		var err error
+		if g, err = r.gc.galleries.Fetch(r.ctx, id); err != nil {
+			r.fail(fmt.Errorf("fetch gallery [id=%s]: %w", id, err))
+			return
+		}
+		current[id] = g
+	}
+	references := r.countFile(g.StorageFiles(), ref.file)
+
+	garbage := Garbage{
+		Gallery: aggregate.Ref{Name: name, ID: id},
+		Storage: ref.file,
+		Trigger: evt,
+	}
+
+	if isContentPath(ref.file.Path) {
+		released, err := r.release(ref, references)
+		if err != nil {
+			r.fail(fmt.Errorf("release references of %q [galleryId=%s]: %w", ref.file.Path, id, err))
+			return
+		}
+		if !released {
+			return
+		}
+		garbage.Deleted = !r.cfg.dryRun
+	} else {
+		if references > 0 {
+			return
+		}
+
+		if !r.cfg.dryRun {
+			if err := deleteFile(r.ctx, r.gc.storage, ref.file); err != nil {
+				r.fail(fmt.Errorf("delete %q [galleryId=%s]: %w", ref.file.Path, id, err))
+				return
+			}
+			garbage.Deleted = true
+		}
+	}
+
+	select {
+	case <-r.ctx.Done():
+	case r.out <- garbage:
+	}
+}
+
+// release removes the references that were removed from the gallery from the
+// [ReferenceCounter], given the current number of references from the gallery,
+// and deletes the content-addressed file if it has no references left. It
+// reports whether the file has no references left. Without a ReferenceCounter,
+// content-addressed files are never released, because they may be referenced
+// by other galleries.
+func (r *gcRun[Gallery]) release(ref removedRef, current int) (bool, error) {
+	if r.cfg.refs == nil {
+		return false, nil
+	}
+
+	// References that were restored during the grace period are not removed.
+	n := ref.before - ref.after
+	if restored := current - ref.after; restored > 0 {
+		n -= restored
+	}
+
+	if n <= 0 {
+		return false, nil
+	}
+
+	return releaseFile(r.ctx, r.cfg.refs, r.gc.storage, ref.file, n, r.cfg.dryRun)
+}
+
+func (r *gcRun[Gallery]) fail(err error) {
+	select {
+	case <-r.ctx.Done():
+	case r.errs <- err:
+	}
+}
+
+// countFile returns how often the given file occurs in files. Unless the
+// storage is a [ProviderStorage], files are compared only by their path,
+// because the storage cannot tell different providers apart. This prevents a
+// file that was moved to another provider at the same path from being deleted.
+func (r *gcRun[Gallery]) countFile(files []image.Storage, file image.Storage) int {
+	_, byProvider := r.gc.storage.(ProviderStorage)
+
+	var n int
+	for _, f := range files {
+		if f == file || (!byProvider && f.Path == file.Path) {
+			n++
+		}
+	}
+	return n
+}
+
+// deletion is a scheduled deletion of a file.
+type deletion struct {
+	due time.Time
+	evt event.Event
+	ref removedRef
+}
+
+// deletionQueue is a min-heap of deletions, ordered by their due time.
+type deletionQueue []deletion
+
+func (q deletionQueue) Len() int           { return len(q) }
+func (q deletionQueue) Less(i, j int) bool { return q[i].due.Before(q[j].due) }
+func (q deletionQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+func (q *deletionQueue) Push(x any) {
+	*q = append(*q, x.(deletion))
+}
+
+func (q *deletionQueue) Pop() any {
+	old := *q
+	n := len(old)
+	d := old[n-1]
+	*q = old[:n-1]
+	return d
+}
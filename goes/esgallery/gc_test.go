@@ -0,0 +1,474 @@
+package esgallery_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/modernice/goes/aggregate/repository"
+	"github.com/modernice/goes/event/eventbus"
+	"github.com/modernice/goes/event/eventstore"
+	"github.com/modernice/media-entity/gallery"
+	"github.com/modernice/media-entity/goes/esgallery"
+	"github.com/modernice/media-entity/internal/testx"
+)
+
+func TestGarbageCollector_Run(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var storage esgallery.MemoryStorage
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
+	ebus := eventbus.New()
+	estore := eventstore.WithBus(eventstore.New(), ebus)
+	galleries := repository.Typed(repository.New(estore), NewTestGallery)
+	gc := esgallery.NewGarbageCollector[*TestGallery](&storage, ebus, galleries)
+
+	g := NewTestGallery(uuid.New())
+	stack, variant := uploadStackWithVariant(t, uploader, g)
+
+	if err := galleries.Save(ctx, g); err != nil {
+		t.Fatalf("save gallery: %v", err)
+	}
+
+	garbage, errs, err := gc.Run(ctx)
+	if err != nil {
+		t.Fatalf("run garbage collector: %v", err)
+	}
+	go testx.PanicOn(errs)
+
+	if _, err := g.RemoveVariant(stack.ID, variant.ID); err != nil {
+		t.Fatalf("remove variant: %v", err)
+	}
+
+	if err := galleries.Save(ctx, g); err != nil {
+		t.Fatalf("save gallery: %v", err)
+	}
+
+	collected := receiveGarbage(t, garbage, 1)[0]
+
+	if collected.Storage != variant.Storage {
+		t.Fatalf("collected garbage should be %v; got %v", variant.Storage, collected.Storage)
+	}
+
+	if !collected.Deleted {
+		t.Fatalf("garbage should be deleted")
+	}
+
+	if collected.Gallery.ID != g.ID {
+		t.Fatalf("garbage should reference gallery %s; got %s", g.ID, collected.Gallery.ID)
+	}
+
+	if collected.Trigger.Name() != esgallery.VariantRemoved {
+		t.Fatalf("garbage should be triggered by %q event; got %q", esgallery.VariantRemoved, collected.Trigger.Name())
+	}
+
+	files := storage.Files()
+	if len(files) != 1 {
+		t.Fatalf("expected 1 file to be in storage; got %d", len(files))
+	}
+
+	if _, ok := files[stack.Original().Storage.Path]; !ok {
+		t.Fatalf("original image should not be deleted")
+	}
+
+	g.Clear()
+
+	if err := galleries.Save(ctx, g); err != nil {
+		t.Fatalf("save gallery: %v", err)
+	}
+
+	receiveGarbage(t, garbage, 1)
+
+	if len(storage.Files()) != 0 {
+		t.Fatalf("expected storage to be empty; got %d files", len(storage.Files()))
+	}
+}
+
+func TestGarbageCollector_Run_DryRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var storage esgallery.MemoryStorage
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
+	ebus := eventbus.New()
+	estore := eventstore.WithBus(eventstore.New(), ebus)
+	galleries := repository.Typed(repository.New(estore), NewTestGallery)
+	gc := esgallery.NewGarbageCollector[*TestGallery](&storage, ebus, galleries)
+
+	g := NewTestGallery(uuid.New())
+	stack, _ := uploadStackWithVariant(t, uploader, g)
+
+	if err := galleries.Save(ctx, g); err != nil {
+		t.Fatalf("save gallery: %v", err)
+	}
+
+	garbage, errs, err := gc.Run(ctx, esgallery.DryRun(true))
+	if err != nil {
+		t.Fatalf("run garbage collector: %v", err)
+	}
+	go testx.PanicOn(errs)
+
+	if _, err := g.RemoveStack(stack.ID); err != nil {
+		t.Fatalf("remove stack: %v", err)
+	}
+
+	if err := galleries.Save(ctx, g); err != nil {
+		t.Fatalf("save gallery: %v", err)
+	}
+
+	for _, collected := range receiveGarbage(t, garbage, 2) {
+		if collected.Deleted {
+			t.Fatalf("garbage should not be deleted in dry-run mode")
+		}
+	}
+
+	if len(storage.Files()) != 2 {
+		t.Fatalf("expected 2 files to be in storage; got %d", len(storage.Files()))
+	}
+}
+
+func TestGarbageCollector_Run_GracePeriod(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var storage esgallery.MemoryStorage
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
+	ebus := eventbus.New()
+	estore := eventstore.WithBus(eventstore.New(), ebus)
+	galleries := repository.Typed(repository.New(estore), NewTestGallery)
+	gc := esgallery.NewGarbageCollector[*TestGallery](&storage, ebus, galleries)
+
+	g := NewTestGallery(uuid.New())
+	stack, variant := uploadStackWithVariant(t, uploader, g)
+
+	if err := galleries.Save(ctx, g); err != nil {
+		t.Fatalf("save gallery: %v", err)
+	}
+
+	garbage, errs, err := gc.Run(ctx, esgallery.GracePeriod(200*time.Millisecond))
+	if err != nil {
+		t.Fatalf("run garbage collector: %v", err)
+	}
+	go testx.PanicOn(errs)
+
+	if _, err := g.RemoveVariant(stack.ID, variant.ID); err != nil {
+		t.Fatalf("remove variant: %v", err)
+	}
+
+	if err := galleries.Save(ctx, g); err != nil {
+		t.Fatalf("save gallery: %v", err)
+	}
+
+	// The variant is added back within the grace period.
+	if _, err := g.AddVariant(stack.ID, variant); err != nil {
+		t.Fatalf("add variant: %v", err)
+	}
+
+	if err := galleries.Save(ctx, g); err != nil {
+		t.Fatalf("save gallery: %v", err)
+	}
+
+	select {
+	case <-time.After(400 * time.Millisecond):
+	case collected := <-garbage:
+		t.Fatalf("re-referenced file should not be collected; got %v", collected.Storage)
+	}
+
+	if len(storage.Files()) != 2 {
+		t.Fatalf("expected 2 files to be in storage; got %d", len(storage.Files()))
+	}
+}
+
+func TestGarbageCollector_Run_appliesEventsOnce(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var storage esgallery.MemoryStorage
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
+	ebus := eventbus.New()
+	estore := eventstore.WithBus(eventstore.New(), ebus)
+	galleries := &countingFetcher{TypedRepository: repository.Typed(repository.New(estore), NewTestGallery)}
+	gc := esgallery.NewGarbageCollector[*TestGallery](&storage, ebus, galleries)
+
+	garbage, errs, err := gc.Run(ctx)
+	if err != nil {
+		t.Fatalf("run garbage collector: %v", err)
+	}
+	go testx.PanicOn(errs)
+
+	g := NewTestGallery(uuid.New())
+
+	var stacks []gallery.Stack[uuid.UUID, uuid.UUID]
+	for i := 0; i < 5; i++ {
+		stack, _ := uploadStackWithVariant(t, uploader, g)
+		stacks = append(stacks, stack)
+	}
+
+	if err := galleries.Save(ctx, g); err != nil {
+		t.Fatalf("save gallery: %v", err)
+	}
+
+	for _, stack := range stacks {
+		if _, err := g.RemoveStack(stack.ID); err != nil {
+			t.Fatalf("remove stack: %v", err)
+		}
+
+		if err := galleries.Save(ctx, g); err != nil {
+			t.Fatalf("save gallery: %v", err)
+		}
+
+		receiveGarbage(t, garbage, 2)
+	}
+
+	if n := galleries.fetchVersion.Load(); n > 1 {
+		t.Fatalf("garbage collector should fetch a gallery version at most once; fetched %d times", n)
+	}
+}
+
+func TestGarbageCollector_Run_CacheGalleries(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  []esgallery.RunGCOption
+		check func(fetched int64) bool
+	}{
+		{
+			name: "default",
+			// Both galleries stay cached, so each is fetched once.
+			check: func(fetched int64) bool { return fetched <= 2 },
+		},
+		{
+			name: "CacheGalleries(1)",
+			opts: []esgallery.RunGCOption{esgallery.CacheGalleries(1)},
+			// Only one gallery stays cached, so every change of the other
+			// gallery fetches it again.
+			check: func(fetched int64) bool { return fetched >= 6 },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var storage esgallery.MemoryStorage
+			uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
+			ebus := eventbus.New()
+			estore := eventstore.WithBus(eventstore.New(), ebus)
+			galleries := &countingFetcher{TypedRepository: repository.Typed(repository.New(estore), NewTestGallery)}
+			gc := esgallery.NewGarbageCollector[*TestGallery](&storage, ebus, galleries)
+
+			garbage, errs, err := gc.Run(ctx, tt.opts...)
+			if err != nil {
+				t.Fatalf("run garbage collector: %v", err)
+			}
+			go testx.PanicOn(errs)
+
+			gs := []*TestGallery{NewTestGallery(uuid.New()), NewTestGallery(uuid.New())}
+			stacks := make(map[*TestGallery][]gallery.Stack[uuid.UUID, uuid.UUID])
+			for _, g := range gs {
+				for i := 0; i < 3; i++ {
+					stack, _ := uploadStackWithVariant(t, uploader, g)
+					stacks[g] = append(stacks[g], stack)
+				}
+				if err := galleries.Save(ctx, g); err != nil {
+					t.Fatalf("save gallery: %v", err)
+				}
+			}
+
+			for i := 0; i < 3; i++ {
+				for _, g := range gs {
+					if _, err := g.RemoveStack(stacks[g][i].ID); err != nil {
+						t.Fatalf("remove stack: %v", err)
+					}
+					if err := galleries.Save(ctx, g); err != nil {
+						t.Fatalf("save gallery: %v", err)
+					}
+					receiveGarbage(t, garbage, 2)
+				}
+			}
+
+			if n := galleries.fetchVersion.Load(); !tt.check(n) {
+				t.Fatalf("garbage collector fetched gallery versions %d times", n)
+			}
+
+			if len(storage.Files()) != 0 {
+				t.Fatalf("all files should be collected; %d files remain", len(storage.Files()))
+			}
+		})
+	}
+}
+
+type countingFetcher struct {
+	*repository.TypedRepository[*TestGallery]
+
+	fetchVersion atomic.Int64
+}
+
+func (f *countingFetcher) FetchVersion(ctx context.Context, id uuid.UUID, v int) (*TestGallery, error) {
+	f.fetchVersion.Add(1)
+	return f.TypedRepository.FetchVersion(ctx, id, v)
+}
+
+func uploadStackWithVariant(t *testing.T, uploader *esgallery.Uploader[uuid.UUID, uuid.UUID], g *TestGallery) (gallery.Stack[uuid.UUID, uuid.UUID], gallery.Image[uuid.UUID]) {
+	t.Helper()
+
+	stack, err := uploader.UploadNew(context.Background(), g, uuid.New(), uuid.New(), newExample(), "example.jpg")
+	if err != nil {
+		t.Fatalf("upload original: %v", err)
+	}
+
+	variant, err := uploader.UploadVariant(context.Background(), g, stack.ID, uuid.New(), newExample())
+	if err != nil {
+		t.Fatalf("upload variant: %v", err)
+	}
+
+	if _, err := g.AddVariant(stack.ID, variant); err != nil {
+		t.Fatalf("add variant: %v", err)
+	}
+
+	stack, _ = g.Stack(stack.ID)
+
+	return stack, variant
+}
+
+func receiveGarbage(t *testing.T, garbage <-chan esgallery.Garbage, n int) []esgallery.Garbage {
+	t.Helper()
+
+	out := make([]esgallery.Garbage, 0, n)
+	for len(out) < n {
+		select {
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for garbage; received %d of %d", len(out), n)
+		case collected := <-garbage:
+			out = append(out, collected)
+		}
+	}
+
+	return out
+}
+
+func TestGarbageCollector_Run_References(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var storage esgallery.MemoryStorage
+	var refs esgallery.MemoryReferenceCounter
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage, esgallery.WithContentAddressing(&refs))
+	ebus := eventbus.New()
+	estore := eventstore.WithBus(eventstore.New(), ebus)
+	galleries := repository.Typed(repository.New(estore), NewTestGallery)
+	gc := esgallery.NewGarbageCollector[*TestGallery](&storage, ebus, galleries)
+
+	// The same image is uploaded into two galleries.
+	var gs []*TestGallery
+	var stacks []gallery.Stack[uuid.UUID, uuid.UUID]
+	for i := 0; i < 2; i++ {
+		g := NewTestGallery(uuid.New())
+		stack, err := uploader.UploadNew(ctx, g, uuid.New(), uuid.New(), newExample(), "example.jpg")
+		if err != nil {
+			t.Fatalf("upload original: %v", err)
+		}
+
+		if err := galleries.Save(ctx, g); err != nil {
+			t.Fatalf("save gallery: %v", err)
+		}
+
+		gs = append(gs, g)
+		stacks = append(stacks, stack)
+	}
+
+	garbage, errs, err := gc.Run(ctx, esgallery.References(&refs))
+	if err != nil {
+		t.Fatalf("run garbage collector: %v", err)
+	}
+	go testx.PanicOn(errs)
+
+	if _, err := gs[0].RemoveStack(stacks[0].ID); err != nil {
+		t.Fatalf("remove stack: %v", err)
+	}
+
+	if err := galleries.Save(ctx, gs[0]); err != nil {
+		t.Fatalf("save gallery: %v", err)
+	}
+
+	select {
+	case <-time.After(200 * time.Millisecond):
+	case collected := <-garbage:
+		t.Fatalf("file that is referenced by another gallery should not be collected; got %v", collected.Storage)
+	}
+
+	if len(storage.Files()) != 1 {
+		t.Fatalf("expected 1 file to be in storage; got %d", len(storage.Files()))
+	}
+
+	if _, err := gs[1].RemoveStack(stacks[1].ID); err != nil {
+		t.Fatalf("remove stack: %v", err)
+	}
+
+	if err := galleries.Save(ctx, gs[1]); err != nil {
+		t.Fatalf("save gallery: %v", err)
+	}
+
+	receiveGarbage(t, garbage, 1)
+
+	if len(storage.Files()) != 0 {
+		t.Fatalf("expected storage to be empty; got %d files", len(storage.Files()))
+	}
+}
+
+func TestGarbageCollector_Run_contentAddressedWithoutReferences(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var storage esgallery.MemoryStorage
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage, esgallery.WithContentAddressing(nil))
+	ebus := eventbus.New()
+	estore := eventstore.WithBus(eventstore.New(), ebus)
+	galleries := repository.Typed(repository.New(estore), NewTestGallery)
+	gc := esgallery.NewGarbageCollector[*TestGallery](&storage, ebus, galleries)
+
+	// The same image is uploaded into two galleries.
+	var gs []*TestGallery
+	var stacks []gallery.Stack[uuid.UUID, uuid.UUID]
+	for i := 0; i < 2; i++ {
+		g := NewTestGallery(uuid.New())
+		stack, err := uploader.UploadNew(ctx, g, uuid.New(), uuid.New(), newExample(), "example.jpg")
+		if err != nil {
+			t.Fatalf("upload original: %v", err)
+		}
+
+		if err := galleries.Save(ctx, g); err != nil {
+			t.Fatalf("save gallery: %v", err)
+		}
+
+		gs = append(gs, g)
+		stacks = append(stacks, stack)
+	}
+
+	garbage, errs, err := gc.Run(ctx)
+	if err != nil {
+		t.Fatalf("run garbage collector: %v", err)
+	}
+	go testx.PanicOn(errs)
+
+	if _, err := gs[0].RemoveStack(stacks[0].ID); err != nil {
+		t.Fatalf("remove stack: %v", err)
+	}
+
+	if err := galleries.Save(ctx, gs[0]); err != nil {
+		t.Fatalf("save gallery: %v", err)
+	}
+
+	select {
+	case <-time.After(200 * time.Millisecond):
+	case collected := <-garbage:
+		t.Fatalf("content-addressed file should not be collected without references; got %v", collected.Storage)
+	}
+
+	if len(storage.Files()) != 1 {
+		t.Fatalf("expected 1 file to be in storage; got %d", len(storage.Files()))
+	}
+}
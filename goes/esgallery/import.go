@@ -0,0 +1,245 @@
+package esgallery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/modernice/media-entity/gallery"
+	"github.com/modernice/media-entity/image"
+)
+
+const (
+	// DefaultImportTimeout is the default timeout of [*Uploader.ImportURL].
+	DefaultImportTimeout = 30 * time.Second
+
+	// DefaultImportMaxFileSize is the default maximum size of an image that is
+	// downloaded by [*Uploader.ImportURL].
+	DefaultImportMaxFileSize = 32 << 20
+)
+
+// ErrHostNotAllowed is returned by [*Uploader.ImportURL] if the host of a URL is
+// not allowed by the [ImportPolicy].
+var ErrHostNotAllowed = errors.New("host not allowed")
+
+// ImportPolicy configures how [*Uploader.ImportURL] downloads images. Pass an
+// ImportPolicy to [NewUploader] using the [WithImportPolicy] option. Imported
+// images are also validated against the [UploadPolicy] of the [*Uploader].
+//
+// By default, hosts that resolve to loopback, private, link-local, or
+// unspecified IP addresses are rejected, to prevent editors from importing
+// files from the internal network.
+type ImportPolicy struct {
+	// MaxFileSize is the maximum file size in bytes. Defaults to
+	// [DefaultImportMaxFileSize].
+	MaxFileSize int64
+
+	// Timeout is the maximum duration of a download, including redirects.
+	// Defaults to [DefaultImportTimeout].
+	Timeout time.Duration
+
+	// AllowedHosts are the hosts that images may be imported from. A host may
+	// start with a wildcard to allow all subdomains, for example
+	// "*.example.com". If empty, all hosts are allowed.
+	AllowedHosts []string
+
+	// DeniedHosts are the hosts that images must not be imported from. Denied
+	// hosts take precedence over allowed hosts. Wildcards are supported like
+	// in AllowedHosts.
+	DeniedHosts []string
+
+	// AllowPrivateNetworks disables the check for private IP addresses.
+	AllowPrivateNetworks bool
+}
+
+// WithImportPolicy returns an [UploaderOption] that configures how images are
+// downloaded by [*Uploader.ImportURL].
+func WithImportPolicy(policy ImportPolicy) UploaderOption {
+	return func(cfg *uploaderConfig) {
+		cfg.imports = policy
+	}
+}
+
+// ImportURL downloads the image at the given HTTP(S) URL, and uploads it to the
+// provided gallery as a new [gallery.Stack], exactly like [*Uploader.UploadNew].
+// The URL is recorded as the Source of the original image. The filename is
+// taken from the Content-Disposition header of the response, or from the path
+// of the URL.
+//
+// The download is restricted by the [ImportPolicy] of the Uploader. If the
+// host of the URL, or of a redirect, is not allowed, an error that satisfies
+// errors.Is(err, ErrHostNotAllowed) is returned. If the image exceeds the
+// maximum file size, an error that satisfies errors.Is(err, ErrFileTooLarge)
+// is returned.
+func (u *Uploader[StackID, ImageID]) ImportURL(
+	ctx context.Context,
+	g ProcessableGallery[StackID, ImageID],
+	stackID StackID,
+	imageID ImageID,
+	rawURL string,
+) (gallery.Stack[StackID, ImageID], error) {
+	var zero gallery.Stack[StackID, ImageID]
+
+	policy := u.cfg.imports.withDefaults()
+
+	source, err := url.Parse(rawURL)
+	if err != nil {
+		return zero, fmt.Errorf("parse url: %w", err)
+	}
+	if err := policy.check(source); err != nil {
+		return zero, err
+	}
+
+	downloadCtx, cancel := context.WithTimeout(ctx, policy.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(downloadCtx, http.MethodGet, source.String(), nil)
+	if err != nil {
+		return zero, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Accept", "image/*")
+
+	resp, err := policy.client().Do(req)
+	if err != nil {
+		return zero, fmt.Errorf("download %s: %w", source.Redacted(), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return zero, fmt.Errorf("download %s: unexpected status %s", source.Redacted(), resp.Status)
+	}
+
+	if resp.ContentLength > policy.MaxFileSize {
+		return zero, policy.fileTooLarge()
+	}
+
+	limit := &limitReader{r: resp.Body, max: policy.MaxFileSize}
+	stack, err := u.uploadNew(ctx, g, stackID, imageID, limit, image.Image{
+		Filename: importFilename(resp),
+		Source:   source.String(),
+	})
+	if limit.exceeded {
+		return zero, policy.fileTooLarge()
+	}
+	if err != nil {
+		return zero, err
+	}
+
+	return stack, nil
+}
+
+// importFilename returns the filename of a downloaded image.
+func importFilename(resp *http.Response) string {
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		if filename := cleanFilename(params["filename"]); filename != "" {
+			return filename
+		}
+	}
+
+	if filename := cleanFilename(resp.Request.URL.Path); filename != "" {
+		return filename
+	}
+
+	return "image"
+}
+
+func (p ImportPolicy) withDefaults() ImportPolicy {
+	if p.MaxFileSize <= 0 {
+		p.MaxFileSize = DefaultImportMaxFileSize
+	}
+	if p.Timeout <= 0 {
+		p.Timeout = DefaultImportTimeout
+	}
+	return p
+}
+
+func (p ImportPolicy) fileTooLarge() error {
+	return fmt.Errorf("%w: file exceeds the maximum of %d bytes", ErrFileTooLarge, p.MaxFileSize)
+}
+
+// check checks if images may be downloaded from the given URL.
+func (p ImportPolicy) check(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported url scheme %q", u.Scheme)
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return fmt.Errorf("%w: missing host", ErrHostNotAllowed)
+	}
+
+	if matchHost(p.DeniedHosts, host) {
+		return fmt.Errorf("%w: %s", ErrHostNotAllowed, host)
+	}
+
+	if len(p.AllowedHosts) > 0 && !matchHost(p.AllowedHosts, host) {
+		return fmt.Errorf("%w: %s", ErrHostNotAllowed, host)
+	}
+
+	return nil
+}
+
+// client returns an HTTP client that enforces the policy for redirects, and
+// that rejects connections to private IP addresses.
+func (p ImportPolicy) client() *http.Client {
+	dialer := &net.Dialer{Timeout: p.Timeout}
+	if !p.AllowPrivateNetworks {
+		// The IP address is checked after the host was resolved, so that
+		// hosts cannot bypass the check by resolving to a private address.
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isPrivateIP(ip) {
+				return fmt.Errorf("%w: %s is a private address", ErrHostNotAllowed, host)
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DisableKeepAlives = true
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return p.check(req.URL)
+		},
+	}
+}
+
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsUnspecified()
+}
+
+// matchHost returns whether the host matches one of the given patterns.
+func matchHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if pattern == host {
+			return true
+		}
+		if strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:]) {
+			return true
+		}
+	}
+	return false
+}
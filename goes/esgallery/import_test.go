@@ -0,0 +1,139 @@
+package esgallery_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/modernice/media-entity/goes/esgallery"
+)
+
+func TestUploader_ImportURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/photos/foo.jpg" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(example)
+	}))
+	defer server.Close()
+
+	var storage esgallery.MemoryStorage
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage, esgallery.WithImportPolicy(esgallery.ImportPolicy{
+		AllowPrivateNetworks: true,
+	}))
+
+	g := NewTestGallery(uuid.New())
+	source := server.URL + "/photos/foo.jpg"
+
+	stack, err := uploader.ImportURL(context.Background(), g, uuid.New(), uuid.New(), source)
+	if err != nil {
+		t.Fatalf("ImportURL() failed: %v", err)
+	}
+
+	original := stack.Original()
+
+	if original.Source != source {
+		t.Fatalf("Source should be %q; is %q", source, original.Source)
+	}
+
+	if original.Filename != "foo.jpg" {
+		t.Fatalf("Filename should be %q; is %q", "foo.jpg", original.Filename)
+	}
+
+	if original.Filesize != len(example) {
+		t.Fatalf("Filesize should be %d; is %d", len(example), original.Filesize)
+	}
+
+	if _, ok := g.Stack(stack.ID); !ok {
+		t.Fatalf("stack should be added to the gallery")
+	}
+
+	if _, err := storage.Get(context.Background(), original.Storage.Path); err != nil {
+		t.Fatalf("imported image should be in storage: %v", err)
+	}
+
+	if _, err := uploader.ImportURL(context.Background(), g, uuid.New(), uuid.New(), server.URL+"/missing.jpg"); err == nil {
+		t.Fatalf("ImportURL() should fail for a missing image")
+	}
+}
+
+func TestUploader_ImportURL_policy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/redirect":
+			http.Redirect(w, r, strings.Replace(r.URL.Query().Get("to"), "127.0.0.1", "localhost", 1), http.StatusFound)
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+			w.Write(example)
+		default:
+			w.Write(example)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		policy esgallery.ImportPolicy
+		url    string
+		want   error
+	}{
+		{
+			name:   "private network",
+			policy: esgallery.ImportPolicy{},
+			url:    server.URL + "/foo.jpg",
+			want:   esgallery.ErrHostNotAllowed,
+		},
+		{
+			name:   "denied host",
+			policy: esgallery.ImportPolicy{AllowPrivateNetworks: true, DeniedHosts: []string{"127.0.0.1"}},
+			url:    server.URL + "/foo.jpg",
+			want:   esgallery.ErrHostNotAllowed,
+		},
+		{
+			name:   "host not in allowed hosts",
+			policy: esgallery.ImportPolicy{AllowPrivateNetworks: true, AllowedHosts: []string{"*.example.com"}},
+			url:    server.URL + "/foo.jpg",
+			want:   esgallery.ErrHostNotAllowed,
+		},
+		{
+			name:   "redirect to denied host",
+			policy: esgallery.ImportPolicy{AllowPrivateNetworks: true, DeniedHosts: []string{"localhost"}},
+			url:    server.URL + "/redirect?to=" + server.URL + "/foo.jpg",
+			want:   esgallery.ErrHostNotAllowed,
+		},
+		{
+			name:   "file too large",
+			policy: esgallery.ImportPolicy{AllowPrivateNetworks: true, MaxFileSize: 100},
+			url:    server.URL + "/foo.jpg",
+			want:   esgallery.ErrFileTooLarge,
+		},
+		{
+			name:   "timeout",
+			policy: esgallery.ImportPolicy{AllowPrivateNetworks: true, Timeout: 50 * time.Millisecond},
+			url:    server.URL + "/slow",
+			want:   context.DeadlineExceeded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var storage esgallery.MemoryStorage
+			uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage, esgallery.WithImportPolicy(tt.policy))
+			g := NewTestGallery(uuid.New())
+
+			if _, err := uploader.ImportURL(context.Background(), g, uuid.New(), uuid.New(), tt.url); !errors.Is(err, tt.want) {
+				t.Fatalf("ImportURL() should fail with %q; got %v", tt.want, err)
+			}
+
+			if files := storage.Files(); len(files) != 0 {
+				t.Fatalf("no files should be uploaded; got %d", len(files))
+			}
+		})
+	}
+}
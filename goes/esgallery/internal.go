@@ -1,11 +1,47 @@
 package esgallery
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"path"
-
-	"github.com/google/uuid"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func variantPath[StackID, ImageID ID](galleryID uuid.UUID, stackID StackID, variantID ImageID, filename string) string {
-	return path.Join(galleryID.String(), stackID.String(), variantID.String(), filename)
+// contentPath returns the content-addressed storage path of a file with the
+// given hex-encoded SHA-256 hash.
+func contentPath(hash string) string {
+	return path.Join("sha256", hash[:2], hash)
+}
+
+// isContentPath reports whether p is a content-addressed storage path that
+// was returned by contentPath.
+func isContentPath(p string) bool {
+	dir, hash := path.Split(p)
+	if len(hash) != sha256.Size*2 || dir != "sha256/"+hash[:2]+"/" {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+// checkPath returns an error that satisfies errors.Is(err, ErrInvalidPath) if
+// the storage path p contains a ".." segment, which could resolve to a location
+// outside of the root of a storage. Backslashes are treated as separators.
+func checkPath(p string) error {
+	for _, segment := range strings.Split(strings.ReplaceAll(p, `\`, "/"), "/") {
+		if segment == ".." {
+			return fmt.Errorf("%w: %q contains \"..\"", ErrInvalidPath, p)
+		}
+	}
+	return nil
+}
+
+// revisionFilename returns the filename with a unique revision suffix before
+// its extension, for example "foo-lx3k9a2b.jpg" for "foo.jpg".
+func revisionFilename(filename string) string {
+	ext := path.Ext(filename)
+	return strings.TrimSuffix(filename, ext) + "-" + strconv.FormatInt(time.Now().UnixNano(), 36) + ext
 }
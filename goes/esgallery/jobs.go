@@ -0,0 +1,368 @@
+package esgallery
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/modernice/goes/aggregate"
+	"github.com/modernice/goes/event"
+	"github.com/modernice/goes/event/query"
+	etime "github.com/modernice/goes/event/query/time"
+	"github.com/modernice/goes/helper/pick"
+	"github.com/modernice/goes/helper/streams"
+	"github.com/modernice/media-entity/gallery"
+	"golang.org/x/exp/slices"
+)
+
+var _ JobStore = (*MemoryJobStore)(nil)
+
+// JobStatus is the status of a post-processing [Job].
+type JobStatus string
+
+// Job statuses
+const (
+	// JobPending is the status of a [Job] that was enqueued but not yet picked
+	// up by a worker.
+	JobPending = JobStatus("pending")
+
+	// JobRunning is the status of a [Job] that is currently being processed.
+	// A [Job] that is still running when the [*PostProcessor] is restarted was
+	// interrupted and is processed again.
+	JobRunning = JobStatus("running")
+
+	// JobDone is the status of a [Job] that was processed successfully, or
+	// that did not require processing.
+	JobDone = JobStatus("done")
+
+	// JobFailed is the status of a [Job] whose processing failed.
+	JobFailed = JobStatus("failed")
+)
+
+// Job is a post-processing job of a [*PostProcessor]. Every Job is triggered
+// by one of the [ProcessorTriggerEvents] and is identified by the id of that
+// event.
+type Job struct {
+	// Event is the id of the trigger event.
+	Event uuid.UUID
+
+	// EventName is the name of the trigger event.
+	EventName string
+
+	// EventTime is the time of the trigger event.
+	EventTime time.Time
+
+	// Gallery is the gallery aggregate that raised the trigger event.
+	Gallery aggregate.Ref
+
+	// StackID is the string representation of the id of the [gallery.Stack]
+	// that is processed by this Job.
+	StackID string
+
+	// Status is the current status of the Job.
+	Status JobStatus
+}
+
+// JobStore persists the [Job]s of a [*PostProcessor], so that processing can
+// be resumed after the post-processor was stopped. Pass a JobStore to
+// [*PostProcessor.Run] using the [Durable] option.
+type JobStore interface {
+	// Enqueue adds a pending [Job] to the store. If the store already contains
+	// a Job for the same trigger event, the store is not modified and false is
+	// returned.
+	Enqueue(context.Context, Job) (bool, error)
+
+	// Update updates the status of the [Job] that was triggered by the given event.
+	Update(ctx context.Context, event uuid.UUID, status JobStatus) error
+
+	// Unfinished returns the pending and running [Job]s, sorted by the time
+	// of their trigger events.
+	Unfinished(context.Context) ([]Job, error)
+
+	// Checkpoint returns the time of the most recent trigger event that was
+	// enqueued, or the zero time if no checkpoint was set yet.
+	Checkpoint(context.Context) (time.Time, error)
+
+	// SetCheckpoint sets the checkpoint returned by Checkpoint.
+	SetCheckpoint(context.Context, time.Time) error
+}
+
+// MemoryJobStore is a thread-safe [JobStore] that stores [Job]s in memory.
+// The zero-value MemoryJobStore is ready-to-use.
+type MemoryJobStore struct {
+	mux        sync.RWMutex
+	jobs       map[uuid.UUID]Job
+	checkpoint time.Time
+}
+
+// Jobs returns all stored [Job]s, sorted by the time of their trigger events.
+func (s *MemoryJobStore) Jobs() []Job {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.filter(func(Job) bool { return true })
+}
+
+// Job returns the [Job] that was triggered by the given event, or false if the
+// store does not contain such a Job.
+func (s *MemoryJobStore) Job(event uuid.UUID) (Job, bool) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	job, ok := s.jobs[event]
+	return job, ok
+}
+
+// Enqueue implements [JobStore].
+func (s *MemoryJobStore) Enqueue(_ context.Context, job Job) (bool, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.jobs == nil {
+		s.jobs = make(map[uuid.UUID]Job)
+	}
+
+	if _, ok := s.jobs[job.Event]; ok {
+		return false, nil
+	}
+
+	if job.Status == "" {
+		job.Status = JobPending
+	}
+	s.jobs[job.Event] = job
+
+	return true, nil
+}
+
+// Update implements [JobStore].
+func (s *MemoryJobStore) Update(_ context.Context, event uuid.UUID, status JobStatus) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	job, ok := s.jobs[event]
+	if !ok {
+		return fmt.Errorf("job for event %s not found in memory job store", event)
+	}
+	job.Status = status
+	s.jobs[event] = job
+
+	return nil
+}
+
+// Unfinished implements [JobStore].
+func (s *MemoryJobStore) Unfinished(context.Context) ([]Job, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.filter(func(job Job) bool {
+		return job.Status == JobPending || job.Status == JobRunning
+	}), nil
+}
+
+// Checkpoint implements [JobStore].
+func (s *MemoryJobStore) Checkpoint(context.Context) (time.Time, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.checkpoint, nil
+}
+
+// SetCheckpoint implements [JobStore].
+func (s *MemoryJobStore) SetCheckpoint(_ context.Context, t time.Time) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.checkpoint = t
+	return nil
+}
+
+func (s *MemoryJobStore) filter(fn func(Job) bool) []Job {
+	out := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		if fn(job) {
+			out = append(out, job)
+		}
+	}
+	slices.SortFunc(out, func(a, b Job) int {
+		switch {
+		case a.EventTime.Before(b.EventTime):
+			return -1
+		case a.EventTime.After(b.EventTime):
+			return 1
+		}
+		return 0
+	})
+	return out
+}
+
+// Durable returns a [RunProcessorOption] that persists the post-processing
+// [Job]s in the provided [JobStore]. When the post-processor starts, the
+// unfinished Jobs of the previous run are processed first. Then, the
+// [ProcessorTriggerEvents] that were published while the post-processor was
+// not running are replayed from the provided event store, starting at the
+// checkpoint of the JobStore.
+//
+// When the JobStore has no checkpoint yet, no events are replayed, and the
+// checkpoint is set to the current time.
+func Durable(jobs JobStore, events event.Store) RunProcessorOption {
+	return func(cfg *runProcessorConfig) {
+		cfg.jobs = jobs
+		cfg.eventStore = events
+	}
+}
+
+// resume returns a channel of trigger events that first contains the events of
+// the unfinished [Job]s, then the events that were missed since the checkpoint,
+// and then the events from the provided live channel. Every event is enqueued
+// as a [Job] before it is pushed into the returned channel; events that were
+// already enqueued are skipped.
+func (q *processorQueue[Gallery, StackID, ImageID]) resume(live <-chan event.Event) (<-chan event.Event, <-chan error, error) {
+	unfinished, err := q.cfg.jobs.Unfinished(q.ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("fetch unfinished jobs: %w", err)
+	}
+
+	checkpoint, err := q.cfg.jobs.Checkpoint(q.ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("fetch checkpoint: %w", err)
+	}
+
+	var (
+		missed    <-chan event.Event
+		missedErr <-chan error
+	)
+
+	if checkpoint.IsZero() {
+		checkpoint = time.Now()
+		if err := q.cfg.jobs.SetCheckpoint(q.ctx, checkpoint); err != nil {
+			return nil, nil, fmt.Errorf("set checkpoint: %w", err)
+		}
+	} else {
+		q.cfg.debugLog("replaying %v events since %v ...", ProcessorTriggerEvents, checkpoint)
+
+		if missed, missedErr, err = q.cfg.eventStore.Query(q.ctx, query.New(
+			query.Name(ProcessorTriggerEvents...),
+			query.Time(etime.Min(checkpoint)),
+			query.SortByTime(),
+		)); err != nil {
+			return nil, nil, fmt.Errorf("query missed events: %w", err)
+		}
+	}
+
+	out := make(chan event.Event)
+	errs := make(chan error)
+
+	push := func(evt event.Event) bool {
+		select {
+		case <-q.ctx.Done():
+			return false
+		case out <- evt:
+			return true
+		}
+	}
+
+	fail := func(err error) {
+		select {
+		case <-q.ctx.Done():
+		case errs <- err:
+		}
+	}
+
+	enqueue := func(evt event.Event) error {
+		if !q.shouldProcess(evt) {
+			return nil
+		}
+
+		added, err := q.cfg.jobs.Enqueue(q.ctx, q.newJob(evt))
+		if err != nil {
+			fail(fmt.Errorf("enqueue job for %q event: %w", evt.Name(), err))
+			return nil
+		}
+
+		if evt.Time().After(checkpoint) {
+			checkpoint = evt.Time()
+			if err := q.cfg.jobs.SetCheckpoint(q.ctx, checkpoint); err != nil {
+				fail(fmt.Errorf("set checkpoint: %w", err))
+			}
+		}
+
+		if added && !push(evt) {
+			return q.ctx.Err()
+		}
+
+		return nil
+	}
+
+	go func() {
+		defer close(out)
+		defer close(errs)
+
+		for _, job := range unfinished {
+			q.cfg.debugLog("resuming unfinished job [event=%s, status=%s]", job.Event, job.Status)
+
+			evt, err := q.cfg.eventStore.Find(q.ctx, job.Event)
+			if err != nil {
+				fail(fmt.Errorf("find trigger event of unfinished job [event=%s]: %w", job.Event, err))
+				continue
+			}
+
+			if !push(evt) {
+				return
+			}
+		}
+
+		if missed != nil {
+			if err := streams.Walk(q.ctx, enqueue, missed, missedErr); err != nil {
+				fail(fmt.Errorf("replay missed events: %w", err))
+			}
+		}
+
+		streams.ForEach(q.ctx, func(evt event.Event) { enqueue(evt) }, fail, live)
+	}()
+
+	return out, errs, nil
+}
+
+func (q *processorQueue[Gallery, StackID, ImageID]) newJob(evt event.Event) Job {
+	job := Job{
+		Event:     evt.ID(),
+		EventName: evt.Name(),
+		EventTime: evt.Time(),
+		Gallery: aggregate.Ref{
+			Name: pick.AggregateName(evt),
+			ID:   pick.AggregateID(evt),
+		},
+		Status: JobPending,
+	}
+
+	if stackID, ok := triggerStackID[StackID, ImageID](evt); ok {
+		job.StackID = stackID.String()
+	}
+
+	return job
+}
+
+func (q *processorQueue[Gallery, StackID, ImageID]) updateJob(evt event.Event, status JobStatus) {
+	if q.cfg.jobs == nil {
+		return
+	}
+
+	// Leave interrupted jobs as they are, so that they are resumed on restart.
+	if q.ctx.Err() != nil {
+		return
+	}
+
+	if err := q.cfg.jobs.Update(q.ctx, evt.ID(), status); err != nil {
+		q.fail(fmt.Errorf("update job status to %q [event=%s]: %w", status, evt.ID(), err))
+	}
+}
+
+// triggerStackID returns the id of the [gallery.Stack] that is referenced by
+// one of the [ProcessorTriggerEvents].
+func triggerStackID[StackID, ImageID ID](evt event.Event) (StackID, bool) {
+	switch data := evt.Data().(type) {
+	case gallery.Stack[StackID, ImageID]:
+		return data.ID, true
+	case VariantReplacedData[StackID, ImageID]:
+		return data.StackID, true
+	}
+	var zero StackID
+	return zero, false
+}
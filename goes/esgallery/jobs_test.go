@@ -0,0 +1,174 @@
+package esgallery_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/modernice/goes/aggregate"
+	"github.com/modernice/goes/aggregate/repository"
+	"github.com/modernice/goes/event/eventbus"
+	"github.com/modernice/goes/event/eventstore"
+	"github.com/modernice/media-entity/goes/esgallery"
+	"github.com/modernice/media-entity/internal/testx"
+	imgtools "github.com/modernice/media-tools/image"
+)
+
+func TestPostProcessor_Run_Durable_replayMissedEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var storage esgallery.MemoryStorage
+	var jobs esgallery.MemoryJobStore
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
+	ebus := eventbus.New()
+	estore := eventstore.WithBus(eventstore.New(), ebus)
+	repo := repository.New(estore)
+	galleries := repository.Typed(repo, NewTestGallery)
+	p := esgallery.NewProcessor(esgallery.DefaultEncoder, &storage, uploader, uuid.New)
+	pp := esgallery.NewPostProcessor(p, ebus, galleries.Fetch)
+
+	pipeline := imgtools.Pipeline{
+		imgtools.Resize(imgtools.DimensionMap{"sm": {640}}),
+	}
+
+	// The first run only sets the checkpoint.
+	runCtx, stop := context.WithCancel(ctx)
+	_, errs, err := pp.Run(runCtx, pipeline, esgallery.Durable(&jobs, estore))
+	if err != nil {
+		t.Fatalf("run post-processor: %v", err)
+	}
+	go testx.PanicOn(errs)
+	stop()
+
+	if checkpoint, _ := jobs.Checkpoint(ctx); checkpoint.IsZero() {
+		t.Fatalf("checkpoint should be set after the first run")
+	}
+
+	<-time.After(50 * time.Millisecond)
+
+	// The StackAdded event is published while the post-processor is down.
+	g := NewTestGallery(uuid.New())
+	stack, err := uploader.UploadNew(ctx, g, uuid.New(), uuid.New(), newExample(), "example.jpg")
+	if err != nil {
+		t.Fatalf("upload original image: %v", err)
+	}
+	trigger := g.AggregateChanges()[0]
+
+	if err := galleries.Save(ctx, g); err != nil {
+		t.Fatalf("save gallery: %v", err)
+	}
+
+	results, errs, err := pp.Run(ctx, pipeline, esgallery.Durable(&jobs, estore))
+	if err != nil {
+		t.Fatalf("run post-processor: %v", err)
+	}
+	go testx.PanicOn(errs)
+
+	var result esgallery.ProcessorResult[uuid.UUID, uuid.UUID]
+	select {
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for post-processor result")
+	case result = <-results:
+	}
+
+	if result.StackID != stack.ID {
+		t.Fatalf("result should be for stack %s; got %s", stack.ID, result.StackID)
+	}
+
+	if result.Trigger.ID() != trigger.ID() {
+		t.Fatalf("result should be triggered by event %s; got %s", trigger.ID(), result.Trigger.ID())
+	}
+
+	expectJobStatus(t, &jobs, trigger.ID(), esgallery.JobDone)
+
+	job, _ := jobs.Job(trigger.ID())
+	if job.StackID != stack.ID.String() {
+		t.Fatalf("job should reference stack %s; got %s", stack.ID, job.StackID)
+	}
+	if job.Gallery != (aggregate.Ref{Name: g.AggregateName(), ID: g.ID}) {
+		t.Fatalf("job references wrong gallery %v", job.Gallery)
+	}
+}
+
+func TestPostProcessor_Run_Durable_resumeUnfinishedJobs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var storage esgallery.MemoryStorage
+	var jobs esgallery.MemoryJobStore
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
+	ebus := eventbus.New()
+	estore := eventstore.WithBus(eventstore.New(), ebus)
+	repo := repository.New(estore)
+	galleries := repository.Typed(repo, NewTestGallery)
+	p := esgallery.NewProcessor(esgallery.DefaultEncoder, &storage, uploader, uuid.New)
+	pp := esgallery.NewPostProcessor(p, ebus, galleries.Fetch)
+
+	pipeline := imgtools.Pipeline{
+		imgtools.Resize(imgtools.DimensionMap{"sm": {640}}),
+	}
+
+	g := NewTestGallery(uuid.New())
+	if _, err := uploader.UploadNew(ctx, g, uuid.New(), uuid.New(), newExample(), "example.jpg"); err != nil {
+		t.Fatalf("upload original image: %v", err)
+	}
+	trigger := g.AggregateChanges()[0]
+
+	if err := galleries.Save(ctx, g); err != nil {
+		t.Fatalf("save gallery: %v", err)
+	}
+
+	// Simulate a job that was interrupted by a crash of the post-processor.
+	jobs.Enqueue(ctx, esgallery.Job{
+		Event:     trigger.ID(),
+		EventName: trigger.Name(),
+		EventTime: trigger.Time(),
+		Status:    esgallery.JobRunning,
+	})
+	jobs.SetCheckpoint(ctx, time.Now())
+
+	results, errs, err := pp.Run(ctx, pipeline, esgallery.Durable(&jobs, estore))
+	if err != nil {
+		t.Fatalf("run post-processor: %v", err)
+	}
+	go testx.PanicOn(errs)
+
+	var result esgallery.ProcessorResult[uuid.UUID, uuid.UUID]
+	select {
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for post-processor result")
+	case result = <-results:
+	}
+
+	if result.Trigger.ID() != trigger.ID() {
+		t.Fatalf("result should be triggered by event %s; got %s", trigger.ID(), result.Trigger.ID())
+	}
+
+	expectJobStatus(t, &jobs, trigger.ID(), esgallery.JobDone)
+
+	select {
+	case <-time.After(200 * time.Millisecond):
+	case <-results:
+		t.Fatalf("resumed job should only be processed once")
+	}
+}
+
+func expectJobStatus(t *testing.T, jobs *esgallery.MemoryJobStore, evt uuid.UUID, status esgallery.JobStatus) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		job, ok := jobs.Job(evt)
+		if ok && job.Status == status {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("job for event %s should have status %q; has %q", evt, status, job.Status)
+		}
+
+		<-time.After(10 * time.Millisecond)
+	}
+}
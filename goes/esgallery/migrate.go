@@ -0,0 +1,238 @@
+package esgallery
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/google/uuid"
+	"github.com/modernice/goes/aggregate"
+	"github.com/modernice/goes/aggregate/query"
+	"github.com/modernice/goes/aggregate/repository"
+	"github.com/modernice/goes/helper/pick"
+	"github.com/modernice/goes/helper/streams"
+	"github.com/modernice/media-entity/gallery"
+	"github.com/modernice/media-entity/image"
+)
+
+// MigratableGallery is a gallery whose images can be migrated to another
+// storage by a [*Migrator]. [*Gallery] implements the gallery methods of
+// MigratableGallery.
+type MigratableGallery[StackID, ImageID ID] interface {
+	aggregate.TypedAggregate
+
+	// StackIDs returns the ids of all [gallery.Stack]s in the gallery.
+	StackIDs() []StackID
+
+	// Stack returns the given [gallery.Stack].
+	Stack(StackID) (gallery.Stack[StackID, ImageID], bool)
+
+	// MoveVariant replaces the storage location of a variant.
+	MoveVariant(StackID, ImageID, image.Storage) (gallery.Stack[StackID, ImageID], error)
+}
+
+// Migrator migrates gallery images from one [Storage] to another. For each
+// gallery in the repository, the Migrator copies the files of all variants from
+// the source to the target storage, and raises [VariantReplaced] events that
+// record the new storage location. The events are marked as Moved, so that the
+// post-processor is not triggered.
+//
+// Migrations are resumable. Only images that are stored at the source provider
+// are migrated, so images that were already migrated by a previous, interrupted
+// run are skipped. Galleries are saved after all of their images were copied.
+//
+// The files in the source storage are not deleted. Run a [*GarbageCollector]
+// with a [*StorageRouter] that routes to both storages to delete them.
+type Migrator[Gallery MigratableGallery[StackID, ImageID], StackID, ImageID ID] struct {
+	source    Storage
+	target    Storage
+	galleries *repository.TypedRepository[Gallery]
+}
+
+// MigrateOption is an option for [*Migrator.Migrate].
+type MigrateOption func(*migrateConfig)
+
+type migrateConfig struct {
+	galleries  []uuid.UUID
+	onProgress func(MigrationProgress)
+}
+
+// MigrationReport reports the progress of a migration.
+type MigrationReport struct {
+	// Galleries is the number of galleries that were migrated.
+	Galleries int
+
+	// Migrated is the number of images that were copied to the target storage.
+	Migrated int
+
+	// Skipped is the number of images that were not stored at the source
+	// provider, for example because they were already migrated.
+	Skipped int
+
+	// Bytes is the number of bytes that were copied to the target storage.
+	Bytes int64
+}
+
+// MigrationProgress is reported to the [OnMigrationProgress] callback after
+// each migrated gallery.
+type MigrationProgress struct {
+	MigrationReport
+
+	// Gallery is the id of the gallery that was migrated.
+	Gallery uuid.UUID
+
+	// Total is the total number of galleries to migrate.
+	Total int
+}
+
+// MigrateGalleries returns a [MigrateOption] that only migrates the galleries
+// with the given ids.
+func MigrateGalleries(ids ...uuid.UUID) MigrateOption {
+	return func(cfg *migrateConfig) {
+		cfg.galleries = append(cfg.galleries, ids...)
+	}
+}
+
+// OnMigrationProgress returns a [MigrateOption] that calls fn after each
+// migrated gallery.
+func OnMigrationProgress(fn func(MigrationProgress)) MigrateOption {
+	return func(cfg *migrateConfig) {
+		cfg.onProgress = fn
+	}
+}
+
+// NewMigrator returns a [*Migrator] that migrates the galleries in the provided
+// repository from the source to the target storage. newGallery must return an
+// empty gallery with the given id, and is used to query the repository.
+func NewMigrator[Gallery MigratableGallery[StackID, ImageID], StackID, ImageID ID](
+	source, target Storage,
+	repo aggregate.Repository,
+	newGallery func(uuid.UUID) Gallery,
+) *Migrator[Gallery, StackID, ImageID] {
+	return &Migrator[Gallery, StackID, ImageID]{
+		source:    source,
+		target:    target,
+		galleries: repository.Typed(repo, newGallery),
+	}
+}
+
+// Migrate migrates the images that are stored at the provider `from` to the
+// target storage, and returns a report of the migration. The Provider of the
+// new storage locations is set to `to`, or to the provider that is returned by
+// the target storage if `to` is empty. Files are copied to the same path in
+// the target storage.
+//
+// If the migration fails, the report of the galleries that were migrated so
+// far is returned together with the error. Call Migrate again to resume.
+func (m *Migrator[Gallery, StackID, ImageID]) Migrate(ctx context.Context, from, to string, opts ...MigrateOption) (MigrationReport, error) {
+	var cfg migrateConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	ids, err := m.galleryIDs(ctx, cfg)
+	if err != nil {
+		return MigrationReport{}, err
+	}
+
+	var report MigrationReport
+	for _, id := range ids {
+		var galleryReport MigrationReport
+		if err := m.galleries.Use(ctx, id, func(g Gallery) error {
+			galleryReport = MigrationReport{}
+			return m.migrateGallery(ctx, g, from, to, &galleryReport)
+		}); err != nil {
+			return report, fmt.Errorf("migrate gallery %s: %w", id, err)
+		}
+
+		report.Galleries++
+		report.Migrated += galleryReport.Migrated
+		report.Skipped += galleryReport.Skipped
+		report.Bytes += galleryReport.Bytes
+
+		if cfg.onProgress != nil {
+			cfg.onProgress(MigrationProgress{
+				MigrationReport: report,
+				Gallery:         id,
+				Total:           len(ids),
+			})
+		}
+	}
+
+	return report, nil
+}
+
+func (m *Migrator[Gallery, StackID, ImageID]) galleryIDs(ctx context.Context, cfg migrateConfig) ([]uuid.UUID, error) {
+	name := pick.AggregateName(m.galleries.NewFunc()(uuid.Nil))
+
+	qopts := []query.Option{query.Name(name)}
+	if len(cfg.galleries) > 0 {
+		qopts = append(qopts, query.ID(cfg.galleries...))
+	}
+
+	str, errs, err := m.galleries.Query(ctx, query.New(qopts...))
+	if err != nil {
+		return nil, fmt.Errorf("query galleries: %w", err)
+	}
+
+	var ids []uuid.UUID
+	if err := streams.Walk(ctx, func(g Gallery) error {
+		ids = append(ids, pick.AggregateID(g))
+		return nil
+	}, str, errs); err != nil {
+		return nil, fmt.Errorf("query galleries: %w", err)
+	}
+
+	return ids, nil
+}
+
+func (m *Migrator[Gallery, StackID, ImageID]) migrateGallery(ctx context.Context, g Gallery, from, to string, report *MigrationReport) error {
+	for _, stackID := range g.StackIDs() {
+		stack, ok := g.Stack(stackID)
+		if !ok {
+			continue
+		}
+
+		for _, variant := range stack.Variants {
+			if variant.Storage.Provider != from || variant.Storage.Path == "" {
+				report.Skipped++
+				continue
+			}
+
+			stored, n, err := m.copy(ctx, variant.Storage)
+			if err != nil {
+				return fmt.Errorf("stack %v: variant %v: %w", stackID, variant.ID, err)
+			}
+			if to != "" {
+				stored.Provider = to
+			}
+
+			if _, err := g.MoveVariant(stackID, variant.ID, stored); err != nil {
+				return fmt.Errorf("stack %v: move variant %v: %w", stackID, variant.ID, err)
+			}
+
+			report.Migrated++
+			report.Bytes += n
+		}
+	}
+
+	return nil
+}
+
+func (m *Migrator[Gallery, StackID, ImageID]) copy(ctx context.Context, file image.Storage) (image.Storage, int64, error) {
+	r, err := getFile(ctx, m.source, file)
+	if err != nil {
+		return image.Storage{}, 0, fmt.Errorf("read %q from source storage: %w", file.Path, err)
+	}
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	counter := &countReader{r: r}
+	stored, err := m.target.Put(ctx, file.Path, counter)
+	if err != nil {
+		return image.Storage{}, 0, fmt.Errorf("write %q to target storage: %w", file.Path, err)
+	}
+
+	return stored, int64(counter.n), nil
+}
@@ -0,0 +1,198 @@
+package esgallery_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/modernice/goes/aggregate/repository"
+	"github.com/modernice/goes/event/eventbus"
+	"github.com/modernice/goes/event/eventstore"
+	"github.com/modernice/media-entity/goes/esgallery"
+	"github.com/modernice/media-entity/internal/testx"
+)
+
+func TestMigrator_Migrate(t *testing.T) {
+	ctx := context.Background()
+
+	source := esgallery.NewFileStorage(t.TempDir())
+	var target esgallery.MemoryStorage
+
+	repo := repository.New(eventstore.New())
+	galleries := repository.Typed(repo, NewTestGallery)
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](source)
+
+	var ids []uuid.UUID
+	for i := 0; i < 2; i++ {
+		g := NewTestGallery(uuid.New())
+		stack, _ := uploadStackWithVariant(t, uploader, g)
+		g.MarkAsProcessed(stack.ID)
+
+		if err := galleries.Save(ctx, g); err != nil {
+			t.Fatalf("save gallery: %v", err)
+		}
+		ids = append(ids, g.ID)
+	}
+
+	migrator := esgallery.NewMigrator[*TestGallery, uuid.UUID, uuid.UUID](source, &target, repo, NewTestGallery)
+
+	var progress []esgallery.MigrationProgress
+	report, err := migrator.Migrate(ctx, "fs", "", esgallery.OnMigrationProgress(func(p esgallery.MigrationProgress) {
+		progress = append(progress, p)
+	}))
+	if err != nil {
+		t.Fatalf("Migrate() failed: %v", err)
+	}
+
+	if report.Galleries != 2 || report.Migrated != 4 || report.Skipped != 0 {
+		t.Fatalf("report should contain 2 galleries with 4 migrated images; got %+v", report)
+	}
+
+	if report.Bytes != 4*int64(len(example)) {
+		t.Fatalf("report should contain %d bytes; got %d", 4*len(example), report.Bytes)
+	}
+
+	if len(progress) != 2 || progress[1].Total != 2 || progress[1].MigrationReport != report {
+		t.Fatalf("progress should be reported for each gallery; got %+v", progress)
+	}
+
+	if len(target.Files()) != 4 {
+		t.Fatalf("expected 4 files in target storage; got %d", len(target.Files()))
+	}
+
+	for _, id := range ids {
+		g, err := galleries.Fetch(ctx, id)
+		if err != nil {
+			t.Fatalf("fetch gallery: %v", err)
+		}
+
+		for _, file := range g.StorageFiles() {
+			if file.Provider != "memory" {
+				t.Fatalf("image should be migrated to %q provider; is stored at %q", "memory", file.Provider)
+			}
+
+			if _, ok := target.Files()[file.Path]; !ok {
+				t.Fatalf("target storage should contain %q", file.Path)
+			}
+		}
+
+		if len(g.ProcessedStacks()) != 1 {
+			t.Fatalf("moving the original image should not unmark its stack as processed")
+		}
+	}
+
+	// Running the migration again skips the migrated images.
+	if report, err = migrator.Migrate(ctx, "fs", ""); err != nil {
+		t.Fatalf("Migrate() failed: %v", err)
+	}
+
+	if report.Migrated != 0 || report.Skipped != 4 {
+		t.Fatalf("migrated images should be skipped; got %+v", report)
+	}
+}
+
+func TestMigrator_Migrate_MigrateGalleries(t *testing.T) {
+	ctx := context.Background()
+
+	source := esgallery.NewFileStorage(t.TempDir())
+	var target esgallery.MemoryStorage
+
+	repo := repository.New(eventstore.New())
+	galleries := repository.Typed(repo, NewTestGallery)
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](source)
+
+	var ids []uuid.UUID
+	for i := 0; i < 3; i++ {
+		g := NewTestGallery(uuid.New())
+		uploadStackWithVariant(t, uploader, g)
+
+		if err := galleries.Save(ctx, g); err != nil {
+			t.Fatalf("save gallery: %v", err)
+		}
+		ids = append(ids, g.ID)
+	}
+
+	migrator := esgallery.NewMigrator[*TestGallery, uuid.UUID, uuid.UUID](source, &target, repo, NewTestGallery)
+
+	report, err := migrator.Migrate(ctx, "fs", "new", esgallery.MigrateGalleries(ids[1]))
+	if err != nil {
+		t.Fatalf("Migrate() failed: %v", err)
+	}
+
+	if report.Galleries != 1 || report.Migrated != 2 {
+		t.Fatalf("report should contain 1 gallery with 2 migrated images; got %+v", report)
+	}
+
+	g, err := galleries.Fetch(ctx, ids[1])
+	if err != nil {
+		t.Fatalf("fetch gallery: %v", err)
+	}
+
+	for _, file := range g.StorageFiles() {
+		if file.Provider != "new" {
+			t.Fatalf("migrated image should be stored at %q provider; is stored at %q", "new", file.Provider)
+		}
+	}
+}
+
+func TestMigrator_Migrate_GarbageCollector(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var source, target esgallery.MemoryStorage
+	router, err := esgallery.NewStorageRouter("old", map[string]esgallery.Storage{
+		"old": &source,
+		"new": &target,
+	})
+	if err != nil {
+		t.Fatalf("create storage router: %v", err)
+	}
+
+	ebus := eventbus.New()
+	estore := eventstore.WithBus(eventstore.New(), ebus)
+	repo := repository.New(estore)
+	galleries := repository.Typed(repo, NewTestGallery)
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](router)
+
+	g := NewTestGallery(uuid.New())
+	uploadStackWithVariant(t, uploader, g)
+
+	if err := galleries.Save(ctx, g); err != nil {
+		t.Fatalf("save gallery: %v", err)
+	}
+
+	gc := esgallery.NewGarbageCollector[*TestGallery](router, ebus, galleries)
+	garbage, errs, err := gc.Run(ctx)
+	if err != nil {
+		t.Fatalf("run garbage collector: %v", err)
+	}
+	go testx.PanicOn(errs)
+
+	migrator := esgallery.NewMigrator[*TestGallery, uuid.UUID, uuid.UUID](router, &target, repo, NewTestGallery)
+	if _, err := migrator.Migrate(ctx, "old", "new"); err != nil {
+		t.Fatalf("Migrate() failed: %v", err)
+	}
+
+	collected := receiveGarbage(t, garbage, 2)
+
+	for _, c := range collected {
+		if c.Storage.Provider != "old" {
+			t.Fatalf("only files of the %q provider should be collected; got %q", "old", c.Storage.Provider)
+		}
+	}
+
+	select {
+	case <-time.After(100 * time.Millisecond):
+	case c := <-garbage:
+		t.Fatalf("migrated files should not be collected; got %v", c.Storage)
+	}
+
+	if len(source.Files()) != 0 {
+		t.Fatalf("source storage should be empty; has %d files", len(source.Files()))
+	}
+
+	if len(target.Files()) != 2 {
+		t.Fatalf("expected 2 files in target storage; got %d", len(target.Files()))
+	}
+}
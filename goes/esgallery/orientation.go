@@ -0,0 +1,62 @@
+package esgallery
+
+import (
+	stdimage "image"
+
+	"github.com/disintegration/imaging"
+	"github.com/modernice/media-entity/image"
+)
+
+// headerBuffer buffers the first [maxHeaderSize] bytes that are written to it,
+// and discards the rest.
+type headerBuffer struct {
+	b []byte
+}
+
+func (h *headerBuffer) Write(p []byte) (int, error) {
+	if rem := maxHeaderSize - len(h.b); rem > 0 {
+		if len(p) > rem {
+			h.b = append(h.b, p[:rem]...)
+		} else {
+			h.b = append(h.b, p...)
+		}
+	}
+	return len(p), nil
+}
+
+// orientation returns the EXIF orientation of the buffered image header, or 0
+// if the header has no orientation.
+func (h *headerBuffer) orientation() int {
+	metadata, err := image.ParseMetadata(h.b)
+	if err != nil {
+		return 0
+	}
+	return metadata.Orientation
+}
+
+// orient applies an EXIF orientation to img, so that it is upright.
+func orient(img stdimage.Image, orientation int) stdimage.Image {
+	switch orientation {
+	case 2:
+		return imaging.FlipH(img)
+	case 3:
+		return imaging.Rotate180(img)
+	case 4:
+		return imaging.FlipV(img)
+	case 5:
+		return imaging.Transpose(img)
+	case 6:
+		return imaging.Rotate270(img)
+	case 7:
+		return imaging.Transverse(img)
+	case 8:
+		return imaging.Rotate90(img)
+	}
+	return img
+}
+
+// swapsDimensions returns whether an EXIF orientation rotates an image by 90
+// degrees, which swaps its width and height.
+func swapsDimensions(orientation int) bool {
+	return orientation >= 5 && orientation <= 8
+}
@@ -0,0 +1,120 @@
+package esgallery
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	_ PathStrategy = DefaultPaths{}
+	_ PathStrategy = DateShardedPaths{}
+	_ PathStrategy = HashShardedPaths{}
+	_ PathStrategy = PathStrategyFunc(nil)
+)
+
+// PathStrategy determines the storage paths of images that are uploaded by an
+// [*Uploader]. Pass a PathStrategy to [NewUploader] using the
+// [WithPathStrategy] option. The default strategy is [DefaultPaths].
+//
+// Changing the PathStrategy of an [*Uploader] does not affect images that were
+// already uploaded, because the storage path of an image is recorded in its
+// [image.Storage].
+type PathStrategy interface {
+	// Path returns the storage path of the described image.
+	Path(PathInfo) string
+}
+
+// PathInfo describes an image that is uploaded to storage.
+type PathInfo struct {
+	// Gallery is the id of the gallery.
+	Gallery uuid.UUID
+
+	// Stack is the id of the [gallery.Stack], formatted as a string.
+	Stack string
+
+	// Image is the id of the variant, formatted as a string.
+	Image string
+
+	// Filename is the filename of the image.
+	Filename string
+
+	// Time is the time of the upload.
+	Time time.Time
+}
+
+// PathStrategyFunc allows a function to be used as a [PathStrategy].
+type PathStrategyFunc func(PathInfo) string
+
+// Path returns fn(info).
+func (fn PathStrategyFunc) Path(info PathInfo) string {
+	return fn(info)
+}
+
+// DefaultPaths is the default [PathStrategy]. Images are stored at
+// "<gallery>/<stack>/<image>/<filename>".
+type DefaultPaths struct{}
+
+// Path implements [PathStrategy].
+func (DefaultPaths) Path(info PathInfo) string {
+	return path.Join(info.Gallery.String(), info.Stack, info.Image, info.Filename)
+}
+
+// DateShardedPaths is a [PathStrategy] that prefixes the [DefaultPaths] with
+// the date of the upload in UTC, for example
+// "2023/01/31/<gallery>/<stack>/<image>/<filename>".
+type DateShardedPaths struct {
+	// Layout is the [time.Layout] of the date prefix. Defaults to "2006/01/02".
+	Layout string
+}
+
+// Path implements [PathStrategy].
+func (s DateShardedPaths) Path(info PathInfo) string {
+	layout := s.Layout
+	if layout == "" {
+		layout = "2006/01/02"
+	}
+	return path.Join(info.Time.UTC().Format(layout), DefaultPaths{}.Path(info))
+}
+
+// HashShardedPaths is a [PathStrategy] that prefixes the [DefaultPaths] with
+// directories derived from the SHA-256 hash of the default path, for example
+// "3f/a1/<gallery>/<stack>/<image>/<filename>". This distributes images evenly
+// across prefixes, which some object storages require for high request rates.
+type HashShardedPaths struct {
+	// Depth is the number of prefix directories. Defaults to 2.
+	Depth int
+
+	// Width is the number of hex characters per prefix directory. Defaults to 2.
+	Width int
+}
+
+// Path implements [PathStrategy].
+func (s HashShardedPaths) Path(info PathInfo) string {
+	depth, width := s.Depth, s.Width
+	if depth <= 0 {
+		depth = 2
+	}
+	if width <= 0 {
+		width = 2
+	}
+
+	p := DefaultPaths{}.Path(info)
+	sum := sha256.Sum256([]byte(p))
+	hash := hex.EncodeToString(sum[:])
+
+	// The hash has 64 characters, which limits the number of prefixes.
+	if depth*width > len(hash) {
+		depth = len(hash) / width
+	}
+
+	elems := make([]string, 0, depth+1)
+	for i := 0; i < depth; i++ {
+		elems = append(elems, hash[i*width:(i+1)*width])
+	}
+
+	return path.Join(append(elems, p)...)
+}
@@ -0,0 +1,65 @@
+package esgallery_test
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/modernice/media-entity/goes/esgallery"
+)
+
+func TestPathStrategy(t *testing.T) {
+	info := esgallery.PathInfo{
+		Gallery:  uuid.MustParse("a7d3b4e6-24f6-4a6b-8d43-6ab0c1e2f3a4"),
+		Stack:    "stack",
+		Image:    "image",
+		Filename: "example.jpg",
+		Time:     time.Date(2023, time.January, 31, 23, 0, 0, 0, time.FixedZone("UTC-2", -2*60*60)),
+	}
+
+	tests := []struct {
+		name     string
+		strategy esgallery.PathStrategy
+		want     *regexp.Regexp
+	}{
+		{
+			name:     "DefaultPaths",
+			strategy: esgallery.DefaultPaths{},
+			want:     regexp.MustCompile(`^a7d3b4e6-24f6-4a6b-8d43-6ab0c1e2f3a4/stack/image/example\.jpg$`),
+		},
+		{
+			name:     "DateShardedPaths",
+			strategy: esgallery.DateShardedPaths{},
+			want:     regexp.MustCompile(`^2023/02/01/a7d3b4e6-24f6-4a6b-8d43-6ab0c1e2f3a4/stack/image/example\.jpg$`),
+		},
+		{
+			name:     "DateShardedPaths (Layout)",
+			strategy: esgallery.DateShardedPaths{Layout: "2006-01"},
+			want:     regexp.MustCompile(`^2023-02/a7d3b4e6-24f6-4a6b-8d43-6ab0c1e2f3a4/stack/image/example\.jpg$`),
+		},
+		{
+			name:     "HashShardedPaths",
+			strategy: esgallery.HashShardedPaths{},
+			want:     regexp.MustCompile(`^[0-9a-f]{2}/[0-9a-f]{2}/a7d3b4e6-24f6-4a6b-8d43-6ab0c1e2f3a4/stack/image/example\.jpg$`),
+		},
+		{
+			name:     "HashShardedPaths (Depth, Width)",
+			strategy: esgallery.HashShardedPaths{Depth: 3, Width: 1},
+			want:     regexp.MustCompile(`^[0-9a-f]/[0-9a-f]/[0-9a-f]/a7d3b4e6-24f6-4a6b-8d43-6ab0c1e2f3a4/stack/image/example\.jpg$`),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.strategy.Path(info)
+			if !tt.want.MatchString(p) {
+				t.Fatalf("Path() should match %q; got %q", tt.want, p)
+			}
+
+			if p2 := tt.strategy.Path(info); p2 != p {
+				t.Fatalf("Path() should be deterministic; got %q and %q", p, p2)
+			}
+		})
+	}
+}
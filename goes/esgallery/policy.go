@@ -0,0 +1,101 @@
+package esgallery
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/modernice/media-entity/image"
+)
+
+var (
+	// ErrFileTooLarge is returned if an uploaded file exceeds the maximum file
+	// size of the [UploadPolicy].
+	ErrFileTooLarge = errors.New("file too large")
+
+	// ErrUnsupportedType is returned if an uploaded file is not an image that
+	// can be decoded, or if its content type is not allowed by the [UploadPolicy].
+	ErrUnsupportedType = errors.New("unsupported file type")
+
+	// ErrImageTooSmall is returned if the width or height of an uploaded image
+	// is below the minimum of the [UploadPolicy].
+	ErrImageTooSmall = errors.New("image too small")
+
+	// ErrImageTooLarge is returned if the width, height, or pixel count of an
+	// uploaded image exceeds the maximum of the [UploadPolicy].
+	ErrImageTooLarge = errors.New("image too large")
+)
+
+// UploadPolicy validates new images before they are uploaded by an [*Uploader].
+// Pass an UploadPolicy to [NewUploader] using the [WithUploadPolicy] option.
+// Zero values disable the corresponding check. Variants that are uploaded by a
+// [*Processor] are not validated.
+type UploadPolicy struct {
+	// MaxFileSize is the maximum file size in bytes.
+	MaxFileSize int64
+
+	// MinWidth and MinHeight are the minimum dimensions in pixels.
+	MinWidth, MinHeight int
+
+	// MaxWidth and MaxHeight are the maximum dimensions in pixels.
+	MaxWidth, MaxHeight int
+
+	// MaxMegapixels is the maximum number of pixels, in millions. Because the
+	// dimensions are read from the image header, MaxMegapixels protects
+	// against decompression bombs before an image is decoded.
+	MaxMegapixels float64
+
+	// ContentTypes are the allowed content types, for example "image/jpeg".
+	// A content type may end with a wildcard, for example "image/*".
+	ContentTypes []string
+}
+
+// Validate validates an image with the given content type, dimensions, and
+// file size against the policy. A negative size skips the file size check.
+func (p UploadPolicy) Validate(contentType string, dims image.Dimensions, size int64) error {
+	if len(p.ContentTypes) > 0 && !p.allows(contentType) {
+		return fmt.Errorf("%w: %s", ErrUnsupportedType, contentType)
+	}
+
+	width, height := dims[0], dims[1]
+
+	if width < p.MinWidth || height < p.MinHeight {
+		return fmt.Errorf("%w: %dx%d is below the minimum of %dx%d", ErrImageTooSmall, width, height, p.MinWidth, p.MinHeight)
+	}
+
+	if (p.MaxWidth > 0 && width > p.MaxWidth) || (p.MaxHeight > 0 && height > p.MaxHeight) {
+		return fmt.Errorf("%w: %dx%d exceeds the maximum of %dx%d", ErrImageTooLarge, width, height, p.MaxWidth, p.MaxHeight)
+	}
+
+	if megapixels := float64(width) * float64(height) / 1e6; p.MaxMegapixels > 0 && megapixels > p.MaxMegapixels {
+		return fmt.Errorf("%w: %.1f megapixels exceed the maximum of %.1f megapixels", ErrImageTooLarge, megapixels, p.MaxMegapixels)
+	}
+
+	if size >= 0 && p.MaxFileSize > 0 && size > p.MaxFileSize {
+		return p.fileTooLarge()
+	}
+
+	return nil
+}
+
+func (p UploadPolicy) allows(contentType string) bool {
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	contentType = strings.TrimSpace(contentType)
+
+	for _, allowed := range p.ContentTypes {
+		if allowed == contentType {
+			return true
+		}
+		if strings.HasSuffix(allowed, "*") && strings.HasPrefix(contentType, strings.TrimSuffix(allowed, "*")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p UploadPolicy) fileTooLarge() error {
+	return fmt.Errorf("%w: file exceeds the maximum of %d bytes", ErrFileTooLarge, p.MaxFileSize)
+}
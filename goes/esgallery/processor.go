@@ -1,8 +1,8 @@
 package esgallery
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	stdimage "image"
 	"io"
@@ -98,8 +98,19 @@ type ProcessableGallery[StackID, ImageID ID] interface {
 	// Tag adds tags to a [gallery.Stack].
 	Tag(StackID, ...string) (gallery.Stack[StackID, ImageID], error)
 
+	// MarkAsProcessing marks a [gallery.Stack] as currently being processed by
+	// a post-processor.
+	MarkAsProcessing(stackID StackID, attempt int)
+
+	// MarkAsFailed marks the processing of a [gallery.Stack] as failed.
+	MarkAsFailed(stackID StackID, reason string, attempt int)
+
 	// MarkAsProcessed marks a [gallery.Stack] as being processed by a post-processor.
 	MarkAsProcessed(StackID)
+
+	// ProcessedStacks returns the ids of the [gallery.Stack]s that have been
+	// processed by a post-processor.
+	ProcessedStacks() []StackID
 }
 
 // WasProcessed returns whether the given [gallery.Stack] was processed by a [*PostProcessor].
@@ -111,7 +122,10 @@ func WasProcessed[StackID, ImageID gallery.ID](s gallery.Stack[StackID, ImageID]
 type ApplyResultOption func(*applyResultConfig)
 
 // ClearStack returns an [ApplyResultOption] that clears the variants of the
-// [gallery.Stack] before adding the processed variants to the Stack.
+// [gallery.Stack] before adding the processed variants to the Stack. Results
+// that were triggered by a replaced original image ([VariantReplaced]) clear
+// the Stack by default, because the existing variants were derived from the
+// previous original.
 func ClearStack(clear bool) ApplyResultOption {
 	return func(cfg *applyResultConfig) {
 		cfg.clearStack = clear
@@ -124,7 +138,7 @@ type applyResultConfig struct {
 
 // ApplyProcessorResult applies a [ProcessorResult] to a Gallery by raising the appropriate events.
 func (r ProcessorResult[StackID, ImageID]) Apply(g ProcessableGallery[StackID, ImageID], opts ...ApplyResultOption) error {
-	var cfg applyResultConfig
+	cfg := applyResultConfig{clearStack: r.replacedOriginal()}
 	for _, opt := range opts {
 		opt(&cfg)
 	}
@@ -161,6 +175,10 @@ func (r ProcessorResult[StackID, ImageID]) Apply(g ProcessableGallery[StackID, I
 	return nil
 }
 
+func (r ProcessorResult[StackID, ImageID]) replacedOriginal() bool {
+	return r.Trigger != nil && r.Trigger.Name() == VariantReplaced
+}
+
 // // Apply calls ApplyProcessorResult(result, g, opts...).
 // func (result ProcessorResult[StackID, ImageID]) Apply(g ProcessableGallery[StackID, ImageID], opts ...ApplyResultOption) error {
 // 	return ApplyProcessorResult(result, g, opts...)
@@ -185,7 +203,9 @@ func NewProcessor[StackID, ImageID ID](
 // ([StackProvider]). The returned [ProcessorResult] can be applied to
 // (gallery) aggregates to actually add the processed images to a gallery.
 // The provided [image.Pipeline] runs on the original image of the
-// [gallery.Stack].
+// [gallery.Stack], which is read from the storage path that is recorded in
+// its [image.Storage]. The EXIF orientation of the original image is applied
+// before the pipeline runs, so that the processed images are upright.
 //
 // The returned [ProcessorResult] can be applied to a gallery aggregate by
 // calling [ProcessorResult.Apply]. Appropriate events will be raised to replace
@@ -209,23 +229,36 @@ func (p *Processor[StackID, ImageID]) Process(
 	original := stack.Original()
 
 	galleryID, galleryName, _ := g.Aggregate()
-	path := variantPath(galleryID, stackID, original.ID, original.Filename)
 
 	// Fetch the original image from storage
-	r, err := p.storage.Get(ctx, path)
+	r, err := getFile(ctx, p.storage, original.Storage)
 	if err != nil {
 		return zeroResult[StackID, ImageID](), fmt.Errorf("storage: %w", err)
 	}
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
 
-	// Detect content-type while decoding image
+	// Detect content-type and orientation while decoding image
 	var detectCT detectContentType
-	r = io.TeeReader(r, &detectCT)
+	var header headerBuffer
+	r = io.TeeReader(io.TeeReader(r, &detectCT), &header)
 
 	img, _, err := stdimage.Decode(r)
 	if err != nil {
 		return zeroResult[StackID, ImageID](), fmt.Errorf("decode original image: %w", err)
 	}
 
+	// Rotate the image upright, because the processed images are encoded
+	// without the EXIF orientation of the original image. The metadata of the
+	// original was read from the whole file when it was uploaded, while the
+	// header only contains the start of the file.
+	orientation := header.orientation()
+	if original.Metadata != nil {
+		orientation = original.Metadata.Orientation
+	}
+	img = orient(img, orientation)
+
 	contentType := detectCT.ContentType()
 
 	result, err := pipeline.Run(ctx, img)
@@ -244,16 +277,11 @@ func (p *Processor[StackID, ImageID]) Process(
 			variantID = p.newVariantID()
 		}
 
-		// Encode the variant into the original image format that was detected earlier.
-		var buf bytes.Buffer
-		if err := p.encoding.Encode(&buf, contentType, pimg.Image); err != nil {
-			return zeroResult[StackID, ImageID](), fmt.Errorf("encode processed image: %w", err)
-		}
-
-		// Upload the variant to storage.
-		uploaded, err := p.uploader.UploadVariant(ctx, g, stackID, variantID, &buf)
+		// Encode the variant into the original image format that was detected
+		// earlier, and stream it to storage while it is being encoded.
+		uploaded, err := p.encodeAndUpload(ctx, g, stackID, variantID, contentType, pimg.Image)
 		if err != nil {
-			return zeroResult[StackID, ImageID](), fmt.Errorf("upload processed image: %w", err)
+			return zeroResult[StackID, ImageID](), err
 		}
 
 		// Mark the image in the gallery as the original, if it is the original image.
@@ -279,10 +307,46 @@ func (p *Processor[StackID, ImageID]) Process(
 	}, nil
 }
 
+func (p *Processor[StackID, ImageID]) encodeAndUpload(
+	ctx context.Context,
+	g ProcessableGallery[StackID, ImageID],
+	stackID StackID,
+	variantID ImageID,
+	contentType string,
+	img stdimage.Image,
+) (gallery.Image[ImageID], error) {
+	pr, pw := io.Pipe()
+
+	encodeErr := make(chan error, 1)
+	go func() {
+		err := p.encoding.Encode(pw, contentType, img)
+		pw.CloseWithError(err)
+		encodeErr <- err
+	}()
+
+	uploaded, err := p.uploader.UploadVariant(ctx, g, stackID, variantID, pr)
+
+	// Unblock the encoder if the upload stopped reading early.
+	pr.Close()
+
+	if err := <-encodeErr; err != nil && !errors.Is(err, io.ErrClosedPipe) {
+		return gallery.Image[ImageID]{}, fmt.Errorf("encode processed image: %w", err)
+	}
+
+	if err != nil {
+		return gallery.Image[ImageID]{}, fmt.Errorf("upload processed image: %w", err)
+	}
+
+	return uploaded, nil
+}
+
 // PostProcessor is a post-processor for gallery images. Whenever a new
 // [gallery.Stack] is added to a gallery, or whenever the original image of a
 // [gallery.Stack] is replaced, the post-processor is triggered to post-process
-// that [gallery.Stack].
+// that [gallery.Stack]. Replacing only the names, descriptions, or tags of the
+// original image does not trigger the post-processor. When a result that was
+// triggered by a replaced original is applied, the variants that were derived
+// from the previous original are removed from the [gallery.Stack].
 //
 // # Example
 //
@@ -310,6 +374,9 @@ type PostProcessor[
 	// autoSave is only valid/used if autoApply is true
 	autoSave  func(context.Context, Gallery) error
 	autoApply bool
+
+	// requeue receives the trigger events of requeued [DeadLetter]s.
+	requeue chan event.Event
 }
 
 // PostProcessorOption is an option for [NewPostProcessor].
@@ -320,7 +387,9 @@ type PostProcessorOption[
 
 // WithAutoApply returns a [PostProcessorOption] that automatically applies
 // [ProcessorResult]s to gallery aggregates. If the provided `save` function
-// is non-nil, galleries will also be saved after applying the result.
+// is non-nil, galleries will also be saved after applying the result, and the
+// processing lifecycle of each [gallery.Stack] is recorded on the galleries by
+// raising [StackProcessingStarted] and [StackProcessingFailed] events.
 func WithAutoApply[
 	StackID, ImageID ID,
 	Gallery ProcessableGallery[StackID, ImageID],
@@ -346,6 +415,7 @@ func NewPostProcessor[
 		processor:    p,
 		bus:          bus,
 		fetchGallery: fetchGallery,
+		requeue:      make(chan event.Event),
 	}
 	for _, opt := range opts {
 		opt(pp)
@@ -361,6 +431,10 @@ type runProcessorConfig struct {
 	discardResults bool
 	debug          bool
 	eventFilters   []func(event.Event) bool
+	jobs           JobStore
+	eventStore     event.Store
+	retry          RetryPolicy
+	deadLetters    DeadLetterSink
 }
 
 // Workers returns a [RunProcessorOption] that sets the number of workers for
@@ -439,7 +513,7 @@ func (pp *PostProcessor[Gallery, StackID, ImageID]) Run(ctx context.Context, pip
 
 	results := make(chan ProcessorResult[StackID, ImageID])
 	processorErrors := make(chan error)
-	outErrors := streams.FanInAll(errs, processorErrors)
+	outErrors := []<-chan error{errs, processorErrors}
 
 	queue := processorQueue[Gallery, StackID, ImageID]{
 		ctx:       ctx,
@@ -451,9 +525,20 @@ func (pp *PostProcessor[Gallery, StackID, ImageID]) Run(ctx context.Context, pip
 		errs:      processorErrors,
 	}
 
+	if cfg.jobs != nil {
+		cfg.debugLog("resuming post-processing jobs ...")
+
+		resumed, resumeErrs, err := queue.resume(events)
+		if err != nil {
+			return nil, nil, fmt.Errorf("resume jobs: %w", err)
+		}
+		queue.events = resumed
+		outErrors = append(outErrors, resumeErrs)
+	}
+
 	go queue.run()
 
-	return results, outErrors, nil
+	return results, streams.FanInAll(outErrors...), nil
 }
 
 type processorQueue[Gallery ProcessableGallery[StackID, ImageID], StackID, ImageID ID] struct {
@@ -483,55 +568,121 @@ func (q *processorQueue[Gallery, StackID, ImageID]) run() {
 }
 
 func (q *processorQueue[Gallery, StackID, ImageID]) work() {
-	for evt := range q.events {
-		if !q.shouldProcess(evt) {
-			continue
+	for {
+		var evt event.Event
+
+		select {
+		case e, ok := <-q.events:
+			if !ok {
+				return
+			}
+			if !q.shouldProcess(e) {
+				continue
+			}
+			evt = e
+		case evt = <-q.processor.requeue:
+			q.cfg.debugLog("received requeued %q event [id=%s]", evt.Name(), evt.ID())
 		}
 
-		var (
-			result     ProcessorResult[StackID, ImageID]
-			err        error
-			shouldPush = true
-			start      = time.Now()
-		)
+		q.handle(evt)
+	}
+}
 
-		q.cfg.debugLog("handling %q event ...", evt.Name())
+func (q *processorQueue[Gallery, StackID, ImageID]) handle(evt event.Event) {
+	var (
+		result     ProcessorResult[StackID, ImageID]
+		err        error
+		shouldPush bool
+		attempts   int
+		start      = time.Now()
+		galleryID  = pick.AggregateID(evt)
+	)
 
-		switch evt.Name() {
-		case StackAdded:
-			result, err = q.stackAdded(event.Cast[gallery.Stack[StackID, ImageID]](evt))
-			// case VariantReplaced:
-			// 	result, shouldPush, err = q.variantReplaced(event.Cast[VariantReplacedData[StackID, ImageID]](evt))
-		}
+	q.cfg.debugLog("handling %q event ...", evt.Name())
 
-		if err != nil {
-			q.fail(fmt.Errorf("handle %q event: %w", evt.Name(), err))
-			continue
-		}
+	q.updateJob(evt, JobRunning)
 
-		result.Trigger = evt
-		result.Runtime = time.Since(start)
+	for {
+		attempts++
 
-		galleryID := pick.AggregateID(evt)
+		if result, shouldPush, err = q.attempt(evt, start, attempts); err == nil {
+			break
+		}
 
-		if q.processor.autoApply {
-			if err := q.apply(&result, galleryID); err != nil {
-				q.fail(fmt.Errorf("apply result: %w", err))
-				continue
-			}
+		q.markFailed(evt, attempts, err)
+
+		delay, retry := q.cfg.retry.next(attempts, err)
+		if !retry || q.ctx.Err() != nil {
+			break
 		}
 
-		if q.cfg.discardResults {
-			q.cfg.debugLog("discarding processing result [galleryId=%s, stackId=%s]", galleryID, result.StackID)
-			continue
+		q.cfg.debugLog("attempt %d failed, retrying in %v: %v [galleryId=%s]", attempts, delay, err, galleryID)
+
+		select {
+		case <-q.ctx.Done():
+		case <-time.After(delay):
 		}
+	}
+
+	if err != nil {
+		q.updateJob(evt, JobFailed)
+		q.deadLetter(evt, attempts, err)
+		q.fail(err)
+		return
+	}
+
+	if !shouldPush {
+		q.cfg.debugLog("skipping %q event [galleryId=%s]", evt.Name(), galleryID)
+		q.updateJob(evt, JobDone)
+		return
+	}
+
+	q.updateJob(evt, JobDone)
+
+	if q.cfg.discardResults {
+		q.cfg.debugLog("discarding processing result [galleryId=%s, stackId=%s]", galleryID, result.StackID)
+		return
+	}
+
+	// Update the Runtime because q.apply() might have taken some time.
+	result.Runtime = time.Since(start)
+	q.push(result)
+}
 
-		if shouldPush {
-			// Update the Runtime because q.apply() might have taken some time.
-			result.Runtime = time.Since(start)
-			q.push(result)
+// attempt runs the processor for the given trigger event once, and applies
+// the result if the [WithAutoApply] option is enabled.
+func (q *processorQueue[Gallery, StackID, ImageID]) attempt(evt event.Event, start time.Time, n int) (zero ProcessorResult[StackID, ImageID], _ bool, _ error) {
+	var (
+		result     ProcessorResult[StackID, ImageID]
+		err        error
+		shouldPush = true
+	)
+
+	switch evt.Name() {
+	case StackAdded:
+		result, err = q.stackAdded(event.Cast[gallery.Stack[StackID, ImageID]](evt), n)
+	case VariantReplaced:
+		result, shouldPush, err = q.variantReplaced(event.Cast[VariantReplacedData[StackID, ImageID]](evt), n)
+	}
+
+	if err != nil {
+		return zero, false, fmt.Errorf("handle %q event: %w", evt.Name(), err)
+	}
+
+	if !shouldPush {
+		return zero, false, nil
+	}
+
+	result.Trigger = evt
+	result.Runtime = time.Since(start)
+
+	if q.processor.autoApply {
+		if err := q.apply(&result, pick.AggregateID(evt)); err != nil {
+			return zero, false, fmt.Errorf("apply result: %w", err)
 		}
 	}
+
+	return result, true, nil
 }
 
 func (q *processorQueue[Gallery, StackID, ImageID]) shouldProcess(evt event.Event) bool {
@@ -589,6 +740,56 @@ func (q *processorQueue[Gallery, StackID, ImageID]) apply(result *ProcessorResul
 	}
 }
 
+// markProcessing raises the [StackProcessingStarted] event on the gallery.
+func (q *processorQueue[Gallery, StackID, ImageID]) markProcessing(galleryID uuid.UUID, stackID StackID, attempt int) {
+	if err := q.record(galleryID, func(g Gallery) { g.MarkAsProcessing(stackID, attempt) }); err != nil {
+		q.fail(fmt.Errorf("mark stack as processing [galleryId=%s, stackId=%s]: %w", galleryID, stackID, err))
+	}
+}
+
+// markFailed raises the [StackProcessingFailed] event on the gallery.
+func (q *processorQueue[Gallery, StackID, ImageID]) markFailed(evt event.Event, attempt int, reason error) {
+	stackID, ok := triggerStackID[StackID, ImageID](evt)
+	if !ok || q.ctx.Err() != nil {
+		return
+	}
+
+	galleryID := pick.AggregateID(evt)
+	if err := q.record(galleryID, func(g Gallery) { g.MarkAsFailed(stackID, reason.Error(), attempt) }); err != nil {
+		q.fail(fmt.Errorf("mark stack as failed [galleryId=%s, stackId=%s]: %w", galleryID, stackID, err))
+	}
+}
+
+// record raises processing lifecycle events on a gallery by calling the
+// provided function, and saves the gallery. Lifecycle events are only recorded
+// if the [WithAutoApply] option is enabled with a "save" function.
+func (q *processorQueue[Gallery, StackID, ImageID]) record(galleryID uuid.UUID, raise func(Gallery)) error {
+	if !q.processor.autoApply || q.processor.autoSave == nil {
+		return nil
+	}
+
+	var tries int
+	for {
+		tries++
+
+		g, err := q.processor.fetchGallery(q.ctx, galleryID)
+		if err != nil {
+			return fmt.Errorf("fetch gallery: %w", err)
+		}
+
+		raise(g)
+
+		if err := q.processor.autoSave(q.ctx, g); err != nil {
+			if aggregate.IsConsistencyError(err) && tries < autoSaveMaxTries {
+				continue
+			}
+			return fmt.Errorf("save gallery: %w", err)
+		}
+
+		return nil
+	}
+}
+
 func (q *processorQueue[Gallery, StackID, ImageID]) fail(err error) {
 	select {
 	case <-q.ctx.Done():
@@ -603,7 +804,7 @@ func (q *processorQueue[Gallery, StackID, ImageID]) push(r ProcessorResult[Stack
 	}
 }
 
-func (q *processorQueue[Gallery, StackID, ImageID]) stackAdded(evt event.Of[gallery.Stack[StackID, ImageID]]) (zero ProcessorResult[StackID, ImageID], _ error) {
+func (q *processorQueue[Gallery, StackID, ImageID]) stackAdded(evt event.Of[gallery.Stack[StackID, ImageID]], attempt int) (zero ProcessorResult[StackID, ImageID], _ error) {
 	galleryID := pick.AggregateID(evt)
 	g, err := q.processor.fetchGallery(q.ctx, galleryID)
 	if err != nil {
@@ -616,6 +817,8 @@ func (q *processorQueue[Gallery, StackID, ImageID]) stackAdded(evt event.Of[gall
 		return zero, fmt.Errorf("%w [galleryId=%s, stackId=%s]", gallery.ErrStackNotFound, galleryID, stack.ID)
 	}
 
+	q.markProcessing(galleryID, stack.ID, attempt)
+
 	q.cfg.debugLog("running processor on stack ... [galleryId=%s, stackId=%s]", galleryID, stack.ID)
 
 	result, err := q.processor.processor.Process(q.ctx, q.pipeline, g, stack.ID)
@@ -626,32 +829,50 @@ func (q *processorQueue[Gallery, StackID, ImageID]) stackAdded(evt event.Of[gall
 	return result, nil
 }
 
-// func (q *processorQueue[
-// 	Gallery,
-// 	StackID, ImageID,
-// ]) variantReplaced(evt event.Of[VariantReplacedData[StackID, ImageID]]) (
-// 	zero ProcessorResult[StackID, ImageID],
-// 	_ bool, _ error,
-// ) {
-// 	galleryID := pick.AggregateID(evt)
-// 	g, err := q.processor.fetchGallery(q.ctx, galleryID)
-// 	if err != nil {
-// 		return zero, false, fmt.Errorf("fetch gallery: %w", err)
-// 	}
+func (q *processorQueue[
+	Gallery,
+	StackID, ImageID,
+]) variantReplaced(evt event.Of[VariantReplacedData[StackID, ImageID]], attempt int) (
+	zero ProcessorResult[StackID, ImageID],
+	_ bool, _ error,
+) {
+	data := evt.Data()
 
-// 	data := evt.Data()
+	// Moving the original to another storage location does not change the file.
+	if !data.Variant.Original || data.Moved {
+		return zero, false, nil
+	}
 
-// 	if !data.Variant.Original {
-// 		return zero, false, nil
-// 	}
+	galleryID := pick.AggregateID(evt)
+	g, err := q.processor.fetchGallery(q.ctx, galleryID)
+	if err != nil {
+		return zero, false, fmt.Errorf("fetch gallery: %w", err)
+	}
 
-// 	result, err := q.processor.processor.Process(q.ctx, q.pipeline, g, data.StackID)
-// 	if err != nil {
-// 		return result, false, fmt.Errorf("run processor: %w", err)
-// 	}
+	if _, ok := g.Stack(data.StackID); !ok {
+		return zero, false, fmt.Errorf("%w [galleryId=%s, stackId=%s]", gallery.ErrStackNotFound, galleryID, data.StackID)
+	}
 
-// 	return result, true, nil
-// }
+	// The gallery unmarks a stack as processed when the file of its original
+	// image changes. If the stack is still marked as processed, either only the
+	// metadata of the original was replaced, or the replacement was raised by
+	// applying a [ProcessorResult].
+	if slices.Contains(g.ProcessedStacks(), data.StackID) {
+		q.cfg.debugLog("original image unchanged since last processing [galleryId=%s, stackId=%s]", galleryID, data.StackID)
+		return zero, false, nil
+	}
+
+	q.markProcessing(galleryID, data.StackID, attempt)
+
+	q.cfg.debugLog("running processor on stack with replaced original ... [galleryId=%s, stackId=%s]", galleryID, data.StackID)
+
+	result, err := q.processor.processor.Process(q.ctx, q.pipeline, g, data.StackID)
+	if err != nil {
+		return result, false, fmt.Errorf("run processor: %w", err)
+	}
+
+	return result, true, nil
+}
 
 func (cfg runProcessorConfig) debugLog(format string, args ...any) {
 	if cfg.debug {
@@ -1,18 +1,27 @@
 package esgallery_test
 
 import (
+	"bytes"
 	"context"
+	"image/jpeg"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/google/uuid"
 	"github.com/modernice/goes/aggregate/repository"
+	"github.com/modernice/goes/event"
 	"github.com/modernice/goes/event/eventbus"
 	"github.com/modernice/goes/event/eventstore"
+	"github.com/modernice/goes/event/query"
+	"github.com/modernice/goes/helper/streams"
 	"github.com/modernice/goes/test"
 	"github.com/modernice/media-entity/gallery"
 	"github.com/modernice/media-entity/goes/esgallery"
+	"github.com/modernice/media-entity/image"
 	"github.com/modernice/media-entity/internal/galleryx"
+	"github.com/modernice/media-entity/internal/slicex"
 	"github.com/modernice/media-entity/internal/testcmp"
 	"github.com/modernice/media-entity/internal/testx"
 	imgtools "github.com/modernice/media-tools/image"
@@ -38,27 +47,180 @@ func TestProcessor_Process(t *testing.T) {
 
 	g := NewTestGallery(uuid.New())
 
-	r := newExample()
-	originalVariant := galleryx.NewImage(uuid.New())
-	stack, _ := g.NewStack(uuid.New(), originalVariant)
+	stackID := uuid.New()
+	originalVariant := uploadOriginal(t, &storage, g, stackID)
+	stack, _ := g.NewStack(stackID, originalVariant)
 
 	if esgallery.WasProcessed(stack) {
 		t.Fatalf("WasProcessed() with fresh Stack should return false")
 	}
 
-	_, err := uploader.UploadVariant(ctx, g, stack.ID, originalVariant.ID, r)
+	result, err := pp.Process(ctx, pipeline, g, stack.ID)
+	if err != nil {
+		t.Fatalf("process stack: %v", err)
+	}
+
+	testProcessorResult(t, result, &storage, g, stack)
+}
+
+func TestProcessor_Process_WithPathStrategy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var storage esgallery.MemoryStorage
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage, esgallery.WithPathStrategy(esgallery.HashShardedPaths{}))
+
+	g := NewTestGallery(uuid.New())
+
+	stack, err := uploader.UploadNew(ctx, g, uuid.New(), uuid.New(), newExample(), "example.jpg")
 	if err != nil {
 		t.Fatalf("upload original image: %v", err)
 	}
 
+	// The original was uploaded using the previous strategy.
+	uploader = esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage, esgallery.WithPathStrategy(esgallery.DateShardedPaths{}))
+	pp := esgallery.NewProcessor(esgallery.DefaultEncoder, &storage, uploader, uuid.New)
+
+	pipeline := imgtools.Pipeline{
+		imgtools.Resize(imgtools.DimensionMap{"sm": {640}}),
+	}
+
 	result, err := pp.Process(ctx, pipeline, g, stack.ID)
 	if err != nil {
 		t.Fatalf("process stack: %v", err)
 	}
 
-	testProcessorResult(t, result, &storage, g, stack)
+	datePrefix := time.Now().UTC().Format("2006/01/02/")
+	for _, img := range result.Images {
+		if !strings.HasPrefix(img.Image.Storage.Path, datePrefix) {
+			t.Fatalf("processed image should be stored at a date-sharded path; got %q", img.Image.Storage.Path)
+		}
+	}
+}
+
+func TestProcessor_Process_orientation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// EXIF data with orientation 6, which rotates the image 90 degrees clockwise.
+	exif := []byte("Exif\x00\x00MM\x00*\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x06\x00\x00\x00\x00\x00\x00")
+	landscape := newNoiseJPEG(t, 64, 32)
+	segment := append([]byte{0xFF, 0xE1, 0, byte(len(exif) + 2)}, exif...)
+	file := append(append(append([]byte{}, landscape[:2]...), segment...), landscape[2:]...)
+
+	var storage esgallery.MemoryStorage
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
+	pp := esgallery.NewProcessor(esgallery.DefaultEncoder, &storage, uploader, uuid.New)
+
+	g := NewTestGallery(uuid.New())
+
+	stack, err := uploader.UploadNew(ctx, g, uuid.New(), uuid.New(), bytes.NewReader(file), "portrait.jpg")
+	if err != nil {
+		t.Fatalf("upload original image: %v", err)
+	}
+
+	if dim := stack.Original().Dimensions; dim.Width() != 32 || dim.Height() != 64 {
+		t.Fatalf("dimensions of the original should reflect its orientation; got %v", dim)
+	}
+
+	pipeline := imgtools.Pipeline{
+		imgtools.Resize(imgtools.DimensionMap{"sm": {16}}),
+	}
+
+	result, err := pp.Process(ctx, pipeline, g, stack.ID)
+	if err != nil {
+		t.Fatalf("process stack: %v", err)
+	}
+
+	for _, img := range result.Images {
+		if dim := img.Image.Dimensions; dim.Width() >= dim.Height() {
+			t.Fatalf("processed image should be upright; got dimensions %v", dim)
+		}
+	}
+}
+
+func TestProcessor_Process_metadata(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	file := newPNGWithEXIF(t, 64, 32, newEXIF(6), 0)
+
+	var storage esgallery.MemoryStorage
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
+	pp := esgallery.NewProcessor(esgallery.DefaultEncoder, &storage, uploader, uuid.New)
+
+	g := NewTestGallery(uuid.New())
+
+	stack, err := uploader.UploadNew(ctx, g, uuid.New(), uuid.New(), bytes.NewReader(file), "portrait.png")
+	if err != nil {
+		t.Fatalf("upload original image: %v", err)
+	}
+
+	gps := &image.GPS{Latitude: 48.5, Longitude: 11.25}
+	if diff := cmp.Diff(&image.Metadata{Orientation: 6, GPS: gps}, stack.Original().Metadata); diff != "" {
+		t.Fatalf("metadata should be parsed from the original (-want +got):\n%s", diff)
+	}
+
+	pipeline := imgtools.Pipeline{
+		imgtools.Resize(imgtools.DimensionMap{"sm": {16}}),
+	}
+
+	result, err := pp.Process(ctx, pipeline, g, stack.ID)
+	if err != nil {
+		t.Fatalf("process stack: %v", err)
+	}
+
+	if err := result.Apply(g); err != nil {
+		t.Fatalf("apply result: %v", err)
+	}
+
+	processed, _ := g.Stack(stack.ID)
+	if len(processed.Variants) < 2 {
+		t.Fatalf("processed stack should have variants; has %d", len(processed.Variants))
+	}
+
+	// Variants are encoded upright.
+	want := &image.Metadata{Orientation: 1, GPS: gps}
+	for _, variant := range processed.Variants {
+		if diff := cmp.Diff(want, variant.Metadata); diff != "" {
+			t.Fatalf("variant %s should keep the metadata of the original (-want +got):\n%s", variant.ID, diff)
+		}
+	}
 }
 
+func TestProcessor_Process_orientationAfterHeader(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The EXIF orientation is stored after the header of the original image.
+	file := newPNGWithEXIF(t, 64, 32, newEXIF(6), 2<<20)
+
+	var storage esgallery.MemoryStorage
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
+	pp := esgallery.NewProcessor(esgallery.DefaultEncoder, &storage, uploader, uuid.New)
+
+	g := NewTestGallery(uuid.New())
+
+	stack, err := uploader.UploadNew(ctx, g, uuid.New(), uuid.New(), bytes.NewReader(file), "portrait.png")
+	if err != nil {
+		t.Fatalf("upload original image: %v", err)
+	}
+
+	pipeline := imgtools.Pipeline{
+		imgtools.Resize(imgtools.DimensionMap{"sm": {16}}),
+	}
+
+	result, err := pp.Process(ctx, pipeline, g, stack.ID)
+	if err != nil {
+		t.Fatalf("process stack: %v", err)
+	}
+
+	for _, img := range result.Images {
+		if dim := img.Image.Dimensions; dim.Width() >= dim.Height() {
+			t.Fatalf("processed image should be upright; got dimensions %v", dim)
+		}
+	}
+}
 func TestProcessor_Run_stackAdded(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -82,14 +244,9 @@ func TestProcessor_Run_stackAdded(t *testing.T) {
 
 	g := NewTestGallery(uuid.New())
 
-	r := newExample()
-	originalVariant := galleryx.NewImage(uuid.New())
-	stack, _ := g.NewStack(uuid.New(), originalVariant)
-
-	_, err := uploader.UploadVariant(ctx, g, stack.ID, originalVariant.ID, r)
-	if err != nil {
-		t.Fatalf("upload original image: %v", err)
-	}
+	stackID := uuid.New()
+	originalVariant := uploadOriginal(t, &storage, g, stackID)
+	stack, _ := g.NewStack(stackID, originalVariant)
 
 	results, errs, err := pp.Run(ctx, pipeline)
 	if err != nil {
@@ -131,132 +288,314 @@ func TestProcessor_Run_stackAdded(t *testing.T) {
 	}
 }
 
-// func TestProcessor_Run_variantReplaced_original(t *testing.T) {
-// 	ctx, cancel := context.WithCancel(context.Background())
-// 	defer cancel()
-
-// 	var storage esgallery.MemoryStorage
-// 	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
-// 	ebus := eventbus.New()
-// 	estore := eventstore.WithBus(eventstore.New(), ebus)
-// 	repo := repository.New(estore)
-// 	galleries := repository.Typed(repo, NewTestGallery)
-// 	p := esgallery.NewProcessor(esgallery.DefaultEncoder, &storage, uploader, uuid.New)
-// 	pp := esgallery.NewPostProcessor(p, ebus, galleries.Fetch)
-
-// 	pipeline := imgtools.Pipeline{
-// 		imgtools.Resize(imgtools.DimensionMap{
-// 			"sm": {640},
-// 			"md": {960},
-// 			"lg": {1280},
-// 		}),
-// 	}
-
-// 	g := NewTestGallery(uuid.New())
-
-// 	r := newExample()
-// 	originalVariant := galleryx.NewImage(uuid.New())
-// 	stack, _ := g.NewStack(uuid.New(), originalVariant)
-
-// 	_, err := uploader.UploadVariant(ctx, g, stack.ID, originalVariant.ID, r)
-// 	if err != nil {
-// 		t.Fatalf("upload original image: %v", err)
-// 	}
-
-// 	if err := galleries.Save(ctx, g); err != nil {
-// 		t.Fatalf("save gallery: %v", err)
-// 	}
-
-// 	<-time.After(200 * time.Millisecond)
-
-// 	results, errs, err := pp.Run(ctx, pipeline)
-// 	if err != nil {
-// 		t.Fatalf("run pipeline: %v", err)
-// 	}
-// 	go testx.PanicOn(errs)
-
-// 	// Trigger post-processor
-// 	replacement := stack.Original()
-// 	if _, err := g.ReplaceVariant(stack.ID, replacement); err != nil {
-// 		t.Fatalf("replace variant: %v", err)
-// 	}
-// 	if err := galleries.Save(ctx, g); err != nil {
-// 		t.Fatalf("save gallery: %v", err)
-// 	}
-
-// 	var result esgallery.ProcessorResult[uuid.UUID, uuid.UUID]
-// 	select {
-// 	case <-time.After(time.Second):
-// 		t.Fatalf("timed out waiting for post-processor result")
-// 	case result = <-results:
-// 	}
-
-// 	testProcessorResult(t, result, &storage, g, stack)
-// }
-
-// func TestProcessor_Run_variantReplaced_variant(t *testing.T) {
-// 	ctx, cancel := context.WithCancel(context.Background())
-// 	defer cancel()
-
-// 	var storage esgallery.MemoryStorage
-// 	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
-// 	ebus := eventbus.New()
-// 	estore := eventstore.WithBus(eventstore.New(), ebus)
-// 	repo := repository.New(estore)
-// 	galleries := repository.Typed(repo, NewTestGallery)
-// 	p := esgallery.NewProcessor(esgallery.DefaultEncoder, &storage, uploader, uuid.New)
-// 	pp := esgallery.NewPostProcessor(p, ebus, galleries.Fetch)
-
-// 	pipeline := imgtools.Pipeline{
-// 		imgtools.Resize(imgtools.DimensionMap{
-// 			"sm": {640},
-// 			"md": {960},
-// 			"lg": {1280},
-// 		}),
-// 	}
-
-// 	g := NewTestGallery(uuid.New())
-
-// 	r := newExample()
-// 	originalVariant := galleryx.NewImage(uuid.New())
-// 	stack, _ := g.NewStack(uuid.New(), originalVariant)
-
-// 	_, err := uploader.UploadVariant(ctx, g, stack.ID, originalVariant.ID, r)
-// 	if err != nil {
-// 		t.Fatalf("upload original image: %v", err)
-// 	}
-
-// 	variantID := uuid.New()
-// 	stack, _ = g.NewVariant(stack.ID, variantID, galleryx.NewImage(uuid.New()).Image)
-
-// 	if err := galleries.Save(ctx, g); err != nil {
-// 		t.Fatalf("save gallery: %v", err)
-// 	}
-
-// 	<-time.After(200 * time.Millisecond)
-
-// 	results, errs, err := pp.Run(ctx, pipeline)
-// 	if err != nil {
-// 		t.Fatalf("run pipeline: %v", err)
-// 	}
-// 	go testx.PanicOn(errs)
-
-// 	// Trigger post-processor
-// 	replacement := stack.Last()
-// 	if _, err := g.ReplaceVariant(stack.ID, replacement); err != nil {
-// 		t.Fatalf("replace variant: %v", err)
-// 	}
-// 	if err := galleries.Save(ctx, g); err != nil {
-// 		t.Fatalf("save gallery: %v", err)
-// 	}
-
-// 	select {
-// 	case <-time.After(500 * time.Millisecond):
-// 		return
-// 	case <-results:
-// 		t.Fatalf("post-processor should not have triggered")
-// 	}
-// }
+func TestProcessor_Run_variantReplaced_original(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var storage esgallery.MemoryStorage
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
+	ebus := eventbus.New()
+	estore := eventstore.WithBus(eventstore.New(), ebus)
+	repo := repository.New(estore)
+	galleries := repository.Typed(repo, NewTestGallery)
+	p := esgallery.NewProcessor(esgallery.DefaultEncoder, &storage, uploader, uuid.New)
+	pp := esgallery.NewPostProcessor(p, ebus, galleries.Fetch)
+
+	pipeline := imgtools.Pipeline{
+		imgtools.Resize(imgtools.DimensionMap{
+			"sm": {640},
+			"md": {960},
+			"lg": {1280},
+		}),
+	}
+
+	g := NewTestGallery(uuid.New())
+
+	stackID := uuid.New()
+	originalVariant := uploadOriginal(t, &storage, g, stackID)
+	stack, _ := g.NewStack(stackID, originalVariant)
+
+	replacement, err := uploader.UploadVariant(ctx, g, stack.ID, originalVariant.ID, newExample())
+	if err != nil {
+		t.Fatalf("upload original image: %v", err)
+	}
+	replacement.Original = true
+
+	if err := galleries.Save(ctx, g); err != nil {
+		t.Fatalf("save gallery: %v", err)
+	}
+
+	<-time.After(200 * time.Millisecond)
+
+	results, errs, err := pp.Run(ctx, pipeline)
+	if err != nil {
+		t.Fatalf("run pipeline: %v", err)
+	}
+	go testx.PanicOn(errs)
+
+	// Trigger post-processor
+	if _, err := g.ReplaceVariant(stack.ID, replacement); err != nil {
+		t.Fatalf("replace variant: %v", err)
+	}
+	if err := galleries.Save(ctx, g); err != nil {
+		t.Fatalf("save gallery: %v", err)
+	}
+
+	var result esgallery.ProcessorResult[uuid.UUID, uuid.UUID]
+	select {
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for post-processor result")
+	case result = <-results:
+	}
+
+	if result.Trigger.Name() != esgallery.VariantReplaced {
+		t.Fatalf("result should be triggered by %q event; got %q", esgallery.VariantReplaced, result.Trigger.Name())
+	}
+
+	testProcessorResult(t, result, &storage, g, stack)
+}
+
+func TestProcessor_Run_variantReplaced_originalMetadata(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var storage esgallery.MemoryStorage
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
+	ebus := eventbus.New()
+	estore := eventstore.WithBus(eventstore.New(), ebus)
+	repo := repository.New(estore)
+	galleries := repository.Typed(repo, NewTestGallery)
+	p := esgallery.NewProcessor(esgallery.DefaultEncoder, &storage, uploader, uuid.New)
+	pp := esgallery.NewPostProcessor(p, ebus, galleries.Fetch, esgallery.WithAutoApply[uuid.UUID, uuid.UUID](true, galleries.Save))
+
+	pipeline := imgtools.Pipeline{
+		imgtools.Resize(imgtools.DimensionMap{
+			"sm": {640},
+		}),
+	}
+
+	g := NewTestGallery(uuid.New())
+
+	stack, err := uploader.UploadNew(ctx, g, uuid.New(), uuid.New(), newExample(), "example.jpg")
+	if err != nil {
+		t.Fatalf("upload original image: %v", err)
+	}
+
+	results, errs, err := pp.Run(ctx, pipeline)
+	if err != nil {
+		t.Fatalf("run pipeline: %v", err)
+	}
+	go testx.PanicOn(errs)
+
+	if err := galleries.Save(ctx, g); err != nil {
+		t.Fatalf("save gallery: %v", err)
+	}
+
+	select {
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for post-processor result")
+	case <-results:
+	}
+
+	if g, err = galleries.Fetch(ctx, g.ID); err != nil {
+		t.Fatalf("fetch gallery: %v", err)
+	}
+
+	// Only the names of the original change, so the post-processor must not be triggered.
+	stack, _ = g.Stack(stack.ID)
+	replacement := stack.Original().Clone()
+	replacement.Names["en"] = "Renamed"
+
+	if _, err := g.ReplaceVariant(stack.ID, replacement); err != nil {
+		t.Fatalf("replace variant: %v", err)
+	}
+	if err := galleries.Save(ctx, g); err != nil {
+		t.Fatalf("save gallery: %v", err)
+	}
+
+	select {
+	case <-time.After(500 * time.Millisecond):
+	case <-results:
+		t.Fatalf("post-processor should not have triggered")
+	}
+}
+
+func TestProcessor_Run_variantReplaced_variant(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var storage esgallery.MemoryStorage
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
+	ebus := eventbus.New()
+	estore := eventstore.WithBus(eventstore.New(), ebus)
+	repo := repository.New(estore)
+	galleries := repository.Typed(repo, NewTestGallery)
+	p := esgallery.NewProcessor(esgallery.DefaultEncoder, &storage, uploader, uuid.New)
+	pp := esgallery.NewPostProcessor(p, ebus, galleries.Fetch)
+
+	pipeline := imgtools.Pipeline{
+		imgtools.Resize(imgtools.DimensionMap{
+			"sm": {640},
+			"md": {960},
+			"lg": {1280},
+		}),
+	}
+
+	g := NewTestGallery(uuid.New())
+
+	stackID := uuid.New()
+	originalVariant := uploadOriginal(t, &storage, g, stackID)
+	stack, _ := g.NewStack(stackID, originalVariant)
+
+	variantID := uuid.New()
+	stack, _ = g.NewVariant(stack.ID, variantID, galleryx.NewImage(uuid.New()).Image)
+
+	if err := galleries.Save(ctx, g); err != nil {
+		t.Fatalf("save gallery: %v", err)
+	}
+
+	<-time.After(200 * time.Millisecond)
+
+	results, errs, err := pp.Run(ctx, pipeline)
+	if err != nil {
+		t.Fatalf("run pipeline: %v", err)
+	}
+	go testx.PanicOn(errs)
+
+	// Trigger post-processor
+	replacement := stack.Last()
+	replacement.Filesize = 54321
+	if _, err := g.ReplaceVariant(stack.ID, replacement); err != nil {
+		t.Fatalf("replace variant: %v", err)
+	}
+	if err := galleries.Save(ctx, g); err != nil {
+		t.Fatalf("save gallery: %v", err)
+	}
+
+	select {
+	case <-time.After(500 * time.Millisecond):
+		return
+	case <-results:
+		t.Fatalf("post-processor should not have triggered")
+	}
+}
+
+func TestProcessor_Run_variantReplaced_WithAutoApply(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var storage esgallery.MemoryStorage
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
+	ebus := eventbus.New()
+	estore := eventstore.WithBus(eventstore.New(), ebus)
+	repo := repository.New(estore)
+	galleries := repository.Typed(repo, NewTestGallery)
+	p := esgallery.NewProcessor(esgallery.DefaultEncoder, &storage, uploader, uuid.New)
+	pp := esgallery.NewPostProcessor(p, ebus, galleries.Fetch, esgallery.WithAutoApply[uuid.UUID, uuid.UUID](true, galleries.Save))
+
+	pipeline := imgtools.Pipeline{
+		imgtools.Resize(imgtools.DimensionMap{
+			"sm": {640},
+			"md": {960},
+			"lg": {1280},
+		}),
+	}
+
+	g := NewTestGallery(uuid.New())
+
+	stack, err := uploader.UploadNew(ctx, g, uuid.New(), uuid.New(), newExample(), "example.jpg")
+	if err != nil {
+		t.Fatalf("upload original image: %v", err)
+	}
+
+	results, errs, err := pp.Run(ctx, pipeline)
+	if err != nil {
+		t.Fatalf("run pipeline: %v", err)
+	}
+	go testx.PanicOn(errs)
+
+	if err := galleries.Save(ctx, g); err != nil {
+		t.Fatalf("save gallery: %v", err)
+	}
+
+	var first esgallery.ProcessorResult[uuid.UUID, uuid.UUID]
+	select {
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for post-processor result")
+	case first = <-results:
+	}
+
+	if g, err = galleries.Fetch(ctx, g.ID); err != nil {
+		t.Fatalf("fetch gallery: %v", err)
+	}
+
+	// Replace the original with a differently encoded file.
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, exampleImg, &jpeg.Options{Quality: 50}); err != nil {
+		t.Fatalf("encode replacement: %v", err)
+	}
+
+	replacement, err := uploader.UploadVariant(ctx, g, stack.ID, stack.Original().ID, &buf)
+	if err != nil {
+		t.Fatalf("upload replacement: %v", err)
+	}
+	replacement.Original = true
+
+	if _, err := g.ReplaceVariant(stack.ID, replacement); err != nil {
+		t.Fatalf("replace variant: %v", err)
+	}
+
+	if slices.Contains(g.ProcessedStacks(), stack.ID) {
+		t.Fatalf("ProcessedStacks() should not contain stack %s after replacing the original", stack.ID)
+	}
+
+	if err := galleries.Save(ctx, g); err != nil {
+		t.Fatalf("save gallery: %v", err)
+	}
+
+	var second esgallery.ProcessorResult[uuid.UUID, uuid.UUID]
+	select {
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for post-processor result")
+	case second = <-results:
+	}
+
+	if !second.Applied || !second.Saved {
+		t.Fatalf("result should be applied and saved; Applied=%v, Saved=%v", second.Applied, second.Saved)
+	}
+
+	if g, err = galleries.Fetch(ctx, g.ID); err != nil {
+		t.Fatalf("fetch gallery: %v", err)
+	}
+
+	stack, _ = g.Stack(stack.ID)
+
+	if len(stack.Variants) != len(second.Images) {
+		t.Fatalf("stack should have %d variants; has %d", len(second.Images), len(stack.Variants))
+	}
+
+	for _, stale := range first.Images[1:] {
+		if _, ok := stack.Variant(stale.Image.ID); ok {
+			t.Fatalf("stale variant %s should have been removed from the stack", stale.Image.ID)
+		}
+	}
+
+	for _, pimg := range second.Images {
+		if _, ok := stack.Variant(pimg.Image.ID); !ok {
+			t.Fatalf("processed variant %s not found in stack", pimg.Image.ID)
+		}
+	}
+
+	if !slices.Contains(g.ProcessedStacks(), stack.ID) {
+		t.Fatalf("expected ProcessedStacks() to contain stack %s\n%v", stack.ID, g.ProcessedStacks())
+	}
+
+	select {
+	case <-time.After(500 * time.Millisecond):
+	case <-results:
+		t.Fatalf("applying the result should not trigger the post-processor again")
+	}
+}
 
 func TestProcessor_Run_WithAutoApply(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
@@ -327,6 +666,83 @@ func TestProcessor_Run_WithAutoApply(t *testing.T) {
 	}
 }
 
+func TestProcessor_Run_WithAutoApply_lifecycle(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage := &flakyStorage{failures: 1}
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](storage)
+	ebus := eventbus.New()
+	estore := eventstore.WithBus(eventstore.New(), ebus)
+	repo := repository.New(estore)
+	galleries := repository.Typed(repo, NewTestGallery)
+	p := esgallery.NewProcessor(esgallery.DefaultEncoder, storage, uploader, uuid.New)
+	pp := esgallery.NewPostProcessor(p, ebus, galleries.Fetch, esgallery.WithAutoApply[uuid.UUID, uuid.UUID](true, galleries.Save))
+
+	pipeline := imgtools.Pipeline{
+		imgtools.Resize(imgtools.DimensionMap{"sm": {640}}),
+	}
+
+	g := NewTestGallery(uuid.New())
+
+	stack, err := uploader.UploadNew(ctx, g, uuid.New(), uuid.New(), newExample(), "example.jpg")
+	if err != nil {
+		t.Fatalf("upload original image: %v", err)
+	}
+
+	results, errs, err := pp.Run(ctx, pipeline, esgallery.Retry(esgallery.RetryPolicy{MaxAttempts: 2}))
+	if err != nil {
+		t.Fatalf("run pipeline: %v", err)
+	}
+	go testx.PanicOn(errs)
+
+	if err := galleries.Save(ctx, g); err != nil {
+		t.Fatalf("save gallery: %v", err)
+	}
+
+	select {
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for post-processor result")
+	case <-results:
+	}
+
+	str, errs, err := estore.Query(ctx, query.New(
+		query.Aggregate(g.AggregateName(), g.ID),
+		query.Name(esgallery.StackProcessingStarted, esgallery.StackProcessingFailed, esgallery.StackProcessed),
+		query.SortByAggregate(),
+	))
+	if err != nil {
+		t.Fatalf("query events: %v", err)
+	}
+
+	events, err := streams.Drain(ctx, str, errs)
+	if err != nil {
+		t.Fatalf("drain events: %v", err)
+	}
+
+	names := slicex.Map(events, func(evt event.Event) string { return evt.Name() })
+	want := []string{
+		esgallery.StackProcessingStarted,
+		esgallery.StackProcessingFailed,
+		esgallery.StackProcessingStarted,
+		esgallery.StackProcessed,
+	}
+	testcmp.Equal(t, "gallery should have recorded the processing lifecycle", want, names)
+
+	failed := event.Cast[esgallery.StackProcessingFailedData[uuid.UUID]](events[1]).Data()
+	if failed.StackID != stack.ID || failed.Attempt != 1 || !strings.Contains(failed.Reason, errUnavailable.Error()) {
+		t.Fatalf("invalid %q event data: %+v", esgallery.StackProcessingFailed, failed)
+	}
+
+	if g, err = galleries.Fetch(ctx, g.ID); err != nil {
+		t.Fatalf("fetch gallery: %v", err)
+	}
+
+	if status := g.ProcessingStatus(stack.ID); status.State != esgallery.Processed {
+		t.Fatalf("stack should be %q; is %q", esgallery.Processed, status.State)
+	}
+}
+
 func TestProcessor_Run_WithAutoApply_noSave(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -350,14 +766,9 @@ func TestProcessor_Run_WithAutoApply_noSave(t *testing.T) {
 
 	g := NewTestGallery(uuid.New())
 
-	r := newExample()
-	originalVariant := galleryx.NewImage(uuid.New())
-	stack, _ := g.NewStack(uuid.New(), originalVariant)
-
-	_, err := uploader.UploadVariant(ctx, g, stack.ID, originalVariant.ID, r)
-	if err != nil {
-		t.Fatalf("upload original image: %v", err)
-	}
+	stackID := uuid.New()
+	originalVariant := uploadOriginal(t, &storage, g, stackID)
+	stack, _ := g.NewStack(stackID, originalVariant)
 
 	results, errs, err := pp.Run(ctx, pipeline)
 	if err != nil {
@@ -443,3 +854,26 @@ func testProcessorResult(
 		}
 	}
 }
+
+// uploadOriginal writes the example image to the default storage path of the
+// original image of a new stack, and returns the stub image for that stack.
+func uploadOriginal(t *testing.T, storage esgallery.Storage, g *TestGallery, stackID uuid.UUID) gallery.Image[uuid.UUID] {
+	t.Helper()
+
+	img := galleryx.NewImage(uuid.New())
+
+	path := esgallery.DefaultPaths{}.Path(esgallery.PathInfo{
+		Gallery:  g.ID,
+		Stack:    stackID.String(),
+		Image:    img.ID.String(),
+		Filename: img.Filename,
+	})
+
+	stored, err := storage.Put(context.Background(), path, newExample())
+	if err != nil {
+		t.Fatalf("upload original image: %v", err)
+	}
+	img.Storage = stored
+
+	return img
+}
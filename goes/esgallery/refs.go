@@ -0,0 +1,182 @@
+package esgallery
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/modernice/media-entity/image"
+)
+
+var _ ReferenceCounter = (*MemoryReferenceCounter)(nil)
+
+// ReferenceCounter counts the references of gallery images to files that are
+// stored at content-addressed paths. A file can be referenced by multiple
+// images, possibly across multiple galleries, and must only be deleted when
+// its last reference is removed. Pass a ReferenceCounter to [NewUploader]
+// using the [WithContentAddressing] option.
+//
+// The [*Uploader] adds a reference when it uploads an image, and the
+// [*GarbageCollector] removes it when the image is removed from its gallery.
+// Both lock the file while they look up, change, and write or delete it, so
+// that a file is never referenced again while it is being deleted.
+type ReferenceCounter interface {
+	// Lock locks the references of the file at the given storage path until
+	// the returned unlock function is called. Lock blocks until the file is
+	// unlocked by other callers, or until the Context is canceled. Locks must
+	// be shared by all Uploaders and garbage collectors that use the same
+	// references.
+	Lock(ctx context.Context, path string) (unlock func(), err error)
+
+	// Increment adds a reference to the given file and returns the new
+	// number of references.
+	Increment(context.Context, image.Storage) (int, error)
+
+	// Decrement removes a reference from the given file and returns the
+	// remaining number of references.
+	Decrement(context.Context, image.Storage) (int, error)
+
+	// Lookup returns the referenced file at the given storage path, and its
+	// number of references. If the file is not referenced, Lookup returns 0
+	// references.
+	Lookup(ctx context.Context, path string) (image.Storage, int, error)
+}
+
+// MemoryReferenceCounter is a thread-safe [ReferenceCounter] that stores
+// reference counts in memory. The zero-value MemoryReferenceCounter is
+// ready-to-use.
+type MemoryReferenceCounter struct {
+	mux   sync.RWMutex
+	refs  map[string]memoryReference
+	locks map[string]chan struct{}
+}
+
+type memoryReference struct {
+	file  image.Storage
+	count int
+}
+
+// Lock implements [ReferenceCounter].
+func (c *MemoryReferenceCounter) Lock(ctx context.Context, path string) (func(), error) {
+	c.mux.Lock()
+	for {
+		locked, ok := c.locks[path]
+		if !ok {
+			break
+		}
+		c.mux.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-locked:
+		}
+
+		c.mux.Lock()
+	}
+
+	if c.locks == nil {
+		c.locks = make(map[string]chan struct{})
+	}
+	locked := make(chan struct{})
+	c.locks[path] = locked
+	c.mux.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mux.Lock()
+			delete(c.locks, path)
+			c.mux.Unlock()
+			close(locked)
+		})
+	}, nil
+}
+
+// Increment implements [ReferenceCounter].
+func (c *MemoryReferenceCounter) Increment(_ context.Context, file image.Storage) (int, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.refs == nil {
+		c.refs = make(map[string]memoryReference)
+	}
+
+	ref := c.refs[file.Path]
+	ref.file = file
+	ref.count++
+	c.refs[file.Path] = ref
+
+	return ref.count, nil
+}
+
+// Decrement implements [ReferenceCounter].
+func (c *MemoryReferenceCounter) Decrement(_ context.Context, file image.Storage) (int, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	ref, ok := c.refs[file.Path]
+	if !ok {
+		return 0, nil
+	}
+
+	ref.count--
+	if ref.count <= 0 {
+		delete(c.refs, file.Path)
+		return 0, nil
+	}
+	c.refs[file.Path] = ref
+
+	return ref.count, nil
+}
+
+// Lookup implements [ReferenceCounter].
+func (c *MemoryReferenceCounter) Lookup(_ context.Context, path string) (image.Storage, int, error) {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	ref := c.refs[path]
+	return ref.file, ref.count, nil
+}
+
+// releaseFile removes n references from a content-addressed file while the file
+// is locked, and deletes the file from storage if it has no references left.
+// releaseFile reports whether the file has no references left. Files that are
+// not referenced at all are never deleted, because it is unknown whether they
+// are still used. If dryRun is true, neither the references nor the file are
+// changed.
+func releaseFile(ctx context.Context, refs ReferenceCounter, storage ManagedStorage, file image.Storage, n int, dryRun bool) (bool, error) {
+	unlock, err := refs.Lock(ctx, file.Path)
+	if err != nil {
+		return false, fmt.Errorf("lock references: %w", err)
+	}
+	defer unlock()
+
+	_, remaining, err := refs.Lookup(ctx, file.Path)
+	if err != nil {
+		return false, fmt.Errorf("lookup references: %w", err)
+	}
+
+	if remaining == 0 {
+		return false, nil
+	}
+
+	if dryRun {
+		return remaining <= n, nil
+	}
+
+	for i := 0; i < n && remaining > 0; i++ {
+		if remaining, err = refs.Decrement(ctx, file); err != nil {
+			return false, fmt.Errorf("decrement references: %w", err)
+		}
+	}
+
+	if remaining > 0 {
+		return false, nil
+	}
+
+	if err := deleteFile(ctx, storage, file); err != nil {
+		return true, fmt.Errorf("delete file: %w", err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,382 @@
+package esgallery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/modernice/media-entity/gallery"
+)
+
+// ErrUploadIncomplete is returned by [*ResumableUploadHandler.Finish] if not
+// all bytes of an upload were received.
+var ErrUploadIncomplete = errors.New("upload incomplete")
+
+const (
+	// UploadLengthHeader is the header that contains the total size of a
+	// resumable upload.
+	UploadLengthHeader = "Upload-Length"
+
+	// UploadOffsetHeader is the header that contains the offset of a chunk of a
+	// resumable upload.
+	UploadOffsetHeader = "Upload-Offset"
+
+	// ChunkContentType is the required Content-Type of chunk requests.
+	ChunkContentType = "application/offset+octet-stream"
+)
+
+// ResumableUploadHandler is an [http.Handler] that implements a resumable
+// upload protocol for large images, modeled after the tus protocol. Clients
+// upload an image in chunks, and can resume an interrupted upload from the
+// last received offset. The received bytes are kept in a [SessionStore].
+//
+// The handler must be mounted with [http.StripPrefix] and handles the
+// following requests:
+//
+//	POST   /{galleryId}               create a session; requires the Upload-Length
+//	                                  header and a filename
+//	HEAD   /{galleryId}/{sessionId}   return the Upload-Offset and Upload-Length
+//	PATCH  /{galleryId}/{sessionId}   append a chunk at the given Upload-Offset
+//	POST   /{galleryId}/{sessionId}   finish the upload
+//	DELETE /{galleryId}/{sessionId}   cancel the upload
+//
+// The filename is read from the Content-Disposition header, or from the
+// "filename" query parameter. The Location header of the created session
+// contains the URL of the session.
+//
+// Finishing an upload creates a new [gallery.Stack] using the [*UploadHandler]
+// that is passed to [NewResumableUploadHandler], and returns it as JSON, just
+// like a regular upload. Sessions that are abandoned by clients can be deleted
+// using [*ResumableUploadHandler.Cleanup].
+type ResumableUploadHandler[
+	Gallery ProcessableGallery[StackID, ImageID],
+	StackID, ImageID ID,
+] struct {
+	uploads  *UploadHandler[Gallery, StackID, ImageID]
+	sessions SessionStore
+	cfg      resumableUploadConfig
+}
+
+// ResumableUploadOption is an option for [NewResumableUploadHandler].
+type ResumableUploadOption func(*resumableUploadConfig)
+
+type resumableUploadConfig struct {
+	onDeleteError func(sessionID uuid.UUID, err error)
+}
+
+// OnSessionDeleteError returns a [ResumableUploadOption] that calls fn if a
+// session cannot be deleted after its upload was finished. The upload itself
+// succeeded, and the session is eventually removed by
+// [*ResumableUploadHandler.Cleanup].
+func OnSessionDeleteError(fn func(sessionID uuid.UUID, err error)) ResumableUploadOption {
+	return func(cfg *resumableUploadConfig) {
+		cfg.onDeleteError = fn
+	}
+}
+
+// NewResumableUploadHandler returns a [*ResumableUploadHandler] that stores
+// upload sessions in the provided [SessionStore], and finishes uploads using
+// the provided [*UploadHandler].
+func NewResumableUploadHandler[
+	Gallery ProcessableGallery[StackID, ImageID],
+	StackID, ImageID ID,
+](
+	uploads *UploadHandler[Gallery, StackID, ImageID],
+	sessions SessionStore,
+	opts ...ResumableUploadOption,
+) *ResumableUploadHandler[Gallery, StackID, ImageID] {
+	h := &ResumableUploadHandler[Gallery, StackID, ImageID]{
+		uploads:  uploads,
+		sessions: sessions,
+	}
+	for _, opt := range opts {
+		opt(&h.cfg)
+	}
+	return h
+}
+
+// Create creates a new upload session for an image with the given filename
+// and total size in bytes. If the size exceeds the maximum file size of the
+// [UploadPolicy] of the [*Uploader], an error that satisfies
+// errors.Is(err, ErrFileTooLarge) is returned. If the gallery does not exist,
+// and the [*UploadHandler] does not create galleries, an error that satisfies
+// errors.Is(err, ErrGalleryNotFound) is returned.
+func (h *ResumableUploadHandler[Gallery, StackID, ImageID]) Create(ctx context.Context, galleryID uuid.UUID, filename string, length int64) (UploadSession, error) {
+	if filename == "" {
+		return UploadSession{}, fmt.Errorf("empty filename")
+	}
+
+	if length <= 0 {
+		return UploadSession{}, fmt.Errorf("invalid upload length %d", length)
+	}
+
+	if policy := h.uploads.uploader.cfg.policy; policy.MaxFileSize > 0 && length > policy.MaxFileSize {
+		return UploadSession{}, policy.fileTooLarge()
+	}
+
+	if _, err := h.uploads.gallery(ctx, galleryID); err != nil {
+		return UploadSession{}, err
+	}
+
+	now := time.Now()
+	session := UploadSession{
+		ID:        uuid.New(),
+		Gallery:   galleryID,
+		Filename:  filename,
+		Length:    length,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if err := h.sessions.Create(ctx, session); err != nil {
+		return UploadSession{}, fmt.Errorf("create session: %w", err)
+	}
+
+	return session, nil
+}
+
+// Append appends a chunk to the upload session at the given offset, and
+// returns the updated session. Bytes beyond the total size of the upload are
+// rejected with an error that satisfies errors.Is(err, ErrFileTooLarge).
+func (h *ResumableUploadHandler[Gallery, StackID, ImageID]) Append(ctx context.Context, sessionID uuid.UUID, offset int64, chunk io.Reader) (UploadSession, error) {
+	session, err := h.sessions.Session(ctx, sessionID)
+	if err != nil {
+		return UploadSession{}, err
+	}
+
+	limited := &limitReader{r: chunk, max: session.Length - offset}
+	session, err = h.sessions.Append(ctx, sessionID, offset, limited)
+	if limited.exceeded {
+		return session, fmt.Errorf("%w: chunk exceeds the upload length of %d bytes", ErrFileTooLarge, session.Length)
+	}
+
+	return session, err
+}
+
+// Finish uploads the received image of a complete upload session to the
+// gallery of the session, saves the gallery, and deletes the session. If not
+// all bytes were received, an error that satisfies
+// errors.Is(err, ErrUploadIncomplete) is returned.
+//
+// The session is claimed before the upload, so that concurrent or retried
+// requests cannot finish the same upload twice. Such requests fail with an
+// error that satisfies errors.Is(err, ErrSessionFinishing). If the upload
+// fails, the claim is released, so that finishing the upload can be retried.
+// If the session cannot be deleted after the gallery was saved, the created
+// stack is still returned, and the error is reported to the
+// [OnSessionDeleteError] callback.
+func (h *ResumableUploadHandler[Gallery, StackID, ImageID]) Finish(ctx context.Context, sessionID uuid.UUID) (_ gallery.Stack[StackID, ImageID], err error) {
+	var zero gallery.Stack[StackID, ImageID]
+
+	session, err := h.sessions.Claim(ctx, sessionID)
+	if err != nil {
+		return zero, err
+	}
+
+	defer func() {
+		if err != nil {
+			if rerr := h.sessions.Release(ctx, sessionID); rerr != nil {
+				err = fmt.Errorf("%w (release session: %v)", err, rerr)
+			}
+		}
+	}()
+
+	if !session.Complete() {
+		return zero, fmt.Errorf("%w: received %d of %d bytes", ErrUploadIncomplete, session.Offset, session.Length)
+	}
+
+	data, err := h.sessions.Open(ctx, sessionID)
+	if err != nil {
+		return zero, fmt.Errorf("open upload: %w", err)
+	}
+	defer data.Close()
+
+	stack, err := h.uploads.uploadFile(ctx, session.Gallery, data, session.Filename)
+	if err != nil {
+		return zero, err
+	}
+
+	// The upload succeeded, so the session is removed by Cleanup if it cannot
+	// be deleted now.
+	if err := h.sessions.Delete(ctx, sessionID); err != nil && h.cfg.onDeleteError != nil {
+		h.cfg.onDeleteError(sessionID, err)
+	}
+
+	return stack, nil
+}
+
+// Cancel deletes an upload session and its received bytes.
+func (h *ResumableUploadHandler[Gallery, StackID, ImageID]) Cancel(ctx context.Context, sessionID uuid.UUID) error {
+	if err := h.sessions.Delete(ctx, sessionID); err != nil {
+		return fmt.Errorf("delete session: %w", err)
+	}
+	return nil
+}
+
+// Cleanup deletes the upload sessions that did not receive a chunk within the
+// given duration, and returns the number of deleted sessions.
+func (h *ResumableUploadHandler[Gallery, StackID, ImageID]) Cleanup(ctx context.Context, maxAge time.Duration) (int, error) {
+	sessions, err := h.sessions.Sessions(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("list sessions: %w", err)
+	}
+
+	var deleted int
+	threshold := time.Now().Add(-maxAge)
+	for _, session := range sessions {
+		if session.UpdatedAt.After(threshold) {
+			continue
+		}
+		if err := h.sessions.Delete(ctx, session.ID); err != nil {
+			return deleted, fmt.Errorf("delete session %s: %w", session.ID, err)
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+// ServeHTTP implements [http.Handler].
+func (h *ResumableUploadHandler[Gallery, StackID, ImageID]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(segments) > 2 {
+		http.NotFound(w, r)
+		return
+	}
+
+	galleryID, err := uuid.Parse(segments[0])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if len(segments) == 1 {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		h.create(w, r, galleryID)
+		return
+	}
+
+	sessionID, err := uuid.Parse(segments[1])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Sessions can only be accessed through the gallery they belong to.
+	session, err := h.sessions.Session(r.Context(), sessionID)
+	if err == nil && session.Gallery != galleryID {
+		err = fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+	}
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	switch r.Method {
+	case http.MethodHead:
+		writeUploadHeaders(w, session)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodPatch:
+		h.append(w, r, session)
+	case http.MethodPost:
+		stack, err := h.Finish(r.Context(), sessionID)
+		if err != nil {
+			writeUploadError(w, err)
+			return
+		}
+		h.uploads.writeStack(w, stack)
+	case http.MethodDelete:
+		if err := h.Cancel(r.Context(), sessionID); err != nil {
+			writeUploadError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "HEAD, PATCH, POST, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *ResumableUploadHandler[Gallery, StackID, ImageID]) create(w http.ResponseWriter, r *http.Request, galleryID uuid.UUID) {
+	length, err := strconv.ParseInt(r.Header.Get(UploadLengthHeader), 10, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid %s header", UploadLengthHeader), http.StatusBadRequest)
+		return
+	}
+
+	var filename string
+	if _, params, err := mime.ParseMediaType(r.Header.Get("Content-Disposition")); err == nil {
+		filename = params["filename"]
+	}
+	if filename == "" {
+		filename = r.URL.Query().Get("filename")
+	}
+
+	session, err := h.Create(r.Context(), galleryID, cleanFilename(filename), length)
+	if err != nil {
+		if !errors.Is(err, ErrFileTooLarge) && !errors.Is(err, ErrGalleryNotFound) {
+			err = uploadError{http.StatusBadRequest, err}
+		}
+		writeUploadError(w, err)
+		return
+	}
+
+	// The request URI still contains the path prefix that was stripped.
+	base := strings.SplitN(r.RequestURI, "?", 2)[0]
+	w.Header().Set("Location", path.Join(base, session.ID.String()))
+	writeUploadHeaders(w, session)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *ResumableUploadHandler[Gallery, StackID, ImageID]) append(w http.ResponseWriter, r *http.Request, session UploadSession) {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != ChunkContentType {
+		http.Error(w, fmt.Sprintf("Content-Type must be %s", ChunkContentType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get(UploadOffsetHeader), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, fmt.Sprintf("invalid %s header", UploadOffsetHeader), http.StatusBadRequest)
+		return
+	}
+
+	if r.ContentLength > 0 && offset+r.ContentLength > session.Length {
+		writeUploadError(w, fmt.Errorf("%w: chunk exceeds the upload length of %d bytes", ErrFileTooLarge, session.Length))
+		return
+	}
+
+	session, err = h.Append(r.Context(), session.ID, offset, r.Body)
+	if err != nil && !errors.Is(err, ErrFileTooLarge) && !errors.Is(err, ErrOffsetMismatch) && !errors.Is(err, ErrSessionFinishing) {
+		// The client disconnected, or the connection broke. The received bytes
+		// are kept, so the client can resume from the current offset.
+		writeUploadHeaders(w, session)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	writeUploadHeaders(w, session)
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeUploadHeaders(w http.ResponseWriter, session UploadSession) {
+	w.Header().Set(UploadOffsetHeader, strconv.FormatInt(session.Offset, 10))
+	w.Header().Set(UploadLengthHeader, strconv.FormatInt(session.Length, 10))
+}
@@ -0,0 +1,311 @@
+package esgallery_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/modernice/goes/aggregate/repository"
+	"github.com/modernice/goes/event/eventstore"
+	"github.com/modernice/media-entity/gallery"
+	"github.com/modernice/media-entity/goes/esgallery"
+)
+
+func TestResumableUploadHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		sessions func(t *testing.T) esgallery.SessionStore
+	}{
+		{
+			name:     "MemorySessionStore",
+			sessions: func(*testing.T) esgallery.SessionStore { return &esgallery.MemorySessionStore{} },
+		},
+		{
+			name:     "FileSessionStore",
+			sessions: func(t *testing.T) esgallery.SessionStore { return esgallery.NewFileSessionStore(t.TempDir()) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var storage esgallery.MemoryStorage
+			galleries := repository.Typed(repository.New(eventstore.New()), NewTestGallery)
+			uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
+			uploads := esgallery.NewUploadHandler(uploader, galleries.Fetch, galleries.Save, esgallery.CreateGalleries[uuid.UUID, uuid.UUID]())
+
+			h := esgallery.NewResumableUploadHandler(uploads, tt.sessions(t))
+			mux := http.NewServeMux()
+			mux.Handle("/uploads/", http.StripPrefix("/uploads/", h))
+			server := httptest.NewServer(mux)
+			defer server.Close()
+
+			galleryID := uuid.New()
+
+			resp := do(t, http.MethodPost, server.URL+"/uploads/"+galleryID.String()+"?filename=foo.jpg", nil, http.Header{
+				esgallery.UploadLengthHeader: {strconv.Itoa(len(example))},
+			})
+			if resp.StatusCode != http.StatusCreated {
+				t.Fatalf("session should be created with status %d; got %d", http.StatusCreated, resp.StatusCode)
+			}
+
+			location := resp.Header.Get("Location")
+			if location == "" {
+				t.Fatalf("Location header should be set")
+			}
+			sessionURL := server.URL + location
+
+			half := len(example) / 2
+			patch := func(offset, end int) *http.Response {
+				return do(t, http.MethodPatch, sessionURL, bytes.NewReader(example[offset:end]), http.Header{
+					"Content-Type":               {esgallery.ChunkContentType},
+					esgallery.UploadOffsetHeader: {strconv.Itoa(offset)},
+				})
+			}
+
+			if resp := patch(0, half); resp.StatusCode != http.StatusNoContent {
+				t.Fatalf("first chunk should be accepted with status %d; got %d", http.StatusNoContent, resp.StatusCode)
+			}
+
+			resp = do(t, http.MethodHead, sessionURL, nil, nil)
+			if offset := resp.Header.Get(esgallery.UploadOffsetHeader); offset != strconv.Itoa(half) {
+				t.Fatalf("Upload-Offset should be %d; is %q", half, offset)
+			}
+
+			if resp := do(t, http.MethodPost, sessionURL, nil, nil); resp.StatusCode != http.StatusConflict {
+				t.Fatalf("incomplete upload should not be finished; got status %d", resp.StatusCode)
+			}
+
+			if resp := patch(0, half); resp.StatusCode != http.StatusConflict {
+				t.Fatalf("chunk at wrong offset should be rejected with status %d; got %d", http.StatusConflict, resp.StatusCode)
+			}
+
+			if resp := patch(half, len(example)); resp.StatusCode != http.StatusNoContent {
+				t.Fatalf("second chunk should be accepted with status %d; got %d", http.StatusNoContent, resp.StatusCode)
+			}
+
+			resp = do(t, http.MethodPost, sessionURL, nil, nil)
+			if resp.StatusCode != http.StatusCreated {
+				b, _ := io.ReadAll(resp.Body)
+				t.Fatalf("upload should be finished with status %d; got %d (%s)", http.StatusCreated, resp.StatusCode, b)
+			}
+
+			var stack gallery.Stack[uuid.UUID, uuid.UUID]
+			if err := json.NewDecoder(resp.Body).Decode(&stack); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+
+			contents, err := storage.Get(context.Background(), stack.Original().Storage.Path)
+			if err != nil {
+				t.Fatalf("uploaded image should be in storage: %v", err)
+			}
+			if b, _ := io.ReadAll(contents); !bytes.Equal(b, example) {
+				t.Fatalf("uploaded image has wrong contents")
+			}
+
+			g, err := galleries.Fetch(context.Background(), galleryID)
+			if err != nil {
+				t.Fatalf("fetch gallery: %v", err)
+			}
+			if _, ok := g.Stack(stack.ID); !ok {
+				t.Fatalf("gallery should have been saved with stack %s", stack.ID)
+			}
+
+			if resp := do(t, http.MethodHead, sessionURL, nil, nil); resp.StatusCode != http.StatusNotFound {
+				t.Fatalf("finished session should be deleted; got status %d", resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestResumableUploadHandler_Append_tooLarge(t *testing.T) {
+	var storage esgallery.MemoryStorage
+	galleries := repository.Typed(repository.New(eventstore.New()), NewTestGallery)
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage, esgallery.WithUploadPolicy(esgallery.UploadPolicy{
+		MaxFileSize: int64(len(example)),
+	}))
+	h := esgallery.NewResumableUploadHandler(esgallery.NewUploadHandler(uploader, galleries.Fetch, galleries.Save, esgallery.CreateGalleries[uuid.UUID, uuid.UUID]()), &esgallery.MemorySessionStore{})
+
+	ctx := context.Background()
+
+	if _, err := h.Create(ctx, uuid.New(), "foo.jpg", int64(len(example))+1); !errors.Is(err, esgallery.ErrFileTooLarge) {
+		t.Fatalf("Create() should fail with %q; got %v", esgallery.ErrFileTooLarge, err)
+	}
+
+	session, err := h.Create(ctx, uuid.New(), "foo.jpg", 10)
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+
+	if _, err := h.Append(ctx, session.ID, 0, bytes.NewReader(example)); !errors.Is(err, esgallery.ErrFileTooLarge) {
+		t.Fatalf("Append() should fail with %q; got %v", esgallery.ErrFileTooLarge, err)
+	}
+}
+
+func TestResumableUploadHandler_Finish_concurrent(t *testing.T) {
+	var storage esgallery.MemoryStorage
+	galleries := repository.Typed(repository.New(eventstore.New()), NewTestGallery)
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
+	h := esgallery.NewResumableUploadHandler(esgallery.NewUploadHandler(uploader, galleries.Fetch, galleries.Save, esgallery.CreateGalleries[uuid.UUID, uuid.UUID]()), &esgallery.MemorySessionStore{})
+
+	ctx := context.Background()
+	galleryID := uuid.New()
+
+	session, err := h.Create(ctx, galleryID, "foo.jpg", int64(len(example)))
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+
+	if _, err := h.Append(ctx, session.ID, 0, bytes.NewReader(example)); err != nil {
+		t.Fatalf("Append() failed: %v", err)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		finished atomic.Int64
+	)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := h.Finish(ctx, session.ID)
+			switch {
+			case err == nil:
+				finished.Add(1)
+			case !errors.Is(err, esgallery.ErrSessionFinishing) && !errors.Is(err, esgallery.ErrSessionNotFound):
+				t.Errorf("Finish() should fail with %q or %q; got %v", esgallery.ErrSessionFinishing, esgallery.ErrSessionNotFound, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := finished.Load(); n != 1 {
+		t.Fatalf("session should be finished once; finished %d times", n)
+	}
+
+	g, err := galleries.Fetch(ctx, galleryID)
+	if err != nil {
+		t.Fatalf("fetch gallery: %v", err)
+	}
+	if len(g.Stacks) != 1 {
+		t.Fatalf("gallery should have 1 stack; has %d", len(g.Stacks))
+	}
+}
+
+func TestResumableUploadHandler_Finish_deleteFails(t *testing.T) {
+	var storage esgallery.MemoryStorage
+	galleries := repository.Typed(repository.New(eventstore.New()), NewTestGallery)
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
+	sessions := failingDeleteStore{&esgallery.MemorySessionStore{}}
+
+	var deleteErr error
+	h := esgallery.NewResumableUploadHandler(
+		esgallery.NewUploadHandler(uploader, galleries.Fetch, galleries.Save, esgallery.CreateGalleries[uuid.UUID, uuid.UUID]()),
+		sessions,
+		esgallery.OnSessionDeleteError(func(_ uuid.UUID, err error) { deleteErr = err }),
+	)
+
+	ctx := context.Background()
+
+	session, err := h.Create(ctx, uuid.New(), "foo.jpg", int64(len(example)))
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+
+	if _, err := h.Append(ctx, session.ID, 0, bytes.NewReader(example)); err != nil {
+		t.Fatalf("Append() failed: %v", err)
+	}
+
+	stack, err := h.Finish(ctx, session.ID)
+	if err != nil {
+		t.Fatalf("Finish() should not fail if the session cannot be deleted; got %v", err)
+	}
+
+	if !errors.Is(deleteErr, errDeleteFailed) {
+		t.Fatalf("delete error should be reported; got %v", deleteErr)
+	}
+
+	if _, err := storage.Get(ctx, stack.Original().Storage.Path); err != nil {
+		t.Fatalf("uploaded image should be in storage: %v", err)
+	}
+
+	if _, err := h.Finish(ctx, session.ID); !errors.Is(err, esgallery.ErrSessionFinishing) {
+		t.Fatalf("finished session should stay claimed; got %v", err)
+	}
+}
+
+type failingDeleteStore struct {
+	*esgallery.MemorySessionStore
+}
+
+func (failingDeleteStore) Delete(context.Context, uuid.UUID) error {
+	return errDeleteFailed
+}
+
+var errDeleteFailed = errors.New("delete failed")
+
+func TestResumableUploadHandler_Cleanup(t *testing.T) {
+	var storage esgallery.MemoryStorage
+	galleries := repository.Typed(repository.New(eventstore.New()), NewTestGallery)
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
+	sessions := &esgallery.MemorySessionStore{}
+	h := esgallery.NewResumableUploadHandler(esgallery.NewUploadHandler(uploader, galleries.Fetch, galleries.Save, esgallery.CreateGalleries[uuid.UUID, uuid.UUID]()), sessions)
+
+	ctx := context.Background()
+
+	stale := esgallery.UploadSession{ID: uuid.New(), Filename: "foo.jpg", Length: 10, UpdatedAt: time.Now().Add(-time.Hour)}
+	if err := sessions.Create(ctx, stale); err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+
+	active, err := h.Create(ctx, uuid.New(), "bar.jpg", 10)
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+
+	deleted, err := h.Cleanup(ctx, time.Minute)
+	if err != nil {
+		t.Fatalf("Cleanup() failed: %v", err)
+	}
+
+	if deleted != 1 {
+		t.Fatalf("Cleanup() should delete 1 session; deleted %d", deleted)
+	}
+
+	if _, err := sessions.Session(ctx, stale.ID); !errors.Is(err, esgallery.ErrSessionNotFound) {
+		t.Fatalf("stale session should be deleted; got %v", err)
+	}
+
+	if _, err := sessions.Session(ctx, active.ID); err != nil {
+		t.Fatalf("active session should not be deleted; got %v", err)
+	}
+}
+
+func do(t *testing.T, method, u string, body io.Reader, header http.Header) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		t.Fatalf("create request: %v", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, u, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
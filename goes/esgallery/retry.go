@@ -0,0 +1,222 @@
+package esgallery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/modernice/goes/aggregate"
+	"github.com/modernice/goes/event"
+	"github.com/modernice/goes/helper/pick"
+)
+
+var _ DeadLetterSink = (*MemoryDeadLetterSink)(nil)
+
+// RetryPolicy configures how a [*PostProcessor] retries the processing of a
+// [gallery.Stack] after it failed. The zero-value RetryPolicy does not retry.
+// A worker of the post-processor is blocked while it waits for a retry; use the
+// [Workers] option to process multiple stacks concurrently.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first one.
+	// A value of 1 or less disables retries.
+	MaxAttempts int
+
+	// Backoff is the delay before the first retry. Defaults to no delay.
+	Backoff time.Duration
+
+	// MaxBackoff limits the delay between two attempts. Defaults to no limit.
+	MaxBackoff time.Duration
+
+	// Multiplier is the factor by which the delay grows after each attempt.
+	// Defaults to 2.
+	Multiplier float64
+
+	// Jitter randomizes each delay by up to the given fraction, in both
+	// directions. For example, a Jitter of 0.2 randomizes a delay of 1s to a
+	// value between 800ms and 1.2s. Values outside of [0, 1] are clamped.
+	Jitter float64
+
+	// Retryable reports whether processing should be retried after the given
+	// error. If Retryable is nil, all errors except canceled or expired
+	// contexts are retried.
+	Retryable func(error) bool
+}
+
+// Retry returns a [RunProcessorOption] that retries failed processing according
+// to the provided [RetryPolicy]. When all attempts have failed, the stack is
+// sent to the [DeadLetterSink] that was configured using the [DeadLetters]
+// option.
+func Retry(policy RetryPolicy) RunProcessorOption {
+	return func(cfg *runProcessorConfig) {
+		cfg.retry = policy
+	}
+}
+
+// next returns the delay before the next attempt, or false if processing
+// should not be retried after the given number of failed attempts.
+func (p RetryPolicy) next(attempts int, err error) (time.Duration, bool) {
+	if attempts >= p.MaxAttempts || !p.retryable(err) {
+		return 0, false
+	}
+
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+
+	delay := float64(p.Backoff) * math.Pow(multiplier, float64(attempts-1))
+
+	if p.MaxBackoff > 0 && delay > float64(p.MaxBackoff) {
+		delay = float64(p.MaxBackoff)
+	}
+
+	if jitter := math.Min(math.Max(p.Jitter, 0), 1); jitter > 0 {
+		delay += delay * jitter * (2*rand.Float64() - 1)
+	}
+
+	return time.Duration(delay), true
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// DeadLetter is a [gallery.Stack] whose processing failed, even after retrying
+// according to the [RetryPolicy] of the [*PostProcessor].
+type DeadLetter struct {
+	// Gallery is the gallery aggregate that contains the stack.
+	Gallery aggregate.Ref
+
+	// StackID is the string representation of the id of the [gallery.Stack].
+	StackID string
+
+	// Trigger is the event that triggered the processing.
+	Trigger event.Event
+
+	// Attempts is the number of failed attempts.
+	Attempts int
+
+	// Err is the error of the last attempt.
+	Err error
+
+	// Time is the time at which the stack was dead-lettered.
+	Time time.Time
+}
+
+// DeadLetterSink records the [DeadLetter]s of a [*PostProcessor]. Use the
+// [DeadLetters] option to pass a DeadLetterSink to [*PostProcessor.Run].
+// Dead-lettered stacks can be processed again by passing the DeadLetters to
+// [*PostProcessor.Requeue].
+type DeadLetterSink interface {
+	// Add records a [DeadLetter].
+	Add(context.Context, DeadLetter) error
+}
+
+// DeadLetters returns a [RunProcessorOption] that sends stacks whose processing
+// failed to the provided [DeadLetterSink].
+func DeadLetters(sink DeadLetterSink) RunProcessorOption {
+	return func(cfg *runProcessorConfig) {
+		cfg.deadLetters = sink
+	}
+}
+
+// MemoryDeadLetterSink is a thread-safe [DeadLetterSink] that stores
+// [DeadLetter]s in memory. A DeadLetter replaces a previously recorded
+// DeadLetter with the same trigger event. The zero-value MemoryDeadLetterSink
+// is ready-to-use.
+type MemoryDeadLetterSink struct {
+	mux     sync.RWMutex
+	letters []DeadLetter
+}
+
+// Add implements [DeadLetterSink].
+func (s *MemoryDeadLetterSink) Add(_ context.Context, letter DeadLetter) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for i, l := range s.letters {
+		if l.Trigger.ID() == letter.Trigger.ID() {
+			s.letters[i] = letter
+			return nil
+		}
+	}
+	s.letters = append(s.letters, letter)
+
+	return nil
+}
+
+// Letters returns the recorded [DeadLetter]s in the order they were added.
+func (s *MemoryDeadLetterSink) Letters() []DeadLetter {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	out := make([]DeadLetter, len(s.letters))
+	copy(out, s.letters)
+	return out
+}
+
+// Remove removes the [DeadLetter] of the given trigger event.
+func (s *MemoryDeadLetterSink) Remove(trigger uuid.UUID) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for i, l := range s.letters {
+		if l.Trigger.ID() == trigger {
+			s.letters = append(s.letters[:i], s.letters[i+1:]...)
+			return
+		}
+	}
+}
+
+// Requeue pushes the trigger events of the provided [DeadLetter]s back into
+// the queue of the running post-processor, which processes them again with a
+// fresh [RetryPolicy]. Requeue blocks until every DeadLetter was picked up by
+// a worker, or until ctx is canceled. Requeued DeadLetters are not removed
+// from the [DeadLetterSink].
+func (pp *PostProcessor[Gallery, StackID, ImageID]) Requeue(ctx context.Context, letters ...DeadLetter) error {
+	for _, letter := range letters {
+		if letter.Trigger == nil {
+			return fmt.Errorf("dead letter has no trigger event [galleryId=%s, stackId=%s]", letter.Gallery.ID, letter.StackID)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case pp.requeue <- letter.Trigger:
+		}
+	}
+	return nil
+}
+
+func (q *processorQueue[Gallery, StackID, ImageID]) deadLetter(evt event.Event, attempts int, err error) {
+	if q.cfg.deadLetters == nil || q.ctx.Err() != nil {
+		return
+	}
+
+	letter := DeadLetter{
+		Gallery: aggregate.Ref{
+			Name: pick.AggregateName(evt),
+			ID:   pick.AggregateID(evt),
+		},
+		Trigger:  evt,
+		Attempts: attempts,
+		Err:      err,
+		Time:     time.Now(),
+	}
+
+	if stackID, ok := triggerStackID[StackID, ImageID](evt); ok {
+		letter.StackID = stackID.String()
+	}
+
+	q.cfg.debugLog("dead-lettering stack after %d attempts [galleryId=%s, stackId=%s]", attempts, letter.Gallery.ID, letter.StackID)
+
+	if err := q.cfg.deadLetters.Add(q.ctx, letter); err != nil {
+		q.fail(fmt.Errorf("add dead letter [galleryId=%s, stackId=%s]: %w", letter.Gallery.ID, letter.StackID, err))
+	}
+}
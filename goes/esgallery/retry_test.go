@@ -0,0 +1,231 @@
+package esgallery_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/modernice/goes/aggregate/repository"
+	"github.com/modernice/goes/event/eventbus"
+	"github.com/modernice/goes/event/eventstore"
+	"github.com/modernice/media-entity/goes/esgallery"
+	"github.com/modernice/media-entity/internal/testx"
+	imgtools "github.com/modernice/media-tools/image"
+)
+
+var errUnavailable = errors.New("storage unavailable")
+
+func TestPostProcessor_Run_Retry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage := &flakyStorage{failures: 2}
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](storage)
+	ebus := eventbus.New()
+	estore := eventstore.WithBus(eventstore.New(), ebus)
+	repo := repository.New(estore)
+	galleries := repository.Typed(repo, NewTestGallery)
+	p := esgallery.NewProcessor(esgallery.DefaultEncoder, storage, uploader, uuid.New)
+	pp := esgallery.NewPostProcessor(p, ebus, galleries.Fetch)
+
+	pipeline := imgtools.Pipeline{
+		imgtools.Resize(imgtools.DimensionMap{"sm": {640}}),
+	}
+
+	g := NewTestGallery(uuid.New())
+	stack, err := uploader.UploadNew(ctx, g, uuid.New(), uuid.New(), newExample(), "example.jpg")
+	if err != nil {
+		t.Fatalf("upload original image: %v", err)
+	}
+
+	results, errs, err := pp.Run(ctx, pipeline, esgallery.Retry(esgallery.RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     10 * time.Millisecond,
+		Jitter:      0.5,
+	}))
+	if err != nil {
+		t.Fatalf("run post-processor: %v", err)
+	}
+	go testx.PanicOn(errs)
+
+	if err := galleries.Save(ctx, g); err != nil {
+		t.Fatalf("save gallery: %v", err)
+	}
+
+	var result esgallery.ProcessorResult[uuid.UUID, uuid.UUID]
+	select {
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for post-processor result")
+	case result = <-results:
+	}
+
+	if result.StackID != stack.ID {
+		t.Fatalf("result should be for stack %s; got %s", stack.ID, result.StackID)
+	}
+
+	if storage.gets() != 3 {
+		t.Fatalf("original should have been fetched 3 times; was fetched %d times", storage.gets())
+	}
+}
+
+func TestPostProcessor_Run_DeadLetters(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage := &flakyStorage{failures: 2}
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](storage)
+	ebus := eventbus.New()
+	estore := eventstore.WithBus(eventstore.New(), ebus)
+	repo := repository.New(estore)
+	galleries := repository.Typed(repo, NewTestGallery)
+	p := esgallery.NewProcessor(esgallery.DefaultEncoder, storage, uploader, uuid.New)
+	pp := esgallery.NewPostProcessor(p, ebus, galleries.Fetch)
+
+	pipeline := imgtools.Pipeline{
+		imgtools.Resize(imgtools.DimensionMap{"sm": {640}}),
+	}
+
+	g := NewTestGallery(uuid.New())
+	stack, err := uploader.UploadNew(ctx, g, uuid.New(), uuid.New(), newExample(), "example.jpg")
+	if err != nil {
+		t.Fatalf("upload original image: %v", err)
+	}
+	trigger := g.AggregateChanges()[0]
+
+	var sink esgallery.MemoryDeadLetterSink
+	results, errs, err := pp.Run(
+		ctx, pipeline,
+		esgallery.Retry(esgallery.RetryPolicy{MaxAttempts: 2}),
+		esgallery.DeadLetters(&sink),
+	)
+	if err != nil {
+		t.Fatalf("run post-processor: %v", err)
+	}
+
+	if err := galleries.Save(ctx, g); err != nil {
+		t.Fatalf("save gallery: %v", err)
+	}
+
+	select {
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for post-processor error")
+	case err := <-errs:
+		if !errors.Is(err, errUnavailable) {
+			t.Fatalf("post-processor error should wrap %q; got %q", errUnavailable, err)
+		}
+	}
+	go testx.PanicOn(errs)
+
+	letters := sink.Letters()
+	if len(letters) != 1 {
+		t.Fatalf("expected 1 dead letter; got %d", len(letters))
+	}
+
+	letter := letters[0]
+	if letter.StackID != stack.ID.String() {
+		t.Fatalf("dead letter should reference stack %s; got %s", stack.ID, letter.StackID)
+	}
+	if letter.Gallery.ID != g.ID {
+		t.Fatalf("dead letter should reference gallery %s; got %s", g.ID, letter.Gallery.ID)
+	}
+	if letter.Trigger.ID() != trigger.ID() {
+		t.Fatalf("dead letter should reference trigger event %s; got %s", trigger.ID(), letter.Trigger.ID())
+	}
+	if letter.Attempts != 2 {
+		t.Fatalf("dead letter should have 2 attempts; has %d", letter.Attempts)
+	}
+	if !errors.Is(letter.Err, errUnavailable) {
+		t.Fatalf("dead letter error should wrap %q; got %q", errUnavailable, letter.Err)
+	}
+
+	// The storage is available again, so the requeued stack is processed.
+	if err := pp.Requeue(ctx, letters...); err != nil {
+		t.Fatalf("requeue dead letters: %v", err)
+	}
+
+	var result esgallery.ProcessorResult[uuid.UUID, uuid.UUID]
+	select {
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for post-processor result")
+	case result = <-results:
+	}
+
+	if result.Trigger.ID() != trigger.ID() {
+		t.Fatalf("requeued result should be triggered by event %s; got %s", trigger.ID(), result.Trigger.ID())
+	}
+}
+
+func TestRetryPolicy_Retryable(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage := &flakyStorage{failures: 2}
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](storage)
+	ebus := eventbus.New()
+	estore := eventstore.WithBus(eventstore.New(), ebus)
+	repo := repository.New(estore)
+	galleries := repository.Typed(repo, NewTestGallery)
+	p := esgallery.NewProcessor(esgallery.DefaultEncoder, storage, uploader, uuid.New)
+	pp := esgallery.NewPostProcessor(p, ebus, galleries.Fetch)
+
+	g := NewTestGallery(uuid.New())
+	if _, err := uploader.UploadNew(ctx, g, uuid.New(), uuid.New(), newExample(), "example.jpg"); err != nil {
+		t.Fatalf("upload original image: %v", err)
+	}
+
+	_, errs, err := pp.Run(ctx, imgtools.Pipeline{}, esgallery.Retry(esgallery.RetryPolicy{
+		MaxAttempts: 5,
+		Retryable: func(err error) bool {
+			return !errors.Is(err, errUnavailable)
+		},
+	}))
+	if err != nil {
+		t.Fatalf("run post-processor: %v", err)
+	}
+
+	if err := galleries.Save(ctx, g); err != nil {
+		t.Fatalf("save gallery: %v", err)
+	}
+
+	select {
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for post-processor error")
+	case <-errs:
+	}
+
+	if storage.gets() != 1 {
+		t.Fatalf("non-retryable error should not be retried; original was fetched %d times", storage.gets())
+	}
+}
+
+// flakyStorage is a [esgallery.MemoryStorage] whose first Get calls fail.
+type flakyStorage struct {
+	esgallery.MemoryStorage
+
+	mux      sync.Mutex
+	failures int
+	calls    int
+}
+
+func (s *flakyStorage) Get(ctx context.Context, path string) (io.Reader, error) {
+	s.mux.Lock()
+	s.calls++
+	fail := s.calls <= s.failures
+	s.mux.Unlock()
+
+	if fail {
+		return nil, errUnavailable
+	}
+
+	return s.MemoryStorage.Get(ctx, path)
+}
+
+func (s *flakyStorage) gets() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.calls
+}
@@ -0,0 +1,207 @@
+package esgallery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/modernice/media-entity/image"
+)
+
+var _ ProviderStorage = (*StorageRouter)(nil)
+
+// StorageRouter is a [Storage] that routes files to multiple storages by their
+// provider. New files are written to the primary storage, while existing files
+// are read from the storage of the Provider that is recorded in their
+// [image.Storage]. This allows galleries to be migrated from one storage
+// backend to another without downtime: configure the new backend as the
+// primary storage, and the images that still reside in the previous backend
+// can be read until they are migrated.
+//
+// The Provider of the [image.Storage] that is returned by Put is set to the
+// name of the primary storage. Name the storages after the providers that are
+// recorded by the images that were uploaded before a StorageRouter was used,
+// for example "fs", "s3", or "memory".
+type StorageRouter struct {
+	mux      sync.RWMutex
+	primary  string
+	storages map[string]Storage
+}
+
+// NewStorageRouter returns a [*StorageRouter] that routes files to the provided
+// storages, which are mapped by their provider name. New files are written to
+// the storage named by primary, which must be one of the provided storages.
+func NewStorageRouter(primary string, storages map[string]Storage) (*StorageRouter, error) {
+	r := &StorageRouter{storages: make(map[string]Storage, len(storages))}
+	for name, storage := range storages {
+		r.storages[name] = storage
+	}
+
+	if err := r.SetPrimary(primary); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Primary returns the provider name of the primary storage.
+func (r *StorageRouter) Primary() string {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return r.primary
+}
+
+// SetPrimary sets the primary storage to which new files are written. It is
+// safe to call SetPrimary while files are uploaded.
+func (r *StorageRouter) SetPrimary(provider string) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if _, ok := r.storages[provider]; !ok {
+		return fmt.Errorf("primary storage: %w: %q", ErrUnknownProvider, provider)
+	}
+	r.primary = provider
+
+	return nil
+}
+
+// Providers returns the provider names of the configured storages, sorted
+// alphabetically.
+func (r *StorageRouter) Providers() []string {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	out := make([]string, 0, len(r.storages))
+	for name := range r.storages {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+
+	return out
+}
+
+// Storage returns the storage of the given provider.
+func (r *StorageRouter) Storage(provider string) (Storage, bool) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	s, ok := r.storages[provider]
+	return s, ok
+}
+
+// Put writes the file to the primary storage.
+func (r *StorageRouter) Put(ctx context.Context, path string, contents io.Reader) (image.Storage, error) {
+	r.mux.RLock()
+	primary := r.primary
+	storage := r.storages[primary]
+	r.mux.RUnlock()
+
+	stored, err := storage.Put(ctx, path, contents)
+	if err != nil {
+		return image.Storage{}, err
+	}
+	stored.Provider = primary
+
+	return stored, nil
+}
+
+// Get returns the file at the given path of the primary storage. If the
+// primary storage does not contain the file, the other storages are tried in
+// alphabetical order. Use GetFrom to read a file from a specific provider.
+func (r *StorageRouter) Get(ctx context.Context, path string) (io.Reader, error) {
+	for _, provider := range r.lookupOrder() {
+		contents, err := r.GetFrom(ctx, provider, path)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		return contents, err
+	}
+	return nil, fmt.Errorf("%w: %q [provider=router]", ErrNotFound, path)
+}
+
+// GetFrom implements [ProviderStorage].
+func (r *StorageRouter) GetFrom(ctx context.Context, provider, path string) (io.Reader, error) {
+	storage, err := r.route(provider)
+	if err != nil {
+		return nil, err
+	}
+	return storage.Get(ctx, path)
+}
+
+// Delete deletes the file at the given path of the primary storage. Use
+// DeleteFrom to delete a file from a specific provider.
+func (r *StorageRouter) Delete(ctx context.Context, path string) error {
+	return r.DeleteFrom(ctx, r.Primary(), path)
+}
+
+// DeleteFrom implements [ProviderStorage]. If the storage of the provider does
+// not implement [ManagedStorage], an error that satisfies
+// errors.Is(err, ErrNotSupported) is returned.
+func (r *StorageRouter) DeleteFrom(ctx context.Context, provider, path string) error {
+	storage, err := r.managed(provider)
+	if err != nil {
+		return err
+	}
+	return storage.Delete(ctx, path)
+}
+
+// Stat returns information about the file at the given path. Like Get, Stat
+// tries the primary storage first, and then the other storages.
+func (r *StorageRouter) Stat(ctx context.Context, path string) (FileInfo, error) {
+	for _, provider := range r.lookupOrder() {
+		info, err := r.StatFrom(ctx, provider, path)
+		if errors.Is(err, ErrNotFound) || errors.Is(err, ErrNotSupported) {
+			continue
+		}
+		return info, err
+	}
+	return FileInfo{}, fmt.Errorf("%w: %q [provider=router]", ErrNotFound, path)
+}
+
+// StatFrom implements [ProviderStorage]. If the storage of the provider does
+// not implement [ManagedStorage], an error that satisfies
+// errors.Is(err, ErrNotSupported) is returned.
+func (r *StorageRouter) StatFrom(ctx context.Context, provider, path string) (FileInfo, error) {
+	storage, err := r.managed(provider)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return storage.Stat(ctx, path)
+}
+
+func (r *StorageRouter) route(provider string) (Storage, error) {
+	storage, ok := r.Storage(provider)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownProvider, provider)
+	}
+	return storage, nil
+}
+
+func (r *StorageRouter) managed(provider string) (ManagedStorage, error) {
+	storage, err := r.route(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	managed, ok := storage.(ManagedStorage)
+	if !ok {
+		return nil, fmt.Errorf("%w [provider=%s]", ErrNotSupported, provider)
+	}
+
+	return managed, nil
+}
+
+// lookupOrder returns the primary provider, followed by the other providers
+// in alphabetical order.
+func (r *StorageRouter) lookupOrder() []string {
+	primary := r.Primary()
+	out := []string{primary}
+	for _, provider := range r.Providers() {
+		if provider != primary {
+			out = append(out, provider)
+		}
+	}
+	return out
+}
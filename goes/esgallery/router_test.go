@@ -0,0 +1,163 @@
+package esgallery_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/modernice/media-entity/goes/esgallery"
+	"github.com/modernice/media-entity/goes/esgallery/storagetest"
+	imgtools "github.com/modernice/media-tools/image"
+)
+
+func TestStorageRouter(t *testing.T) {
+	storagetest.Run(t, "StorageRouter", func(t *testing.T) esgallery.Storage {
+		router, err := esgallery.NewStorageRouter("fs", map[string]esgallery.Storage{
+			"memory": &esgallery.MemoryStorage{},
+			"fs":     esgallery.NewFileStorage(t.TempDir()),
+		})
+		if err != nil {
+			t.Fatalf("create storage router: %v", err)
+		}
+		return router
+	})
+}
+
+func TestNewStorageRouter_unknownPrimary(t *testing.T) {
+	_, err := esgallery.NewStorageRouter("s3", map[string]esgallery.Storage{
+		"memory": &esgallery.MemoryStorage{},
+	})
+	if !errors.Is(err, esgallery.ErrUnknownProvider) {
+		t.Fatalf("NewStorageRouter() should fail with %q; got %v", esgallery.ErrUnknownProvider, err)
+	}
+}
+
+func TestStorageRouter_routing(t *testing.T) {
+	ctx := context.Background()
+
+	var old, current esgallery.MemoryStorage
+	router, err := esgallery.NewStorageRouter("old", map[string]esgallery.Storage{
+		"old":     &old,
+		"current": &current,
+	})
+	if err != nil {
+		t.Fatalf("create storage router: %v", err)
+	}
+
+	stored, err := router.Put(ctx, "foo.jpg", bytes.NewReader([]byte("old")))
+	if err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+
+	if stored.Provider != "old" {
+		t.Fatalf("Provider should be %q; is %q", "old", stored.Provider)
+	}
+
+	if err := router.SetPrimary("current"); err != nil {
+		t.Fatalf("SetPrimary() failed: %v", err)
+	}
+
+	if stored, err = router.Put(ctx, "bar.jpg", bytes.NewReader([]byte("current"))); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+
+	if stored.Provider != "current" {
+		t.Fatalf("Provider should be %q; is %q", "current", stored.Provider)
+	}
+
+	if len(old.Files()) != 1 || len(current.Files()) != 1 {
+		t.Fatalf("each storage should contain 1 file; old has %d, current has %d", len(old.Files()), len(current.Files()))
+	}
+
+	// Files that were written to the previous primary storage can still be read.
+	expectRouted(t, router, "old", "foo.jpg", "old")
+	expectRouted(t, router, "current", "bar.jpg", "current")
+
+	if _, err := router.GetFrom(ctx, "current", "foo.jpg"); !errors.Is(err, esgallery.ErrNotFound) {
+		t.Fatalf("GetFrom() should fail with %q; got %v", esgallery.ErrNotFound, err)
+	}
+
+	if _, err := router.GetFrom(ctx, "s3", "foo.jpg"); !errors.Is(err, esgallery.ErrUnknownProvider) {
+		t.Fatalf("GetFrom() should fail with %q; got %v", esgallery.ErrUnknownProvider, err)
+	}
+
+	if err := router.DeleteFrom(ctx, "old", "foo.jpg"); err != nil {
+		t.Fatalf("DeleteFrom() failed: %v", err)
+	}
+
+	if len(old.Files()) != 0 {
+		t.Fatalf("file should be deleted from %q storage", "old")
+	}
+}
+
+func TestProcessor_Process_StorageRouter(t *testing.T) {
+	ctx := context.Background()
+
+	var old, current esgallery.MemoryStorage
+	router, err := esgallery.NewStorageRouter("old", map[string]esgallery.Storage{
+		"old":     &old,
+		"current": &current,
+	})
+	if err != nil {
+		t.Fatalf("create storage router: %v", err)
+	}
+
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](router)
+	p := esgallery.NewProcessor(esgallery.DefaultEncoder, router, uploader, uuid.New)
+
+	g := NewTestGallery(uuid.New())
+
+	stack, err := uploader.UploadNew(ctx, g, uuid.New(), uuid.New(), newExample(), "example.jpg")
+	if err != nil {
+		t.Fatalf("upload original image: %v", err)
+	}
+
+	if err := router.SetPrimary("current"); err != nil {
+		t.Fatalf("SetPrimary() failed: %v", err)
+	}
+
+	pipeline := imgtools.Pipeline{
+		imgtools.Resize(imgtools.DimensionMap{"sm": {640}}),
+	}
+
+	result, err := p.Process(ctx, pipeline, g, stack.ID)
+	if err != nil {
+		t.Fatalf("process stack: %v", err)
+	}
+
+	for _, img := range result.Images {
+		if img.Image.Storage.Provider != "current" {
+			t.Fatalf("processed image should be uploaded to %q storage; got %q", "current", img.Image.Storage.Provider)
+		}
+	}
+
+	if len(current.Files()) != len(result.Images) {
+		t.Fatalf("expected %d files in %q storage; got %d", len(result.Images), "current", len(current.Files()))
+	}
+}
+
+func expectRouted(t *testing.T, router *esgallery.StorageRouter, provider, path, want string) {
+	t.Helper()
+
+	for _, get := range []func() (io.Reader, error){
+		func() (io.Reader, error) { return router.Get(context.Background(), path) },
+		func() (io.Reader, error) { return router.GetFrom(context.Background(), provider, path) },
+	} {
+		r, err := get()
+		if err != nil {
+			t.Fatalf("get %q: %v", path, err)
+		}
+
+		contents, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("read %q: %v", path, err)
+		}
+
+		if string(contents) != want {
+			t.Fatalf("%q should have contents %q; got %q", path, want, contents)
+		}
+	}
+}
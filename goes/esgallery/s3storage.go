@@ -0,0 +1,515 @@
+package esgallery
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/modernice/media-entity/image"
+)
+
+var _ ManagedStorage = (*S3Storage)(nil)
+
+const (
+	// DefaultS3PartSize is the default size of the parts of a multipart upload.
+	DefaultS3PartSize = 8 << 20
+
+	// MinS3PartSize is the minimum size of the parts of a multipart upload,
+	// as required by S3.
+	MinS3PartSize = 5 << 20
+)
+
+// S3Config configures an [*S3Storage].
+type S3Config struct {
+	// Endpoint is the base URL of the S3-compatible API, for example
+	// "https://s3.eu-central-1.amazonaws.com" or "http://localhost:9000".
+	Endpoint string
+
+	// Region is the region of the bucket. Defaults to "us-east-1".
+	Region string
+
+	// Bucket is the name of the bucket.
+	Bucket string
+
+	// Prefix is prepended to all paths passed to Put and Get.
+	Prefix string
+
+	// AccessKeyID and SecretAccessKey are the credentials that are used to
+	// sign requests. If AccessKeyID is empty, requests are not signed.
+	AccessKeyID     string
+	SecretAccessKey string
+
+	// SessionToken is the optional session token of temporary credentials.
+	SessionToken string
+
+	// PathStyle enables path-style addressing ("<endpoint>/<bucket>/<key>")
+	// instead of virtual-hosted-style addressing ("<bucket>.<endpoint>/<key>").
+	// Most self-hosted S3-compatible servers require path-style addressing.
+	PathStyle bool
+
+	// CacheControl is the Cache-Control metadata of uploaded objects.
+	CacheControl string
+
+	// PartSize is the size of the parts of multipart uploads. Files that are
+	// larger than PartSize are uploaded using a multipart upload. Defaults to
+	// [DefaultS3PartSize]; values below [MinS3PartSize] are raised to it.
+	PartSize int64
+
+	// Client is the HTTP client that is used to send requests. Defaults to
+	// [http.DefaultClient].
+	Client *http.Client
+}
+
+// S3Storage is a [Storage] that stores images in a bucket of an S3-compatible
+// object storage. Put uploads files using a single PUT request, or using a
+// multipart upload if the file is larger than the configured part size. The
+// Content-Type of uploaded objects is determined from the file extension, or
+// from the contents of the file if the extension is unknown. The provider name
+// of stored images is "s3".
+type S3Storage struct {
+	cfg      S3Config
+	endpoint *url.URL
+}
+
+// S3Error is an error response of an S3-compatible API.
+type S3Error struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+
+	// Code is the error code, for example "NoSuchKey".
+	Code string
+
+	// Message is the error message.
+	Message string
+}
+
+func (err *S3Error) Error() string {
+	if err.Code == "" {
+		return fmt.Sprintf("s3: %s", http.StatusText(err.StatusCode))
+	}
+	return fmt.Sprintf("s3: %s: %s", err.Code, err.Message)
+}
+
+// NewS3Storage returns an [*S3Storage] that uses the provided configuration.
+func NewS3Storage(cfg S3Config) (*S3Storage, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("empty bucket name")
+	}
+
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("parse endpoint: %w", err)
+	}
+
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint %q", cfg.Endpoint)
+	}
+
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+
+	if cfg.PartSize <= 0 {
+		cfg.PartSize = DefaultS3PartSize
+	}
+
+	if cfg.PartSize < MinS3PartSize {
+		cfg.PartSize = MinS3PartSize
+	}
+
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+
+	return &S3Storage{cfg: cfg, endpoint: endpoint}, nil
+}
+
+// Bucket returns the name of the bucket.
+func (s *S3Storage) Bucket() string {
+	return s.cfg.Bucket
+}
+
+// Put implements [Storage].
+func (s *S3Storage) Put(ctx context.Context, p string, contents io.Reader) (image.Storage, error) {
+	key, err := s.key(p)
+	if err != nil {
+		return image.Storage{}, err
+	}
+
+	var part bytes.Buffer
+	n, err := io.CopyN(&part, contents, s.cfg.PartSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return image.Storage{}, fmt.Errorf("read contents: %w", err)
+	}
+
+	header := s.objectHeader(key, part.Bytes())
+
+	if n < s.cfg.PartSize {
+		if _, err := s.do(ctx, http.MethodPut, key, nil, header, part.Bytes()); err != nil {
+			return image.Storage{}, fmt.Errorf("put object %q: %w", key, err)
+		}
+	} else if err := s.putMultipart(ctx, key, header, &part, contents); err != nil {
+		return image.Storage{}, fmt.Errorf("multipart upload of object %q: %w", key, err)
+	}
+
+	return image.Storage{
+		Provider: "s3",
+		Path:     p,
+	}, nil
+}
+
+// Get implements [Storage]. The returned reader is the body of the HTTP
+// response and must be closed by the caller.
+func (s *S3Storage) Get(ctx context.Context, p string) (io.Reader, error) {
+	key, err := s.key(p)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.send(ctx, http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		var s3err *S3Error
+		if errors.As(err, &s3err) && s3err.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: %q [provider=s3, bucket=%s]", ErrNotFound, key, s.cfg.Bucket)
+		}
+		return nil, fmt.Errorf("get object %q: %w", key, err)
+	}
+
+	return resp.Body, nil
+}
+
+// Delete implements [ManagedStorage].
+func (s *S3Storage) Delete(ctx context.Context, p string) error {
+	key, err := s.key(p)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.do(ctx, http.MethodDelete, key, nil, nil, nil); err != nil {
+		var s3err *S3Error
+		if errors.As(err, &s3err) && s3err.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		return fmt.Errorf("delete object %q: %w", key, err)
+	}
+
+	return nil
+}
+
+// Stat implements [ManagedStorage].
+func (s *S3Storage) Stat(ctx context.Context, p string) (FileInfo, error) {
+	key, err := s.key(p)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	resp, err := s.send(ctx, http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		var s3err *S3Error
+		if errors.As(err, &s3err) && s3err.StatusCode == http.StatusNotFound {
+			return FileInfo{}, fmt.Errorf("%w: %q [provider=s3, bucket=%s]", ErrNotFound, key, s.cfg.Bucket)
+		}
+		return FileInfo{}, fmt.Errorf("head object %q: %w", key, err)
+	}
+	resp.Body.Close()
+
+	info := FileInfo{
+		Path:        p,
+		Size:        resp.ContentLength,
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+
+	if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
+		if info.ModTime, err = http.ParseTime(lastModified); err != nil {
+			return FileInfo{}, fmt.Errorf("parse Last-Modified header: %w", err)
+		}
+	}
+
+	return info, nil
+}
+
+func (s *S3Storage) putMultipart(ctx context.Context, key string, header http.Header, part *bytes.Buffer, contents io.Reader) error {
+	body, err := s.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, header, nil)
+	if err != nil {
+		return fmt.Errorf("create multipart upload: %w", err)
+	}
+
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(body, &initiated); err != nil {
+		return fmt.Errorf("decode multipart upload: %w", err)
+	}
+
+	if err := s.uploadParts(ctx, key, initiated.UploadID, part, contents); err != nil {
+		// Use a fresh context, so that the upload is also aborted if ctx was canceled.
+		abortCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if _, abortErr := s.do(abortCtx, http.MethodDelete, key, url.Values{"uploadId": {initiated.UploadID}}, nil, nil); abortErr != nil {
+			return fmt.Errorf("%w (abort multipart upload: %v)", err, abortErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (s *S3Storage) uploadParts(ctx context.Context, key, uploadID string, part *bytes.Buffer, contents io.Reader) error {
+	var parts []s3CompletedPart
+
+	for number := 1; part.Len() > 0; number++ {
+		query := url.Values{
+			"partNumber": {strconv.Itoa(number)},
+			"uploadId":   {uploadID},
+		}
+
+		resp, err := s.send(ctx, http.MethodPut, key, query, nil, part.Bytes())
+		if err != nil {
+			return fmt.Errorf("upload part %d: %w", number, err)
+		}
+		resp.Body.Close()
+
+		parts = append(parts, s3CompletedPart{
+			PartNumber: number,
+			ETag:       resp.Header.Get("ETag"),
+		})
+
+		part.Reset()
+		if _, err := io.CopyN(part, contents, s.cfg.PartSize); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("read contents: %w", err)
+		}
+	}
+
+	complete, err := xml.Marshal(struct {
+		XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+		Parts   []s3CompletedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return fmt.Errorf("encode completed parts: %w", err)
+	}
+
+	body, err := s.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, nil, complete)
+	if err != nil {
+		return fmt.Errorf("complete multipart upload: %w", err)
+	}
+
+	// CompleteMultipartUpload may fail after responding with 200 OK.
+	if err := decodeS3Error(http.StatusOK, body); err != nil {
+		return fmt.Errorf("complete multipart upload: %w", err)
+	}
+
+	return nil
+}
+
+// key returns the object key of the given storage path. Storage paths that
+// would resolve to a key outside of the configured prefix are rejected.
+func (s *S3Storage) key(p string) (string, error) {
+	if err := checkPath(p); err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(path.Join(s.cfg.Prefix, p), "/"), nil
+}
+
+func (s *S3Storage) objectHeader(key string, head []byte) http.Header {
+	header := http.Header{"Content-Type": {contentTypeOf(key, head)}}
+	if s.cfg.CacheControl != "" {
+		header.Set("Cache-Control", s.cfg.CacheControl)
+	}
+
+	return header
+}
+
+// do sends a request and returns the response body.
+func (s *S3Storage) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) ([]byte, error) {
+	resp, err := s.send(ctx, method, key, query, header, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+
+	return b, nil
+}
+
+// send sends a request and returns the response. If the API responds with an
+// error status, the response body is decoded into an [*S3Error].
+func (s *S3Storage) send(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u := *s.endpoint
+	escapedKey := s3Escape(key, false)
+	if s.cfg.PathStyle {
+		u.RawPath = strings.TrimSuffix(u.EscapedPath(), "/") + "/" + s3Escape(s.cfg.Bucket, false) + "/" + escapedKey
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+		u.RawPath = strings.TrimSuffix(u.EscapedPath(), "/") + "/" + escapedKey
+	}
+	u.Path, _ = url.PathUnescape(u.RawPath)
+	u.RawQuery = s3Query(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	s.sign(req, body, time.Now())
+
+	resp, err := s.cfg.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		if err := decodeS3Error(resp.StatusCode, b); err != nil {
+			return nil, err
+		}
+		return nil, &S3Error{StatusCode: resp.StatusCode}
+	}
+
+	return resp, nil
+}
+
+func decodeS3Error(status int, body []byte) error {
+	var resp struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}
+	if err := xml.Unmarshal(body, &resp); err != nil {
+		return nil
+	}
+	return &S3Error{
+		StatusCode: status,
+		Code:       resp.Code,
+		Message:    resp.Message,
+	}
+}
+
+// sign signs the request using AWS Signature Version 4.
+func (s *S3Storage) sign(req *http.Request, body []byte, now time.Time) {
+	payloadHash := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	if s.cfg.AccessKeyID == "" {
+		return
+	}
+
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if s.cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.cfg.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-amz-") || lk == "content-type" || lk == "cache-control" {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + s.cfg.Region + "/s3/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretAccessKey), date)
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Query returns the canonical query string of the provided query, which is
+// sorted by key and escaped according to the S3 rules.
+func s3Query(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
+
+// s3Escape escapes all characters except unreserved characters. Slashes are
+// only escaped if escapeSlash is true.
+func s3Escape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !escapeSlash) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
@@ -0,0 +1,533 @@
+package esgallery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	_ SessionStore = (*MemorySessionStore)(nil)
+	_ SessionStore = (*FileSessionStore)(nil)
+)
+
+var (
+	// ErrSessionNotFound is returned by a [SessionStore] if an upload session
+	// does not exist.
+	ErrSessionNotFound = errors.New("upload session not found")
+
+	// ErrOffsetMismatch is returned by a [SessionStore] if a chunk is appended
+	// at an offset other than the current offset of the upload session.
+	ErrOffsetMismatch = errors.New("upload offset mismatch")
+
+	// ErrSessionFinishing is returned by a [SessionStore] if an upload session
+	// is claimed or appended to while it is being finished.
+	ErrSessionFinishing = errors.New("upload session is being finished")
+)
+
+// UploadSession is the state of a resumable upload.
+type UploadSession struct {
+	// ID is the id of the session.
+	ID uuid.UUID `json:"id"`
+
+	// Gallery is the id of the gallery that the image is uploaded to.
+	Gallery uuid.UUID `json:"gallery"`
+
+	// Filename is the filename of the uploaded image.
+	Filename string `json:"filename"`
+
+	// Length is the total size of the upload in bytes.
+	Length int64 `json:"length"`
+
+	// Offset is the number of bytes that were received so far.
+	Offset int64 `json:"offset"`
+
+	// CreatedAt is the time at which the session was created.
+	CreatedAt time.Time `json:"createdAt"`
+
+	// UpdatedAt is the time at which the last chunk was received.
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	// Finishing is true while the upload is being finished.
+	Finishing bool `json:"finishing,omitempty"`
+}
+
+// Complete returns whether all bytes of the upload were received.
+func (s UploadSession) Complete() bool {
+	return s.Offset >= s.Length
+}
+
+// SessionStore stores the sessions and received bytes of resumable uploads.
+// Implementations must be safe for concurrent use.
+type SessionStore interface {
+	// Create creates a new upload session.
+	Create(context.Context, UploadSession) error
+
+	// Session returns the upload session with the given id. If the session
+	// does not exist, an error that satisfies errors.Is(err, ErrSessionNotFound)
+	// is returned.
+	Session(context.Context, uuid.UUID) (UploadSession, error)
+
+	// Append appends a chunk to the upload at the given offset, and returns
+	// the updated session. If the offset is not the current offset of the
+	// session, an error that satisfies errors.Is(err, ErrOffsetMismatch) is
+	// returned. If reading the chunk fails, the bytes that were read until then
+	// are kept, and the updated session is returned together with the error,
+	// so that clients can resume the upload from the new offset.
+	Append(ctx context.Context, id uuid.UUID, offset int64, chunk io.Reader) (UploadSession, error)
+
+	// Claim atomically marks an upload session as finishing, and returns the
+	// claimed session, so that an upload is finished only once. No chunks can
+	// be appended to a claimed session. If the session is already claimed, an
+	// error that satisfies errors.Is(err, ErrSessionFinishing) is returned.
+	Claim(context.Context, uuid.UUID) (UploadSession, error)
+
+	// Release releases the claim of an upload session, for example because
+	// finishing the upload failed and can be retried.
+	Release(context.Context, uuid.UUID) error
+
+	// Open returns the bytes that were received for an upload session. The
+	// caller must close the returned reader.
+	Open(context.Context, uuid.UUID) (io.ReadCloser, error)
+
+	// Sessions returns all upload sessions.
+	Sessions(context.Context) ([]UploadSession, error)
+
+	// Delete deletes an upload session and its received bytes.
+	Delete(context.Context, uuid.UUID) error
+}
+
+// MemorySessionStore is a thread-safe [SessionStore] that stores upload
+// sessions in memory. The zero value is ready to use.
+type MemorySessionStore struct {
+	mux      sync.RWMutex
+	sessions map[uuid.UUID]*memorySession
+}
+
+type memorySession struct {
+	mux     sync.Mutex
+	session UploadSession
+	data    []byte
+}
+
+// Create implements [SessionStore].
+func (s *MemorySessionStore) Create(_ context.Context, session UploadSession) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.sessions == nil {
+		s.sessions = make(map[uuid.UUID]*memorySession)
+	}
+	if _, ok := s.sessions[session.ID]; ok {
+		return fmt.Errorf("session %s already exists", session.ID)
+	}
+	s.sessions[session.ID] = &memorySession{session: session}
+
+	return nil
+}
+
+// Session implements [SessionStore].
+func (s *MemorySessionStore) Session(_ context.Context, id uuid.UUID) (UploadSession, error) {
+	ms, err := s.get(id)
+	if err != nil {
+		return UploadSession{}, err
+	}
+
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+
+	return ms.session, nil
+}
+
+// Append implements [SessionStore].
+func (s *MemorySessionStore) Append(_ context.Context, id uuid.UUID, offset int64, chunk io.Reader) (UploadSession, error) {
+	ms, err := s.get(id)
+	if err != nil {
+		return UploadSession{}, err
+	}
+
+	if session, err := ms.checkOffset(offset); err != nil {
+		return session, err
+	}
+
+	// The chunk is read without holding the lock, so that the session can be
+	// queried while a chunk is received.
+	var buf bytes.Buffer
+	_, err = io.Copy(&buf, chunk)
+
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+
+	if err := checkAppend(ms.session, offset); err != nil {
+		return ms.session, err
+	}
+
+	ms.data = append(ms.data, buf.Bytes()...)
+	ms.session.Offset += int64(buf.Len())
+	ms.session.UpdatedAt = time.Now()
+
+	if err != nil {
+		return ms.session, fmt.Errorf("read chunk: %w", err)
+	}
+
+	return ms.session, nil
+}
+
+// Claim implements [SessionStore].
+func (s *MemorySessionStore) Claim(_ context.Context, id uuid.UUID) (UploadSession, error) {
+	ms, err := s.get(id)
+	if err != nil {
+		return UploadSession{}, err
+	}
+
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+
+	if ms.session.Finishing {
+		return ms.session, fmt.Errorf("%w: %s", ErrSessionFinishing, id)
+	}
+	ms.session.Finishing = true
+
+	return ms.session, nil
+}
+
+// Release implements [SessionStore].
+func (s *MemorySessionStore) Release(_ context.Context, id uuid.UUID) error {
+	ms, err := s.get(id)
+	if err != nil {
+		return err
+	}
+
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	ms.session.Finishing = false
+
+	return nil
+}
+
+// Open implements [SessionStore].
+func (s *MemorySessionStore) Open(_ context.Context, id uuid.UUID) (io.ReadCloser, error) {
+	ms, err := s.get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+
+	return io.NopCloser(bytes.NewReader(ms.data)), nil
+}
+
+// Sessions implements [SessionStore].
+func (s *MemorySessionStore) Sessions(context.Context) ([]UploadSession, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	sessions := make([]UploadSession, 0, len(s.sessions))
+	for _, ms := range s.sessions {
+		ms.mux.Lock()
+		sessions = append(sessions, ms.session)
+		ms.mux.Unlock()
+	}
+	sortSessions(sessions)
+
+	return sessions, nil
+}
+
+// Delete implements [SessionStore].
+func (s *MemorySessionStore) Delete(_ context.Context, id uuid.UUID) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
+
+func (ms *memorySession) checkOffset(offset int64) (UploadSession, error) {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	return ms.session, checkAppend(ms.session, offset)
+}
+
+func (s *MemorySessionStore) get(id uuid.UUID) (*memorySession, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	ms, ok := s.sessions[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, id)
+	}
+
+	return ms, nil
+}
+
+// FileSessionStore is a [SessionStore] that stores upload sessions in a
+// directory of the local filesystem. Each session is stored as a JSON file
+// next to a file that contains the received bytes, so that uploads survive
+// restarts. Appends are serialized per session within a single process; a
+// directory must not be shared by multiple processes.
+type FileSessionStore struct {
+	dir   string
+	locks sync.Map
+}
+
+// NewFileSessionStore returns a [*FileSessionStore] that stores upload
+// sessions in the given directory. The directory is created when the first
+// session is created.
+func NewFileSessionStore(dir string) *FileSessionStore {
+	return &FileSessionStore{dir: dir}
+}
+
+// Create implements [SessionStore].
+func (s *FileSessionStore) Create(_ context.Context, session UploadSession) error {
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+
+	f, err := os.OpenFile(s.dataPath(session.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("create data file: %w", err)
+	}
+	f.Close()
+
+	if err := s.write(session); err != nil {
+		os.Remove(s.dataPath(session.ID))
+		return err
+	}
+
+	return nil
+}
+
+// Session implements [SessionStore].
+func (s *FileSessionStore) Session(_ context.Context, id uuid.UUID) (UploadSession, error) {
+	return s.read(id)
+}
+
+// Append implements [SessionStore].
+func (s *FileSessionStore) Append(_ context.Context, id uuid.UUID, offset int64, chunk io.Reader) (UploadSession, error) {
+	unlock := s.lock(id)
+	defer unlock()
+
+	session, err := s.read(id)
+	if err != nil {
+		return UploadSession{}, err
+	}
+
+	if err := checkAppend(session, offset); err != nil {
+		return session, err
+	}
+
+	f, err := os.OpenFile(s.dataPath(id), os.O_WRONLY, 0)
+	if err != nil {
+		return session, fmt.Errorf("open data file: %w", err)
+	}
+	defer f.Close()
+
+	// Discard bytes of a previous append that failed to update the session.
+	if err := f.Truncate(session.Offset); err != nil {
+		return session, fmt.Errorf("truncate data file: %w", err)
+	}
+	if _, err := f.Seek(session.Offset, io.SeekStart); err != nil {
+		return session, fmt.Errorf("seek data file: %w", err)
+	}
+
+	n, copyErr := io.Copy(f, chunk)
+	if err := f.Sync(); err != nil && copyErr == nil {
+		copyErr = fmt.Errorf("sync data file: %w", err)
+	}
+
+	session.Offset += n
+	session.UpdatedAt = time.Now()
+	if err := s.write(session); err != nil {
+		return session, err
+	}
+
+	if copyErr != nil {
+		return session, fmt.Errorf("write chunk: %w", copyErr)
+	}
+
+	return session, nil
+}
+
+// Claim implements [SessionStore].
+func (s *FileSessionStore) Claim(_ context.Context, id uuid.UUID) (UploadSession, error) {
+	unlock := s.lock(id)
+	defer unlock()
+
+	session, err := s.read(id)
+	if err != nil {
+		return UploadSession{}, err
+	}
+
+	if session.Finishing {
+		return session, fmt.Errorf("%w: %s", ErrSessionFinishing, id)
+	}
+	session.Finishing = true
+
+	if err := s.write(session); err != nil {
+		return UploadSession{}, err
+	}
+
+	return session, nil
+}
+
+// Release implements [SessionStore].
+func (s *FileSessionStore) Release(_ context.Context, id uuid.UUID) error {
+	unlock := s.lock(id)
+	defer unlock()
+
+	session, err := s.read(id)
+	if err != nil {
+		return err
+	}
+	session.Finishing = false
+
+	return s.write(session)
+}
+
+// Open implements [SessionStore].
+func (s *FileSessionStore) Open(_ context.Context, id uuid.UUID) (io.ReadCloser, error) {
+	session, err := s.read(id)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(s.dataPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("open data file: %w", err)
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, session.Offset), f}, nil
+}
+
+// Sessions implements [SessionStore].
+func (s *FileSessionStore) Sessions(context.Context) ([]UploadSession, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read directory: %w", err)
+	}
+
+	var sessions []UploadSession
+	for _, entry := range entries {
+		id, err := uuid.Parse(strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		session, err := s.read(id)
+		if err != nil {
+			if errors.Is(err, ErrSessionNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	sortSessions(sessions)
+
+	return sessions, nil
+}
+
+// Delete implements [SessionStore].
+func (s *FileSessionStore) Delete(_ context.Context, id uuid.UUID) error {
+	unlock := s.lock(id)
+	defer unlock()
+	defer s.locks.Delete(id)
+
+	for _, p := range []string{s.sessionPath(id), s.dataPath(id)} {
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("remove %s: %w", filepath.Base(p), err)
+		}
+	}
+
+	return nil
+}
+
+func (s *FileSessionStore) lock(id uuid.UUID) func() {
+	mux, _ := s.locks.LoadOrStore(id, &sync.Mutex{})
+	mux.(*sync.Mutex).Lock()
+	return mux.(*sync.Mutex).Unlock
+}
+
+func (s *FileSessionStore) read(id uuid.UUID) (UploadSession, error) {
+	b, err := os.ReadFile(s.sessionPath(id))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return UploadSession{}, fmt.Errorf("%w: %s", ErrSessionNotFound, id)
+		}
+		return UploadSession{}, fmt.Errorf("read session: %w", err)
+	}
+
+	var session UploadSession
+	if err := json.Unmarshal(b, &session); err != nil {
+		return UploadSession{}, fmt.Errorf("decode session: %w", err)
+	}
+
+	return session, nil
+}
+
+// write writes a session to a temporary file, and then renames it to its
+// final path, so that readers never see a partially written session.
+func (s *FileSessionStore) write(session UploadSession) error {
+	b, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("encode session: %w", err)
+	}
+
+	tmp := s.sessionPath(session.ID) + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("write session: %w", err)
+	}
+
+	if err := os.Rename(tmp, s.sessionPath(session.ID)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write session: %w", err)
+	}
+
+	return nil
+}
+
+func (s *FileSessionStore) sessionPath(id uuid.UUID) string {
+	return filepath.Join(s.dir, id.String()+".json")
+}
+
+func (s *FileSessionStore) dataPath(id uuid.UUID) string {
+	return filepath.Join(s.dir, id.String()+".data")
+}
+
+// checkAppend returns an error if a chunk cannot be appended to the session at
+// the given offset.
+func checkAppend(session UploadSession, offset int64) error {
+	if session.Finishing {
+		return fmt.Errorf("%w: %s", ErrSessionFinishing, session.ID)
+	}
+
+	if offset != session.Offset {
+		return fmt.Errorf("%w: offset is %d, not %d", ErrOffsetMismatch, session.Offset, offset)
+	}
+
+	return nil
+}
+
+func sortSessions(sessions []UploadSession) {
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+}
@@ -0,0 +1,338 @@
+package esgallery
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	mediafile "github.com/modernice/media-entity/file"
+	"github.com/modernice/media-entity/image"
+)
+
+var (
+	// ErrInvalidSignature is returned by [*URLSigner.Verify] if a URL is not
+	// signed, or if its signature does not match.
+	ErrInvalidSignature = errors.New("invalid url signature")
+
+	// ErrURLExpired is returned by [*URLSigner.Verify] if a signed URL has
+	// expired.
+	ErrURLExpired = errors.New("signed url expired")
+
+	// ErrInvalidKey is returned by [NewURLSigner] if the secret key is shorter
+	// than [MinKeySize].
+	ErrInvalidKey = errors.New("invalid signing key")
+)
+
+// MinKeySize is the minimum size in bytes of the secret key of a [*URLSigner].
+const MinKeySize = 32
+
+// URLSigner generates and verifies HMAC-signed, expiring URLs for files in
+// private galleries. A signed URL grants access to a single file, identified
+// by the Provider and Path of its [image.Storage], until it expires. A signed
+// URL can additionally be restricted to a variant and/or size, which is
+// enforced by [*URLSigner.Handler] if it is given a [VariantResolver].
+//
+//	signer, err := esgallery.NewURLSigner(key, "https://example.com/private")
+//	u := signer.Sign(img.Storage, time.Now().Add(time.Hour))
+//
+//	http.Handle("/private/", signer.Handler(storage))
+type URLSigner struct {
+	key      []byte
+	base     string
+	basePath string
+	now      func() time.Time
+}
+
+// SignedURL provides the verified claims of a signed URL. The Variant and Size
+// restrictions are part of the signature, so they cannot be changed by the
+// client.
+type SignedURL struct {
+	// Storage is the storage location of the file.
+	Storage image.Storage
+
+	// Expires is the time at which the URL expires.
+	Expires time.Time
+
+	// Variant is the id of the variant that the URL is restricted to, or empty
+	// if the URL is not restricted to a variant.
+	Variant string
+
+	// Size is the name of the size (for example "sm") that the URL is
+	// restricted to, or empty if the URL is not restricted to a size.
+	Size string
+}
+
+// Allows reports whether the URL grants access to a file of the given variant
+// and size. Empty restrictions allow every variant and size.
+func (u SignedURL) Allows(file FileVariant) bool {
+	return (u.Variant == "" || u.Variant == file.Variant) && (u.Size == "" || u.Size == file.Size)
+}
+
+func (u SignedURL) restricted() bool {
+	return u.Variant != "" || u.Size != ""
+}
+
+// SignOption is an option for [*URLSigner.Sign].
+type SignOption func(*SignedURL)
+
+// RestrictVariant returns a [SignOption] that restricts a signed URL to the
+// variant with the given id.
+func RestrictVariant(id string) SignOption {
+	return func(u *SignedURL) {
+		u.Variant = id
+	}
+}
+
+// RestrictSize returns a [SignOption] that restricts a signed URL to the size
+// with the given name, for example the dimension name of a processed image.
+func RestrictSize(name string) SignOption {
+	return func(u *SignedURL) {
+		u.Size = name
+	}
+}
+
+// FileVariant is the variant and size of a stored file.
+type FileVariant struct {
+	// Variant is the id of the variant that is stored in the file.
+	Variant string
+
+	// Size is the name of the size of the file, for example "sm", or empty if
+	// the file has no named size.
+	Size string
+}
+
+// VariantResolver returns the [FileVariant] of a stored file. If the file is
+// unknown, it should return an error that satisfies errors.Is(err, ErrNotFound).
+type VariantResolver func(context.Context, image.Storage) (FileVariant, error)
+
+// NewURLSigner returns a [*URLSigner] that signs URLs with the given secret
+// key. Signed URLs are relative to baseURL, which may be an absolute URL, for
+// example "https://example.com/private", or a path, for example "/private".
+// The key must be at least [MinKeySize] bytes long, otherwise an error that
+// satisfies errors.Is(err, ErrInvalidKey) is returned.
+func NewURLSigner(key []byte, baseURL string) (*URLSigner, error) {
+	if len(key) < MinKeySize {
+		return nil, fmt.Errorf("%w: key must be at least %d bytes long; is %d bytes", ErrInvalidKey, MinKeySize, len(key))
+	}
+
+	base := strings.TrimSuffix(baseURL, "/")
+
+	basePath := base
+	if u, err := url.Parse(base); err == nil {
+		basePath = u.Path
+	}
+
+	return &URLSigner{
+		key:      key,
+		base:     base,
+		basePath: basePath,
+		now:      time.Now,
+	}, nil
+}
+
+// Sign returns a URL for the given file that expires at the given time.
+// Restrictions that are passed as [SignOption]s are part of the signature.
+func (s *URLSigner) Sign(file image.Storage, expires time.Time, opts ...SignOption) string {
+	// Leading slashes are not part of the URL path.
+	file.Path = strings.TrimPrefix(file.Path, "/")
+
+	claims := SignedURL{Storage: file, Expires: expires}
+	for _, opt := range opts {
+		opt(&claims)
+	}
+
+	q := url.Values{}
+	q.Set("provider", file.Provider)
+	q.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	if claims.Variant != "" {
+		q.Set("variant", claims.Variant)
+	}
+	if claims.Size != "" {
+		q.Set("size", claims.Size)
+	}
+	q.Set("signature", s.signature(claims))
+
+	u, _ := mediafile.BaseURL(s.base).ResolveURL(file)
+
+	return u + "?" + q.Encode()
+}
+
+// Resolver returns an [image.URLResolver] that resolves signed URLs that
+// expire after the given duration. The returned resolver can be used to fill
+// the URLs of gallery DTOs.
+func (s *URLSigner) Resolver(ttl time.Duration, opts ...SignOption) image.URLResolver {
+	return mediafile.URLResolverFunc(func(file image.Storage) (string, error) {
+		return s.Sign(file, s.now().Add(ttl), opts...), nil
+	})
+}
+
+// Verify verifies the signature and expiry of the given URL, and returns the
+// claims of the URL. If the signature is invalid, an error that satisfies
+// errors.Is(err, ErrInvalidSignature) is returned. If the URL has expired, an
+// error that satisfies errors.Is(err, ErrURLExpired) is returned.
+func (s *URLSigner) Verify(u *url.URL) (SignedURL, error) {
+	p := strings.TrimPrefix(u.Path, s.basePath+"/")
+	if p == u.Path || p == "" {
+		return SignedURL{}, fmt.Errorf("%w: path %q is outside of %q", ErrInvalidSignature, u.Path, s.basePath)
+	}
+
+	q := u.Query()
+
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil {
+		return SignedURL{}, fmt.Errorf("%w: invalid expiry %q", ErrInvalidSignature, q.Get("expires"))
+	}
+
+	claims := SignedURL{
+		Storage: image.Storage{
+			Provider: q.Get("provider"),
+			Path:     p,
+		},
+		Expires: time.Unix(expires, 0),
+		Variant: q.Get("variant"),
+		Size:    q.Get("size"),
+	}
+
+	signature, err := hex.DecodeString(q.Get("signature"))
+	if err != nil {
+		return SignedURL{}, fmt.Errorf("%w: malformed signature", ErrInvalidSignature)
+	}
+
+	expected, _ := hex.DecodeString(s.signature(claims))
+	if !hmac.Equal(signature, expected) {
+		return SignedURL{}, ErrInvalidSignature
+	}
+
+	if !s.now().Before(claims.Expires) {
+		return claims, fmt.Errorf("%w at %s", ErrURLExpired, claims.Expires.Format(time.RFC3339))
+	}
+
+	return claims, nil
+}
+
+// Middleware returns a middleware that only calls the next handler if the
+// request URL has a valid signature. Requests with a missing or invalid
+// signature are rejected with 403 Forbidden, and expired URLs with 410 Gone.
+// The verified claims are available to the next handler through
+// [SignedURLFromContext]. The middleware does not enforce the Variant and Size
+// restrictions of a URL; the next handler must check them using
+// [SignedURL.Allows].
+//
+// The middleware must be mounted at the path of the base URL of the signer,
+// without stripping the path prefix.
+func (s *URLSigner) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := s.Verify(r.URL)
+		if err != nil {
+			status := http.StatusForbidden
+			if errors.Is(err, ErrURLExpired) {
+				status = http.StatusGone
+			}
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), signedURLKey{}, claims)))
+	})
+}
+
+// SignedHandlerOption is an option for [*URLSigner.Handler].
+type SignedHandlerOption func(*signedHandlerConfig)
+
+type signedHandlerConfig struct {
+	variants VariantResolver
+}
+
+// ResolveVariants returns a [SignedHandlerOption] that enforces the Variant and
+// Size restrictions of signed URLs, using the provided [VariantResolver] to
+// determine the variant and size of the requested file.
+func ResolveVariants(resolve VariantResolver) SignedHandlerOption {
+	return func(cfg *signedHandlerConfig) {
+		cfg.variants = resolve
+	}
+}
+
+// Handler returns an [http.Handler] that serves files from the provided
+// [Storage] if the request URL has a valid signature. Read the documentation
+// of [*URLSigner.Middleware] for more information.
+//
+// URLs that are restricted to a variant or size are rejected with 403
+// Forbidden if the requested file is another variant or size, as determined
+// by the [VariantResolver] of the [ResolveVariants] option. Without the
+// ResolveVariants option, restricted URLs are always rejected, because their
+// restrictions cannot be enforced.
+func (s *URLSigner) Handler(storage Storage, opts ...SignedHandlerOption) http.Handler {
+	var cfg signedHandlerConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	files := NewFileServer(storage)
+	return s.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := SignedURLFromContext(r.Context())
+
+		if claims.restricted() {
+			if status := cfg.enforce(r.Context(), claims); status != http.StatusOK {
+				http.Error(w, http.StatusText(status), status)
+				return
+			}
+		}
+
+		// Signed URLs must not be cached beyond their expiry.
+		maxAge := int(time.Until(claims.Expires).Seconds())
+
+		files.serveFile(w, r, claims.Storage, fmt.Sprintf("private, max-age=%d", maxAge))
+	}))
+}
+
+// enforce checks the Variant and Size restrictions of a signed URL, and returns
+// the HTTP status of the response.
+func (cfg signedHandlerConfig) enforce(ctx context.Context, claims SignedURL) int {
+	if cfg.variants == nil {
+		return http.StatusForbidden
+	}
+
+	file, err := cfg.variants(ctx, claims.Storage)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return http.StatusNotFound
+		}
+		return http.StatusInternalServerError
+	}
+
+	if !claims.Allows(file) {
+		return http.StatusForbidden
+	}
+
+	return http.StatusOK
+}
+
+type signedURLKey struct{}
+
+// SignedURLFromContext returns the verified claims of the signed URL of a
+// request that passed the middleware of a [*URLSigner].
+func SignedURLFromContext(ctx context.Context) (SignedURL, bool) {
+	claims, ok := ctx.Value(signedURLKey{}).(SignedURL)
+	return claims, ok
+}
+
+func (s *URLSigner) signature(claims SignedURL) string {
+	mac := hmac.New(sha256.New, s.key)
+	io.WriteString(mac, strings.Join([]string{
+		claims.Storage.Provider,
+		claims.Storage.Path,
+		strconv.FormatInt(claims.Expires.Unix(), 10),
+		claims.Variant,
+		claims.Size,
+	}, "\n"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,290 @@
+package esgallery_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/modernice/media-entity/goes/esgallery"
+	"github.com/modernice/media-entity/image"
+)
+
+var testKey = bytes.Repeat([]byte("k"), esgallery.MinKeySize)
+
+func TestNewURLSigner_invalidKey(t *testing.T) {
+	for _, key := range [][]byte{nil, {}, []byte("secret"), testKey[1:]} {
+		if _, err := esgallery.NewURLSigner(key, "/private"); !errors.Is(err, esgallery.ErrInvalidKey) {
+			t.Fatalf("NewURLSigner() with a %d byte key should fail with %q; got %v", len(key), esgallery.ErrInvalidKey, err)
+		}
+	}
+}
+
+func TestURLSigner_Handler(t *testing.T) {
+	var storage esgallery.MemoryStorage
+	stored, err := storage.Put(context.Background(), "foo/bar baz.jpg", newExample())
+	if err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+
+	signer := newURLSigner(t, testKey, "/private")
+	server := httptest.NewServer(signer.Handler(&storage))
+	defer server.Close()
+
+	signed := signer.Sign(stored, time.Now().Add(time.Minute))
+	if !strings.HasPrefix(signed, "/private/foo/bar%20baz.jpg?") {
+		t.Fatalf("signed URL should start with the escaped path; got %q", signed)
+	}
+
+	resp := get(t, server.URL+signed)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("signed URL should be served with status %d; got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if !bytes.Equal(body, example) {
+		t.Fatalf("response has wrong contents")
+	}
+
+	if ct := resp.Header.Get("Content-Type"); ct != "image/jpeg" {
+		t.Fatalf("Content-Type should be %q; is %q", "image/jpeg", ct)
+	}
+
+	if cc := resp.Header.Get("Cache-Control"); !strings.HasPrefix(cc, "private") {
+		t.Fatalf("Cache-Control should be private; is %q", cc)
+	}
+}
+
+func TestURLSigner_Handler_invalid(t *testing.T) {
+	var storage esgallery.MemoryStorage
+	stored, err := storage.Put(context.Background(), "foo.jpg", newExample())
+	if err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	if _, err := storage.Put(context.Background(), "bar.jpg", newExample()); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+
+	signer := newURLSigner(t, testKey, "https://example.com/private")
+	server := httptest.NewServer(signer.Handler(&storage))
+	defer server.Close()
+
+	expires := time.Now().Add(time.Minute)
+	signed := requestURI(t, signer.Sign(stored, expires))
+
+	tests := []struct {
+		name string
+		uri  string
+		want int
+	}{
+		{
+			name: "unsigned",
+			uri:  "/private/foo.jpg",
+			want: http.StatusForbidden,
+		},
+		{
+			name: "other path",
+			uri:  strings.Replace(signed, "foo.jpg", "bar.jpg", 1),
+			want: http.StatusForbidden,
+		},
+		{
+			name: "other expiry",
+			uri:  strings.Replace(signed, strconv.FormatInt(expires.Unix(), 10), strconv.FormatInt(expires.Add(time.Hour).Unix(), 10), 1),
+			want: http.StatusForbidden,
+		},
+		{
+			name: "other key",
+			uri:  requestURI(t, newURLSigner(t, bytes.Repeat([]byte("o"), esgallery.MinKeySize), "/private").Sign(stored, time.Now().Add(time.Minute))),
+			want: http.StatusForbidden,
+		},
+		{
+			name: "expired",
+			uri:  requestURI(t, signer.Sign(stored, time.Now().Add(-time.Minute))),
+			want: http.StatusGone,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if resp := get(t, server.URL+tt.uri); resp.StatusCode != tt.want {
+				t.Fatalf("%s should be rejected with status %d; got %d", tt.uri, tt.want, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestURLSigner_Handler_restrictions(t *testing.T) {
+	var storage esgallery.MemoryStorage
+	small, err := storage.Put(context.Background(), "sm.jpg", newExample())
+	if err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	large, err := storage.Put(context.Background(), "lg.jpg", newExample())
+	if err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+
+	variants := map[string]esgallery.FileVariant{
+		small.Path: {Variant: "a", Size: "sm"},
+		large.Path: {Variant: "b", Size: "lg"},
+	}
+	resolve := func(_ context.Context, file image.Storage) (esgallery.FileVariant, error) {
+		v, ok := variants[file.Path]
+		if !ok {
+			return v, esgallery.ErrNotFound
+		}
+		return v, nil
+	}
+
+	signer := newURLSigner(t, testKey, "/private")
+	server := httptest.NewServer(signer.Handler(&storage, esgallery.ResolveVariants(resolve)))
+	defer server.Close()
+
+	unenforced := httptest.NewServer(signer.Handler(&storage))
+	defer unenforced.Close()
+
+	expires := time.Now().Add(time.Minute)
+	signedSmall := signer.Sign(small, expires, esgallery.RestrictSize("sm"))
+
+	tests := []struct {
+		name   string
+		server *httptest.Server
+		uri    string
+		want   int
+	}{
+		{
+			name:   "allowed size",
+			server: server,
+			uri:    signedSmall,
+			want:   http.StatusOK,
+		},
+		{
+			name:   "allowed variant and size",
+			server: server,
+			uri:    signer.Sign(small, expires, esgallery.RestrictVariant("a"), esgallery.RestrictSize("sm")),
+			want:   http.StatusOK,
+		},
+		{
+			name:   "unrestricted",
+			server: server,
+			uri:    signer.Sign(large, expires),
+			want:   http.StatusOK,
+		},
+		{
+			name:   "other size",
+			server: server,
+			uri:    signer.Sign(large, expires, esgallery.RestrictSize("sm")),
+			want:   http.StatusForbidden,
+		},
+		{
+			name:   "other variant",
+			server: server,
+			uri:    signer.Sign(large, expires, esgallery.RestrictVariant("a")),
+			want:   http.StatusForbidden,
+		},
+		{
+			name:   "changed restriction",
+			server: server,
+			uri:    strings.Replace(signedSmall, "size=sm", "size=lg", 1),
+			want:   http.StatusForbidden,
+		},
+		{
+			name:   "removed restriction",
+			server: server,
+			uri:    strings.Replace(signedSmall, "&size=sm", "", 1),
+			want:   http.StatusForbidden,
+		},
+		{
+			name:   "unknown file",
+			server: server,
+			uri:    signer.Sign(image.Storage{Provider: small.Provider, Path: "unknown.jpg"}, expires, esgallery.RestrictSize("sm")),
+			want:   http.StatusNotFound,
+		},
+		{
+			name:   "no resolver",
+			server: unenforced,
+			uri:    signedSmall,
+			want:   http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if resp := get(t, tt.server.URL+tt.uri); resp.StatusCode != tt.want {
+				t.Fatalf("%s should be served with status %d; got %d", tt.uri, tt.want, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestURLSigner_Verify(t *testing.T) {
+	signer := newURLSigner(t, testKey, "/private")
+
+	var storage esgallery.MemoryStorage
+	stored, _ := storage.Put(context.Background(), "foo.jpg", newExample())
+
+	expires := time.Now().Add(time.Minute).Truncate(time.Second)
+	u, _ := url.Parse(signer.Sign(stored, expires, esgallery.RestrictVariant("variant"), esgallery.RestrictSize("sm")))
+
+	claims, err := signer.Verify(u)
+	if err != nil {
+		t.Fatalf("Verify() failed: %v", err)
+	}
+
+	want := esgallery.SignedURL{Storage: stored, Expires: expires, Variant: "variant", Size: "sm"}
+	if claims.Storage != want.Storage || !claims.Expires.Equal(want.Expires) || claims.Variant != want.Variant || claims.Size != want.Size {
+		t.Fatalf("Verify() should return %+v; got %+v", want, claims)
+	}
+
+	restricted := *u
+	restricted.RawQuery = strings.Replace(u.RawQuery, "variant=variant", "variant=other", 1)
+	if _, err := signer.Verify(&restricted); !errors.Is(err, esgallery.ErrInvalidSignature) {
+		t.Fatalf("Verify() should fail with %q; got %v", esgallery.ErrInvalidSignature, err)
+	}
+
+	u.RawQuery = strings.Replace(u.RawQuery, "provider=memory", "provider=other", 1)
+	if _, err := signer.Verify(u); !errors.Is(err, esgallery.ErrInvalidSignature) {
+		t.Fatalf("Verify() should fail with %q; got %v", esgallery.ErrInvalidSignature, err)
+	}
+}
+
+func newURLSigner(t *testing.T, key []byte, baseURL string) *esgallery.URLSigner {
+	t.Helper()
+
+	signer, err := esgallery.NewURLSigner(key, baseURL)
+	if err != nil {
+		t.Fatalf("NewURLSigner() failed: %v", err)
+	}
+
+	return signer
+}
+
+func get(t *testing.T, u string) *http.Response {
+	t.Helper()
+
+	resp, err := http.Get(u)
+	if err != nil {
+		t.Fatalf("GET %s: %v", u, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+func requestURI(t *testing.T, rawURL string) string {
+	t.Helper()
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("parse %q: %v", rawURL, err)
+	}
+
+	return u.RequestURI()
+}
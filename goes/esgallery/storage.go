@@ -3,15 +3,36 @@ package esgallery
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"net/http"
 	"path"
 	"sync"
+	"time"
 
 	"github.com/modernice/media-entity/image"
 )
 
-var _ Storage = (*MemoryStorage)(nil)
+var _ ManagedStorage = (*MemoryStorage)(nil)
+
+var (
+	// ErrNotFound is returned by a [Storage] if a file does not exist.
+	ErrNotFound = errors.New("file not found in storage")
+
+	// ErrNotSupported is returned if an operation requires a [ManagedStorage],
+	// but the [Storage] does not implement it.
+	ErrNotSupported = errors.New("operation not supported by storage")
+
+	// ErrUnknownProvider is returned by a [ProviderStorage] if a file is
+	// requested from a provider that is not configured.
+	ErrUnknownProvider = errors.New("unknown storage provider")
+
+	// ErrInvalidPath is returned by a [Storage] if a storage path would
+	// resolve to a location outside of the root of the storage.
+	ErrInvalidPath = errors.New("invalid storage path")
+)
 
 // Storage is the storage for gallery images.
 type Storage interface {
@@ -19,15 +40,69 @@ type Storage interface {
 	// path and returns the storage location of the uploaded image.
 	Put(ctx context.Context, path string, contents io.Reader) (image.Storage, error)
 
-	// Get returns the contents of the image at the given storage path.
+	// Get returns the contents of the image at the given storage path. If the
+	// image does not exist, an error that satisfies errors.Is(err, ErrNotFound)
+	// should be returned. If the returned reader implements [io.Closer], the
+	// caller must close it.
 	Get(ctx context.Context, path string) (io.Reader, error)
 }
 
+// ManagedStorage is a [Storage] that can also delete files and return
+// information about them. Implementing ManagedStorage is optional; it allows
+// the [*Uploader] to clean up files that are no longer used.
+type ManagedStorage interface {
+	Storage
+
+	// Delete deletes the file at the given storage path. Deleting a file that
+	// does not exist is not an error.
+	Delete(ctx context.Context, path string) error
+
+	// Stat returns information about the file at the given storage path. If
+	// the file does not exist, an error that satisfies
+	// errors.Is(err, ErrNotFound) should be returned.
+	Stat(ctx context.Context, path string) (FileInfo, error)
+}
+
+// ProviderStorage is a [ManagedStorage] that stores files at multiple
+// providers, such as [*StorageRouter]. If the storage of an [*Uploader],
+// [*Processor], or [*GarbageCollector] implements ProviderStorage, files are
+// read and deleted at the Provider that is recorded in their [image.Storage].
+type ProviderStorage interface {
+	ManagedStorage
+
+	// GetFrom returns the contents of the file at the given path of the given
+	// provider.
+	GetFrom(ctx context.Context, provider, path string) (io.Reader, error)
+
+	// DeleteFrom deletes the file at the given path of the given provider.
+	DeleteFrom(ctx context.Context, provider, path string) error
+
+	// StatFrom returns information about the file at the given path of the
+	// given provider.
+	StatFrom(ctx context.Context, provider, path string) (FileInfo, error)
+}
+
+// FileInfo provides information about a file in a [ManagedStorage].
+type FileInfo struct {
+	// Path is the storage path of the file.
+	Path string
+
+	// Size is the size of the file in bytes.
+	Size int64
+
+	// ContentType is the MIME type of the file.
+	ContentType string
+
+	// ModTime is the time the file was last written.
+	ModTime time.Time
+}
+
 // MemoryStorage is a thread-safe [Storage] that stores images in memory.
 type MemoryStorage struct {
 	mux   sync.RWMutex
 	once  sync.Once
 	files map[string][]byte
+	times map[string]time.Time
 	root  string
 }
 
@@ -52,6 +127,9 @@ func (s *MemoryStorage) Files() map[string][]byte {
 
 // Put implements [Storage].
 func (s *MemoryStorage) Put(_ context.Context, p string, contents io.Reader) (image.Storage, error) {
+	if err := checkPath(p); err != nil {
+		return image.Storage{}, err
+	}
 	joined := path.Join(s.root, p)
 
 	b, err := io.ReadAll(contents)
@@ -62,8 +140,12 @@ func (s *MemoryStorage) Put(_ context.Context, p string, contents io.Reader) (im
 	s.mux.Lock()
 	defer s.mux.Unlock()
 
-	s.once.Do(func() { s.files = make(map[string][]byte) })
+	s.once.Do(func() {
+		s.files = make(map[string][]byte)
+		s.times = make(map[string]time.Time)
+	})
 	s.files[joined] = b
+	s.times[joined] = time.Now()
 
 	return image.Storage{
 		Provider: "memory",
@@ -71,8 +153,11 @@ func (s *MemoryStorage) Put(_ context.Context, p string, contents io.Reader) (im
 	}, nil
 }
 
-// Put implements [Storage].
+// Get implements [Storage].
 func (s *MemoryStorage) Get(_ context.Context, p string) (io.Reader, error) {
+	if err := checkPath(p); err != nil {
+		return nil, err
+	}
 	p = path.Join(s.root, p)
 
 	s.mux.RLock()
@@ -80,8 +165,87 @@ func (s *MemoryStorage) Get(_ context.Context, p string) (io.Reader, error) {
 
 	contents, ok := s.files[p]
 	if !ok {
-		return nil, fmt.Errorf("image %q not found in memory storage", p)
+		return nil, fmt.Errorf("%w: %q [provider=memory]", ErrNotFound, p)
 	}
 
 	return bytes.NewReader(contents), nil
 }
+
+// Delete implements [ManagedStorage].
+func (s *MemoryStorage) Delete(_ context.Context, p string) error {
+	if err := checkPath(p); err != nil {
+		return err
+	}
+	p = path.Join(s.root, p)
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	delete(s.files, p)
+	delete(s.times, p)
+
+	return nil
+}
+
+// Stat implements [ManagedStorage].
+func (s *MemoryStorage) Stat(_ context.Context, p string) (FileInfo, error) {
+	if err := checkPath(p); err != nil {
+		return FileInfo{}, err
+	}
+	joined := path.Join(s.root, p)
+
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	contents, ok := s.files[joined]
+	if !ok {
+		return FileInfo{}, fmt.Errorf("%w: %q [provider=memory]", ErrNotFound, joined)
+	}
+
+	return FileInfo{
+		Path:        p,
+		Size:        int64(len(contents)),
+		ContentType: contentTypeOf(p, contents),
+		ModTime:     s.times[joined],
+	}, nil
+}
+
+// getFile returns the contents of the file at the given storage location. If
+// the storage is a [ProviderStorage], the file is read from its provider.
+func getFile(ctx context.Context, s Storage, file image.Storage) (io.Reader, error) {
+	if ps, ok := s.(ProviderStorage); ok && file.Provider != "" {
+		return ps.GetFrom(ctx, file.Provider, file.Path)
+	}
+	return s.Get(ctx, file.Path)
+}
+
+// deleteFile deletes the file at the given storage location. If the storage is
+// a [ProviderStorage], the file is deleted from its provider.
+func deleteFile(ctx context.Context, s ManagedStorage, file image.Storage) error {
+	if ps, ok := s.(ProviderStorage); ok && file.Provider != "" {
+		return ps.DeleteFrom(ctx, file.Provider, file.Path)
+	}
+	return s.Delete(ctx, file.Path)
+}
+
+// statFile returns information about the file at the given storage location.
+// If the storage is a [ProviderStorage], the file is looked up at its provider.
+func statFile(ctx context.Context, s ManagedStorage, file image.Storage) (FileInfo, error) {
+	if ps, ok := s.(ProviderStorage); ok && file.Provider != "" {
+		return ps.StatFrom(ctx, file.Provider, file.Path)
+	}
+	return s.Stat(ctx, file.Path)
+}
+
+// contentTypeOf returns the MIME type of a file. The MIME type is determined
+// from the file extension, or from the first bytes of the file if the file
+// extension is unknown.
+func contentTypeOf(p string, head []byte) string {
+	if contentType := mime.TypeByExtension(path.Ext(p)); contentType != "" {
+		return contentType
+	}
+	if len(head) > 512 {
+		head = head[:512]
+	}
+	return http.DetectContentType(head)
+}
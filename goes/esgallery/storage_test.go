@@ -0,0 +1,102 @@
+package esgallery_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/modernice/media-entity/goes/esgallery"
+	"github.com/modernice/media-entity/goes/esgallery/storagetest"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	storagetest.Run(t, "MemoryStorage", func(*testing.T) esgallery.Storage {
+		return &esgallery.MemoryStorage{}
+	})
+}
+
+func TestFileStorage(t *testing.T) {
+	storagetest.Run(t, "FileStorage", func(t *testing.T) esgallery.Storage {
+		return esgallery.NewFileStorage(t.TempDir())
+	})
+}
+
+func TestFileStorage_Put(t *testing.T) {
+	root := t.TempDir()
+	storage := esgallery.NewFileStorage(root)
+
+	stored, err := storage.Put(context.Background(), "foo/bar.jpg", bytes.NewReader([]byte("foo")))
+	if err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+
+	if stored.Provider != "fs" {
+		t.Fatalf("provider should be %q; is %q", "fs", stored.Provider)
+	}
+
+	contents, err := os.ReadFile(filepath.Join(root, "foo", "bar.jpg"))
+	if err != nil {
+		t.Fatalf("read stored file: %v", err)
+	}
+
+	if string(contents) != "foo" {
+		t.Fatalf("stored file has wrong contents %q", contents)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(root, "foo"))
+	if err != nil {
+		t.Fatalf("read directory: %v", err)
+	}
+
+	if len(entries) != 1 {
+		t.Fatalf("temporary files should be removed; directory contains %d files", len(entries))
+	}
+}
+
+func TestFileStorage_FileMode(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []esgallery.FileStorageOption
+		want os.FileMode
+	}{
+		{name: "default", want: esgallery.DefaultFileMode},
+		{name: "custom", opts: []esgallery.FileStorageOption{esgallery.FileMode(0o640)}, want: 0o640},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			storage := esgallery.NewFileStorage(root, tt.opts...)
+
+			if _, err := storage.Put(context.Background(), "foo.jpg", bytes.NewReader([]byte("foo"))); err != nil {
+				t.Fatalf("Put() failed: %v", err)
+			}
+
+			info, err := os.Stat(filepath.Join(root, "foo.jpg"))
+			if err != nil {
+				t.Fatalf("stat stored file: %v", err)
+			}
+
+			if info.Mode().Perm() != tt.want {
+				t.Fatalf("stored file should have mode %v; has %v", tt.want, info.Mode().Perm())
+			}
+		})
+	}
+}
+
+func TestFileStorage_traversal(t *testing.T) {
+	storage := esgallery.NewFileStorage(t.TempDir())
+
+	for _, p := range []string{"../foo.jpg", "foo/../../bar.jpg", `foo\..\..\bar.jpg`, ""} {
+		if _, err := storage.Put(context.Background(), p, bytes.NewReader(nil)); !errors.Is(err, esgallery.ErrInvalidPath) {
+			t.Fatalf("Put(%q) should fail with %q; got %v", p, esgallery.ErrInvalidPath, err)
+		}
+
+		if _, err := storage.Get(context.Background(), p); !errors.Is(err, esgallery.ErrInvalidPath) {
+			t.Fatalf("Get(%q) should fail with %q; got %v", p, esgallery.ErrInvalidPath, err)
+		}
+	}
+}
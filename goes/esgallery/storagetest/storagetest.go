@@ -0,0 +1,256 @@
+// Package storagetest provides conformance tests for [esgallery.Storage]
+// implementations.
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/modernice/media-entity/goes/esgallery"
+)
+
+// StorageFactory creates an empty [esgallery.Storage].
+type StorageFactory func(*testing.T) esgallery.Storage
+
+// Run tests a [esgallery.Storage] implementation. If the storage implements
+// [esgallery.ManagedStorage], Delete and Stat are tested as well.
+//
+//	func TestMyStorage(t *testing.T) {
+//		storagetest.Run(t, "MyStorage", func(t *testing.T) esgallery.Storage {
+//			return NewMyStorage()
+//		})
+//	}
+func Run(t *testing.T, name string, newStorage StorageFactory) {
+	t.Run(name, func(t *testing.T) {
+		run(t, "Put_Get", newStorage, testPutGet)
+		run(t, "Put_overwrite", newStorage, testOverwrite)
+		run(t, "Put_nested", newStorage, testNested)
+		run(t, "Get_notFound", newStorage, testNotFound)
+		run(t, "Put_Get_traversal", newStorage, testTraversal)
+		run(t, "Concurrency", newStorage, testConcurrency)
+		run(t, "Delete", newStorage, testDelete)
+		run(t, "Stat", newStorage, testStat)
+	})
+}
+
+func run(t *testing.T, name string, newStorage StorageFactory, runner func(*testing.T, StorageFactory)) {
+	t.Run(name, func(t *testing.T) {
+		runner(t, newStorage)
+	})
+}
+
+func testPutGet(t *testing.T, newStorage StorageFactory) {
+	storage := newStorage(t)
+
+	contents := []byte("foo")
+	stored, err := storage.Put(context.Background(), "foo/bar.jpg", bytes.NewReader(contents))
+	if err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+
+	if stored.Path != "foo/bar.jpg" {
+		t.Fatalf("Put() should return the provided path %q; got %q", "foo/bar.jpg", stored.Path)
+	}
+
+	if stored.Provider == "" {
+		t.Fatalf("Put() should return a non-empty provider")
+	}
+
+	expectContents(t, storage, stored.Path, contents)
+}
+
+func testOverwrite(t *testing.T, newStorage StorageFactory) {
+	storage := newStorage(t)
+
+	if _, err := storage.Put(context.Background(), "foo.jpg", bytes.NewReader([]byte("foo"))); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+
+	if _, err := storage.Put(context.Background(), "foo.jpg", bytes.NewReader([]byte("bar"))); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+
+	expectContents(t, storage, "foo.jpg", []byte("bar"))
+}
+
+func testNested(t *testing.T, newStorage StorageFactory) {
+	storage := newStorage(t)
+
+	paths := []string{"a.jpg", "a/b.jpg", "a/b/c.jpg"}
+	for _, p := range paths {
+		if _, err := storage.Put(context.Background(), p, bytes.NewReader([]byte(p))); err != nil {
+			t.Fatalf("Put(%q) failed: %v", p, err)
+		}
+	}
+
+	for _, p := range paths {
+		expectContents(t, storage, p, []byte(p))
+	}
+}
+
+func testNotFound(t *testing.T, newStorage StorageFactory) {
+	storage := newStorage(t)
+
+	r, err := storage.Get(context.Background(), "foo/bar.jpg")
+	if c, ok := r.(io.Closer); ok {
+		c.Close()
+	}
+
+	if !errors.Is(err, esgallery.ErrNotFound) {
+		t.Fatalf("Get() of a missing file should return %q; got %v", esgallery.ErrNotFound, err)
+	}
+}
+
+func testTraversal(t *testing.T, newStorage StorageFactory) {
+	storage := newStorage(t)
+
+	for _, p := range []string{"../foo.jpg", "foo/../../bar.jpg", `foo\..\..\bar.jpg`} {
+		if _, err := storage.Put(context.Background(), p, bytes.NewReader([]byte("foo"))); !errors.Is(err, esgallery.ErrInvalidPath) {
+			t.Fatalf("Put(%q) should fail with %q; got %v", p, esgallery.ErrInvalidPath, err)
+		}
+
+		r, err := storage.Get(context.Background(), p)
+		if c, ok := r.(io.Closer); ok {
+			c.Close()
+		}
+		if !errors.Is(err, esgallery.ErrInvalidPath) {
+			t.Fatalf("Get(%q) should fail with %q; got %v", p, esgallery.ErrInvalidPath, err)
+		}
+	}
+}
+
+func testConcurrency(t *testing.T, newStorage StorageFactory) {
+	storage := newStorage(t)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 30)
+
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			p := fmt.Sprintf("concurrent/%d.jpg", i%10)
+			if _, err := storage.Put(context.Background(), p, bytes.NewReader([]byte(p))); err != nil {
+				errs <- fmt.Errorf("Put(%q): %w", p, err)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		p := fmt.Sprintf("concurrent/%d.jpg", i)
+		expectContents(t, storage, p, []byte(p))
+	}
+}
+
+func testDelete(t *testing.T, newStorage StorageFactory) {
+	storage := managed(t, newStorage)
+
+	if _, err := storage.Put(context.Background(), "foo/bar.jpg", bytes.NewReader([]byte("foo"))); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+
+	if err := storage.Delete(context.Background(), "foo/bar.jpg"); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+
+	r, err := storage.Get(context.Background(), "foo/bar.jpg")
+	if c, ok := r.(io.Closer); ok {
+		c.Close()
+	}
+
+	if !errors.Is(err, esgallery.ErrNotFound) {
+		t.Fatalf("Get() of a deleted file should return %q; got %v", esgallery.ErrNotFound, err)
+	}
+
+	if err := storage.Delete(context.Background(), "foo/bar.jpg"); err != nil {
+		t.Fatalf("Delete() of a missing file should not fail; got %v", err)
+	}
+}
+
+func testStat(t *testing.T, newStorage StorageFactory) {
+	storage := managed(t, newStorage)
+
+	contents := []byte("<html><body>foo</body></html>")
+	if _, err := storage.Put(context.Background(), "foo/bar.jpg", bytes.NewReader(contents)); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+
+	if _, err := storage.Put(context.Background(), "foo/bar", bytes.NewReader(contents)); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+
+	info, err := storage.Stat(context.Background(), "foo/bar.jpg")
+	if err != nil {
+		t.Fatalf("Stat() failed: %v", err)
+	}
+
+	if info.Path != "foo/bar.jpg" {
+		t.Fatalf("Stat() should return path %q; got %q", "foo/bar.jpg", info.Path)
+	}
+
+	if info.Size != int64(len(contents)) {
+		t.Fatalf("Stat() should return size %d; got %d", len(contents), info.Size)
+	}
+
+	if info.ContentType != "image/jpeg" {
+		t.Fatalf("Stat() should return content type %q; got %q", "image/jpeg", info.ContentType)
+	}
+
+	if info.ModTime.IsZero() {
+		t.Fatalf("Stat() should return the modification time")
+	}
+
+	info, err = storage.Stat(context.Background(), "foo/bar")
+	if err != nil {
+		t.Fatalf("Stat() failed: %v", err)
+	}
+
+	if info.ContentType != "text/html; charset=utf-8" {
+		t.Fatalf("Stat() should detect content type %q; got %q", "text/html; charset=utf-8", info.ContentType)
+	}
+
+	if _, err := storage.Stat(context.Background(), "foo/baz.jpg"); !errors.Is(err, esgallery.ErrNotFound) {
+		t.Fatalf("Stat() of a missing file should return %q; got %v", esgallery.ErrNotFound, err)
+	}
+}
+
+func managed(t *testing.T, newStorage StorageFactory) esgallery.ManagedStorage {
+	storage, ok := newStorage(t).(esgallery.ManagedStorage)
+	if !ok {
+		t.Skip("storage does not implement esgallery.ManagedStorage")
+	}
+	return storage
+}
+
+func expectContents(t *testing.T, storage esgallery.Storage, path string, want []byte) {
+	t.Helper()
+
+	r, err := storage.Get(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Get(%q) failed: %v", path, err)
+	}
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read contents of %q: %v", path, err)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Fatalf("Get(%q) returned wrong contents; want %q; got %q", path, want, got)
+	}
+}
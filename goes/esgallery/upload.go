@@ -3,9 +3,15 @@ package esgallery
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	stdimage "image"
 	"io"
+	"net/http"
+	"os"
+	"time"
 
 	"github.com/modernice/goes/helper/pick"
 	"github.com/modernice/media-entity/gallery"
@@ -16,13 +22,95 @@ import (
 // to a [*Processor] to automatically upload processed images to (cloud) storage.
 type Uploader[StackID, ImageID ID] struct {
 	storage Storage
+	cfg     uploaderConfig
+}
+
+// UploaderOption is an option for [NewUploader].
+type UploaderOption func(*uploaderConfig)
+
+type uploaderConfig struct {
+	policy           UploadPolicy
+	paths            PathStrategy
+	contentAddressed bool
+	refs             ReferenceCounter
+	imports          ImportPolicy
+	captions         string
+	discardGPS       bool
+}
+
+// WithUploadPolicy returns an [UploaderOption] that validates new images
+// against the provided [UploadPolicy] before they are uploaded.
+func WithUploadPolicy(policy UploadPolicy) UploaderOption {
+	return func(cfg *uploaderConfig) {
+		cfg.policy = policy
+	}
+}
+
+// WithPathStrategy returns an [UploaderOption] that determines the storage
+// paths of uploaded images using the provided [PathStrategy]. Images that are
+// uploaded with content addressing enabled are always stored at their
+// content-addressed path.
+func WithPathStrategy(paths PathStrategy) UploaderOption {
+	return func(cfg *uploaderConfig) {
+		cfg.paths = paths
+	}
+}
+
+// WithContentAddressing returns an [UploaderOption] that stores images at
+// content-addressed paths, which are derived from the SHA-256 hash of the file
+// contents. Identical files are stored only once, even if they are uploaded
+// into multiple galleries. The hash is recorded in the Hash field of the
+// uploaded [image.Image].
+//
+// Because the storage path is only known after the whole file was read,
+// content-addressed uploads are spooled to a temporary file before they are
+// written to storage.
+//
+// If refs is non-nil, the references to each file are counted. Files that are
+// already referenced are not uploaded again. Pass the same [ReferenceCounter]
+// to the [*GarbageCollector] using the [References] option, which deletes a
+// file when its last reference is removed. Content-addressed files are never
+// deleted by [*Uploader.Delete], and if refs is nil, they are never deleted at
+// all, because other galleries may still use them.
+func WithContentAddressing(refs ReferenceCounter) UploaderOption {
+	return func(cfg *uploaderConfig) {
+		cfg.contentAddressed = true
+		cfg.refs = refs
+	}
+}
+
+// MetadataCaptions returns an [UploaderOption] that seeds the names and
+// descriptions of new original images from the title and caption in their
+// [image.Metadata]. The title and caption are used as the name and description
+// for the provided language, unless the image already has a name or
+// description for that language.
+func MetadataCaptions(lang string) UploaderOption {
+	return func(cfg *uploaderConfig) {
+		cfg.captions = lang
+	}
+}
+
+// DiscardGPS returns an [UploaderOption] that removes the GPS location from the
+// [image.Metadata] of uploaded images, so that the location at which an image
+// was captured is not exposed by the gallery. The uploaded file itself is
+// stored unmodified and still contains the location; processed variants are
+// encoded without metadata.
+func DiscardGPS() UploaderOption {
+	return func(cfg *uploaderConfig) {
+		cfg.discardGPS = true
+	}
 }
 
 // NewUploader returns an [*Uploader] that uploads images to the provided [Storage].
-func NewUploader[StackID, ImageID ID](storage Storage) *Uploader[StackID, ImageID] {
-	return &Uploader[StackID, ImageID]{
-		storage: storage,
+func NewUploader[StackID, ImageID ID](storage Storage, opts ...UploaderOption) *Uploader[StackID, ImageID] {
+	u := &Uploader[StackID, ImageID]{storage: storage}
+	for _, opt := range opts {
+		opt(&u.cfg)
+	}
+	if u.cfg.paths == nil {
+		u.cfg.paths = DefaultPaths{}
 	}
+	return u
 }
 
 // UploadNew uploads a new image to the provided gallery and returns the newly
@@ -30,8 +118,11 @@ func NewUploader[StackID, ImageID ID](storage Storage) *Uploader[StackID, ImageI
 //
 // The filesize and dimensions of the uploaded image are determined while
 // uploading to storage, and set on the [gallery.Image] in the returned
-// [gallery.Stack]. The Filename of the returned [gallery.Image] is set to
-// the provided filename.
+// [gallery.Stack]. The image is streamed to storage; only the header of the
+// image is buffered to detect its dimensions. The Filename of the returned
+// [gallery.Image] is set to the provided filename. If the EXIF orientation of
+// the image rotates it by 90 degrees, its width and height are swapped, so that
+// the dimensions reflect how the image is displayed.
 //
 // To upload a new variant of an existing [gallery.Stack], call u.UploadVariant()
 // instead.
@@ -47,45 +138,77 @@ func (u *Uploader[StackID, ImageID]) UploadNew(
 		return gallery.Stack[StackID, ImageID]{}, fmt.Errorf("empty filename")
 	}
 
+	return u.uploadNew(ctx, g, stackID, imageID, r, image.Image{Filename: filename})
+}
+
+// uploadNew uploads a new original image to the provided gallery. The storage
+// location, filesize, dimensions, and hash of the provided image are set from
+// the upload.
+func (u *Uploader[StackID, ImageID]) uploadNew(
+	ctx context.Context,
+	g ProcessableGallery[StackID, ImageID],
+	stackID StackID,
+	imageID ImageID,
+	r io.Reader,
+	img image.Image,
+) (gallery.Stack[StackID, ImageID], error) {
 	if _, ok := g.Stack(stackID); ok {
 		return gallery.Stack[StackID, ImageID]{}, fmt.Errorf("stack id: %w", gallery.ErrDuplicateID)
 	}
 
-	var info detectFileInfo
-	r = io.TeeReader(r, &info)
-
-	galleryID := pick.AggregateID(g)
-	path := variantPath(galleryID, stackID, imageID, filename)
-
-	storage, err := u.storage.Put(ctx, path, r)
+	original, err := u.uploadOriginal(ctx, g, stackID, imageID, r, img)
 	if err != nil {
-		return gallery.Stack[StackID, ImageID]{}, fmt.Errorf("storage: %w", err)
+		return gallery.Stack[StackID, ImageID]{}, err
 	}
 
-	dims, err := info.Dimensions()
+	stack, err := g.NewStack(stackID, original)
 	if err != nil {
-		return gallery.Stack[StackID, ImageID]{}, fmt.Errorf("detect image dimensions: %w", err)
+		u.discard(ctx, original.Image)
+		return gallery.Stack[StackID, ImageID]{}, fmt.Errorf("create stack: %w", err)
 	}
 
-	img := image.Image{
-		Storage:    storage,
-		Filename:   filename,
-		Filesize:   info.size,
-		Dimensions: dims,
-	}.Normalize()
+	return stack, nil
+}
 
-	gimg := gallery.Image[ImageID]{
-		ID:       imageID,
-		Image:    img,
-		Original: true,
-	}
+// uploadOriginal uploads the file of a new original image, without adding it
+// to the gallery. The storage location, filesize, dimensions, hash, and
+// metadata of the provided image are set from the upload.
+func (u *Uploader[StackID, ImageID]) uploadOriginal(
+	ctx context.Context,
+	g pick.AggregateProvider,
+	stackID StackID,
+	imageID ImageID,
+	r io.Reader,
+	img image.Image,
+) (gallery.Image[ImageID], error) {
+	path := u.path(g, stackID, imageID, img.Filename)
 
-	stack, err := g.NewStack(stackID, gimg)
+	storage, info, err := u.upload(ctx, path, r, &u.cfg.policy)
 	if err != nil {
-		return gallery.Stack[StackID, ImageID]{}, fmt.Errorf("create stack: %w", err)
+		return gallery.Image[ImageID]{}, err
 	}
 
-	return stack, nil
+	img.Storage = storage
+	img.Filesize = info.size
+	img.Dimensions = info.dimensions
+	img.Hash = info.hash
+	img.Metadata = info.metadata
+	img = img.Normalize()
+
+	if u.cfg.captions != "" && img.Metadata != nil {
+		if img.Names[u.cfg.captions] == "" && img.Metadata.Title != "" {
+			img.Names[u.cfg.captions] = img.Metadata.Title
+		}
+		if img.Descriptions[u.cfg.captions] == "" && img.Metadata.Caption != "" {
+			img.Descriptions[u.cfg.captions] = img.Metadata.Caption
+		}
+	}
+
+	return gallery.Image[ImageID]{
+		ID:       imageID,
+		Image:    img,
+		Original: true,
+	}, nil
 }
 
 // UploadVariant writes the image in `r` to the underlying [Storage] and returns
@@ -96,13 +219,19 @@ func (u *Uploader[StackID, ImageID]) UploadNew(
 //
 // The provided StackID specifies the [gallery.Stack] the image should be added
 // to. The provided ImageID is used as the ID of the returned [gallery.Image].
-// The storage path of the uploaded image is determined by the StackID, ImageID,
-// and the ID of the provided gallery.
+// The storage path of the uploaded image is determined by the [PathStrategy]
+// of the Uploader.
 //
 // The filesize and dimensions of the uploaded image are determined while
-// uploading to storage, and set on the returned [gallery.Image]. The Filename
-// of the returned [gallery.Image] is set to the Filename of the original image
-// of the [gallery.Stack].
+// uploading to storage, and set on the returned [gallery.Image]. The image is
+// streamed to storage; only the header of the image is buffered to detect its
+// dimensions. The Filename of the returned [gallery.Image] is set to the
+// Filename of the original image of the [gallery.Stack].
+//
+// Variants are usually encoded without metadata, so the returned
+// [gallery.Image] keeps a copy of the [image.Metadata] of the original image.
+// Because variants are encoded upright, the orientation of the copy is reset
+// to 1.
 func (u *Uploader[StackID, ImageID]) UploadVariant(
 	ctx context.Context,
 	g ProcessableGallery[StackID, ImageID],
@@ -116,52 +245,464 @@ func (u *Uploader[StackID, ImageID]) UploadVariant(
 	}
 	original := stack.Original()
 
-	var info detectFileInfo
-	r = io.TeeReader(r, &info)
-
-	galleryID := pick.AggregateID(g)
-	path := variantPath(galleryID, stackID, variantID, original.Filename)
-
-	storage, err := u.storage.Put(ctx, path, r)
-	if err != nil {
-		return gallery.Image[ImageID]{}, fmt.Errorf("storage: %w", err)
-	}
+	path := u.path(g, stackID, variantID, original.Filename)
 
-	dims, err := info.Dimensions()
+	storage, info, err := u.upload(ctx, path, r, nil)
 	if err != nil {
-		return gallery.Image[ImageID]{}, fmt.Errorf("detect image dimensions: %w", err)
+		return gallery.Image[ImageID]{}, err
 	}
 
 	variantImg := original.Image.Clone()
 	variantImg.Storage = storage
 	variantImg.Filename = original.Filename
 	variantImg.Filesize = info.size
-	variantImg.Dimensions = dims
+	variantImg.Dimensions = info.dimensions
+	variantImg.Hash = info.hash
+	variantImg.Metadata = u.variantMetadata(original.Metadata)
 
 	variant, err := stack.NewVariant(variantID, variantImg)
 	if err != nil {
+		u.discard(ctx, variantImg)
 		return gallery.Image[ImageID]{}, fmt.Errorf("create variant: %w", err)
 	}
 
 	return variant, nil
 }
 
-type detectFileInfo struct {
-	size int
-	data []byte
+// ReplaceOption is an option for [*Uploader.ReplaceOriginal].
+type ReplaceOption func(*replaceConfig)
+
+type replaceConfig struct {
+	clearVariants bool
+}
+
+// ClearVariants returns a [ReplaceOption] that removes all variants except the
+// original from the [gallery.Stack] after its original was replaced. Without
+// this option, the variants that were derived from the previous original are
+// kept until the post-processor replaces them.
+func ClearVariants() ReplaceOption {
+	return func(cfg *replaceConfig) {
+		cfg.clearVariants = true
+	}
+}
+
+// ReplaceOriginal uploads a new original image for an existing [gallery.Stack],
+// and returns the updated [gallery.Stack]. The ID, tags, and position of the
+// stack, and the ID, names, and descriptions of the original image are kept.
+// The replacement is validated against the [UploadPolicy] of the Uploader,
+// just like new images.
+//
+// ReplaceOriginal raises a [VariantReplaced] event for the original image with
+// the new storage location, filename, filesize, and dimensions, which triggers
+// the post-processor. If filename is empty, the filename of the previous
+// original is kept. The replacement is never written to the storage path of
+// the previous original, and the file of the previous original is not deleted,
+// so that the gallery stays consistent if it cannot be saved. After saving the
+// gallery, delete the previous original using [*Uploader.Delete], or run a
+// [*GarbageCollector].
+func (u *Uploader[StackID, ImageID]) ReplaceOriginal(
+	ctx context.Context,
+	g ProcessableGallery[StackID, ImageID],
+	stackID StackID,
+	r io.Reader,
+	filename string,
+	opts ...ReplaceOption,
+) (gallery.Stack[StackID, ImageID], error) {
+	var cfg replaceConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	stack, ok := g.Stack(stackID)
+	if !ok {
+		return gallery.Stack[StackID, ImageID]{}, gallery.ErrStackNotFound
+	}
+
+	if !stack.ContainsOriginal() {
+		return stack, fmt.Errorf("original image: %w", gallery.ErrVariantNotFound)
+	}
+	original := stack.Original()
+
+	if filename == "" {
+		filename = original.Filename
+	}
+
+	path := u.path(g, stackID, original.ID, filename)
+	if path == original.Storage.Path {
+		path = u.path(g, stackID, original.ID, revisionFilename(filename))
+	}
+
+	storage, info, err := u.upload(ctx, path, r, &u.cfg.policy)
+	if err != nil {
+		return stack, err
+	}
+
+	replacement := original
+	replacement.Image = original.Image.Clone()
+	replacement.Storage = storage
+	replacement.Filename = filename
+	replacement.Filesize = info.size
+	replacement.Dimensions = info.dimensions
+	replacement.Hash = info.hash
+	replacement.Metadata = info.metadata
+	replacement.Source = ""
+
+	if stack, err = g.ReplaceVariant(stackID, replacement); err != nil {
+		u.discard(ctx, replacement.Image)
+		return stack, fmt.Errorf("replace original: %w", err)
+	}
+
+	if cfg.clearVariants {
+		if stack, err = g.ClearStack(stackID); err != nil {
+			return stack, fmt.Errorf("clear stack: %w", err)
+		}
+	}
+
+	return stack, nil
+}
+
+// Delete deletes the file of the provided image from the underlying [Storage].
+// If the storage does not implement [ManagedStorage], Delete returns an error
+// that satisfies errors.Is(err, ErrNotSupported). Images that are stored at a
+// content-addressed path may share their file with other images, possibly in
+// other galleries, so Delete does not delete their files. Use a
+// [*GarbageCollector] with the [References] option to delete them.
+//
+// Delete does not modify any gallery. Call it after removing the image from
+// its gallery, for example after [*Gallery.RemoveVariant].
+func (u *Uploader[StackID, ImageID]) Delete(ctx context.Context, img image.Image) error {
+	storage, ok := u.storage.(ManagedStorage)
+	if !ok {
+		return fmt.Errorf("delete %q: %w", img.Storage.Path, ErrNotSupported)
+	}
+
+	if img.Storage.Path == "" || isContentPath(img.Storage.Path) {
+		return nil
+	}
+
+	if err := deleteFile(ctx, storage, img.Storage); err != nil {
+		return fmt.Errorf("delete %q: %w", img.Storage.Path, err)
+	}
+
+	return nil
+}
+
+// DeleteStack deletes the files of all variants of the provided
+// [gallery.Stack] from the underlying [Storage]. DeleteStack tries to delete
+// every file, even if deleting one of them fails, and returns the first error.
+// Read the documentation of [*Uploader.Delete] for more information.
+func (u *Uploader[StackID, ImageID]) DeleteStack(ctx context.Context, stack gallery.Stack[StackID, ImageID]) error {
+	var firstErr error
+	for _, variant := range stack.Variants {
+		if err := u.Delete(ctx, variant.Image); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("variant %v: %w", variant.ID, err)
+		}
+	}
+	return firstErr
+}
+
+// discard deletes the file of an image that was uploaded to storage, but that
+// cannot be used because the upload failed afterwards. If the image is stored
+// at a content-addressed path, the reference that was added by the upload is
+// removed, and the file is only deleted if it has no references left.
+func (u *Uploader[StackID, ImageID]) discard(ctx context.Context, img image.Image) {
+	if !isContentPath(img.Storage.Path) {
+		u.Delete(ctx, img)
+		return
+	}
+
+	if storage, ok := u.storage.(ManagedStorage); ok && u.cfg.refs != nil {
+		releaseFile(ctx, u.cfg.refs, storage, img.Storage, 1, false)
+	}
+}
+
+// discardStack discards the files of all variants of a stack that was
+// uploaded, but that was not saved.
+func (u *Uploader[StackID, ImageID]) discardStack(ctx context.Context, stack gallery.Stack[StackID, ImageID]) {
+	for _, variant := range stack.Variants {
+		u.discard(ctx, variant.Image)
+	}
+}
+
+// path returns the storage path of an image using the [PathStrategy] of the
+// Uploader.
+func (u *Uploader[StackID, ImageID]) path(g pick.AggregateProvider, stackID StackID, imageID ImageID, filename string) string {
+	return u.cfg.paths.Path(PathInfo{
+		Gallery:  pick.AggregateID(g),
+		Stack:    stackID.String(),
+		Image:    imageID.String(),
+		Filename: filename,
+		Time:     time.Now(),
+	})
+}
+
+// maxHeaderSize is the maximum number of bytes at the start of an uploaded
+// image that are buffered to detect the type, dimensions, and metadata of the
+// image.
+const maxHeaderSize = 1 << 20
+
+type uploadInfo struct {
+	size        int
+	dimensions  image.Dimensions
+	contentType string
+	hash        string
+	metadata    *image.Metadata
+}
+
+// upload streams the image in r to the given storage path, or to its
+// content-addressed path if content addressing is enabled. Only the header of
+// the image is buffered to detect its content type, dimensions, and metadata.
+// If the image is larger than the header, the metadata is read from the whole
+// image while it is uploaded, because PNG and WebP images may store their
+// metadata after the image data. If policy is non-nil, the image is validated
+// against it before it is uploaded, and again after the upload if the metadata
+// changed its orientation.
+func (u *Uploader[StackID, ImageID]) upload(ctx context.Context, path string, r io.Reader, policy *UploadPolicy) (image.Storage, uploadInfo, error) {
+	header := make([]byte, maxHeaderSize)
+	n, err := io.ReadFull(r, header)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return image.Storage{}, uploadInfo{}, fmt.Errorf("read image: %w", err)
+	}
+	header = header[:n]
+	complete := n < maxHeaderSize
+
+	info := uploadInfo{contentType: http.DetectContentType(header)}
+
+	cfg, _, err := stdimage.DecodeConfig(bytes.NewReader(header))
+	if err != nil {
+		if errors.Is(err, stdimage.ErrFormat) {
+			return image.Storage{}, uploadInfo{}, fmt.Errorf("%w: %s", ErrUnsupportedType, info.contentType)
+		}
+		return image.Storage{}, uploadInfo{}, fmt.Errorf("detect image dimensions: decode image config: %w", err)
+	}
+	info.dimensions = image.Dimensions{cfg.Width, cfg.Height}
+
+	if metadata, err := image.ParseMetadata(header); err == nil {
+		info.setMetadata(metadata, cfg, u.cfg.discardGPS)
+	}
+
+	body := io.MultiReader(bytes.NewReader(header), r)
+
+	var limit *limitReader
+	if policy != nil {
+		size := int64(-1)
+		if complete {
+			size = int64(n)
+		} else if l, ok := r.(interface{ Len() int }); ok {
+			size = int64(n + l.Len())
+		}
+
+		if err := policy.Validate(info.contentType, info.dimensions, size); err != nil {
+			return image.Storage{}, uploadInfo{}, err
+		}
+
+		if policy.MaxFileSize > 0 {
+			limit = &limitReader{r: body, max: policy.MaxFileSize}
+			body = limit
+		}
+	}
+
+	var scan *metadataScan
+	if !complete {
+		scan = scanMetadata()
+		body = io.TeeReader(body, scan)
+	}
+
+	counter := &countReader{r: body}
+
+	var storage image.Storage
+	if u.cfg.contentAddressed {
+		storage, info.hash, err = u.putContentAddressed(ctx, counter)
+	} else {
+		storage, err = u.storage.Put(ctx, path, counter)
+	}
+
+	var metadata *image.Metadata
+	if scan != nil {
+		if m, err := scan.result(); err == nil {
+			metadata = &m
+		}
+	}
+
+	if limit != nil && limit.exceeded {
+		return image.Storage{}, uploadInfo{}, policy.fileTooLarge()
+	}
+	if err != nil {
+		return image.Storage{}, uploadInfo{}, fmt.Errorf("storage: %w", err)
+	}
+	info.size = counter.n
+
+	if metadata != nil {
+		dimensions := info.dimensions
+		info.setMetadata(*metadata, cfg, u.cfg.discardGPS)
+
+		if policy != nil && info.dimensions != dimensions {
+			if err := policy.Validate(info.contentType, info.dimensions, int64(info.size)); err != nil {
+				u.discard(ctx, image.Image{Storage: storage})
+				return image.Storage{}, uploadInfo{}, err
+			}
+		}
+	}
+
+	return storage, info, nil
+}
+
+// setMetadata sets the metadata of an uploaded image, and its dimensions, which
+// reflect how the image is displayed, from the dimensions of the encoded image
+// and the orientation in the metadata.
+func (info *uploadInfo) setMetadata(metadata image.Metadata, cfg stdimage.Config, discardGPS bool) {
+	if discardGPS {
+		metadata.GPS = nil
+	}
+
+	info.metadata = nil
+	if !metadata.IsZero() {
+		info.metadata = &metadata
+	}
+
+	info.dimensions = image.Dimensions{cfg.Width, cfg.Height}
+	if swapsDimensions(metadata.Orientation) {
+		info.dimensions = image.Dimensions{cfg.Height, cfg.Width}
+	}
 }
 
-func (f *detectFileInfo) Write(p []byte) (int, error) {
-	f.data = append(f.data, p...)
-	l := len(p)
-	f.size += l
-	return l, nil
+// variantMetadata returns a copy of the metadata of an original image for one
+// of its variants.
+func (u *Uploader[StackID, ImageID]) variantMetadata(original *image.Metadata) *image.Metadata {
+	if original == nil {
+		return nil
+	}
+
+	metadata := original.Clone()
+	if metadata.Orientation != 0 {
+		metadata.Orientation = 1
+	}
+	if u.cfg.discardGPS {
+		metadata.GPS = nil
+	}
+
+	if metadata.IsZero() {
+		return nil
+	}
+	return &metadata
 }
 
-func (f *detectFileInfo) Dimensions() (image.Dimensions, error) {
-	img, _, err := stdimage.Decode(bytes.NewReader(f.data))
+// metadataScan reads the metadata of an image that is written to it, while the
+// image is streamed to storage.
+type metadataScan struct {
+	w        *io.PipeWriter
+	done     chan struct{}
+	metadata image.Metadata
+	err      error
+}
+
+func scanMetadata() *metadataScan {
+	r, w := io.Pipe()
+	s := &metadataScan{w: w, done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		s.metadata, s.err = image.ReadMetadata(r)
+
+		// Drain the rest of the image, so that writes never block.
+		io.Copy(io.Discard, r)
+	}()
+	return s
+}
+
+func (s *metadataScan) Write(p []byte) (int, error) {
+	return s.w.Write(p)
+}
+
+// result waits until the scan has read everything that was written to it, and
+// returns the metadata.
+func (s *metadataScan) result() (image.Metadata, error) {
+	s.w.Close()
+	<-s.done
+	return s.metadata, s.err
+}
+
+// putContentAddressed spools the contents of r to a temporary file to compute
+// its hash, and then writes the file to its content-addressed path. If
+// references are counted, a reference is added while the file is locked, so
+// that the file cannot be deleted in between, and the file is not written
+// again if it is already referenced.
+func (u *Uploader[StackID, ImageID]) putContentAddressed(ctx context.Context, r io.Reader) (image.Storage, string, error) {
+	tmp, err := os.CreateTemp("", "esgallery-*")
 	if err != nil {
-		return image.Dimensions{}, fmt.Errorf("decode image: %w", err)
+		return image.Storage{}, "", fmt.Errorf("create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(tmp, io.TeeReader(r, h)); err != nil {
+		return image.Storage{}, "", err
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+	path := contentPath(hash)
+
+	if u.cfg.refs == nil {
+		storage, err := putFile(ctx, u.storage, path, tmp)
+		return storage, hash, err
+	}
+
+	unlock, err := u.cfg.refs.Lock(ctx, path)
+	if err != nil {
+		return image.Storage{}, "", fmt.Errorf("lock references of %q: %w", path, err)
+	}
+	defer unlock()
+
+	storage, refs, err := u.cfg.refs.Lookup(ctx, path)
+	if err != nil {
+		return image.Storage{}, "", fmt.Errorf("lookup references of %q: %w", path, err)
+	}
+
+	if refs == 0 {
+		if storage, err = putFile(ctx, u.storage, path, tmp); err != nil {
+			return image.Storage{}, "", err
+		}
+	}
+
+	if _, err := u.cfg.refs.Increment(ctx, storage); err != nil {
+		return image.Storage{}, "", fmt.Errorf("increment references of %q: %w", path, err)
+	}
+
+	return storage, hash, nil
+}
+
+// putFile writes the contents of a spooled file to storage.
+func putFile(ctx context.Context, storage Storage, path string, f *os.File) (image.Storage, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return image.Storage{}, fmt.Errorf("rewind temporary file: %w", err)
+	}
+	return storage.Put(ctx, path, f)
+}
+
+type countReader struct {
+	r io.Reader
+	n int
+}
+
+func (r *countReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += n
+	return n, err
+}
+
+// limitReader fails with [ErrFileTooLarge] after more than max bytes were read.
+type limitReader struct {
+	r        io.Reader
+	max      int64
+	n        int64
+	exceeded bool
+}
+
+func (r *limitReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if r.n > r.max {
+		r.exceeded = true
+		return 0, ErrFileTooLarge
 	}
-	return image.Dimensions{img.Bounds().Dx(), img.Bounds().Dy()}, nil
+	return n, err
 }
@@ -1,14 +1,27 @@
 package esgallery_test
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	stdimage "image"
+	"image/jpeg"
+	"image/png"
 	"io"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/uuid"
+	"github.com/modernice/media-entity/gallery"
 	"github.com/modernice/media-entity/goes/esgallery"
+	"github.com/modernice/media-entity/image"
 	"github.com/modernice/media-entity/internal/galleryx"
 )
 
@@ -64,3 +77,539 @@ func TestUploader_Upload(t *testing.T) {
 		t.Fatalf("uploaded file has wrong filesize; got %d; want %d", uploaded.Filesize, wantFilesize)
 	}
 }
+
+func TestUploader_DeleteStack(t *testing.T) {
+	var storage esgallery.MemoryStorage
+	up := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
+	g := NewTestGallery(uuid.New())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stack, err := up.UploadNew(ctx, g, uuid.New(), uuid.New(), newExample(), "example.jpg")
+	if err != nil {
+		t.Fatalf("upload original: %v", err)
+	}
+
+	variant, err := up.UploadVariant(ctx, g, stack.ID, uuid.New(), newExample())
+	if err != nil {
+		t.Fatalf("upload variant: %v", err)
+	}
+
+	if _, err := g.AddVariant(stack.ID, variant); err != nil {
+		t.Fatalf("add variant: %v", err)
+	}
+
+	if len(storage.Files()) != 2 {
+		t.Fatalf("expected 2 files to be in storage; got %d", len(storage.Files()))
+	}
+
+	removed, err := g.RemoveStack(stack.ID)
+	if err != nil {
+		t.Fatalf("remove stack: %v", err)
+	}
+
+	if err := up.DeleteStack(ctx, removed); err != nil {
+		t.Fatalf("delete stack: %v", err)
+	}
+
+	if len(storage.Files()) != 0 {
+		t.Fatalf("expected storage to be empty; got %d files", len(storage.Files()))
+	}
+}
+
+func TestUploader_Delete_notSupported(t *testing.T) {
+	up := esgallery.NewUploader[uuid.UUID, uuid.UUID](putGetStorage{&esgallery.MemoryStorage{}})
+
+	err := up.Delete(context.Background(), galleryx.NewImage(uuid.New()).Image)
+	if !errors.Is(err, esgallery.ErrNotSupported) {
+		t.Fatalf("Delete() should fail with %q; got %v", esgallery.ErrNotSupported, err)
+	}
+}
+
+func TestUploader_UploadNew_discardInvalidImage(t *testing.T) {
+	var storage esgallery.MemoryStorage
+	up := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
+	g := NewTestGallery(uuid.New())
+
+	if _, err := up.UploadNew(context.Background(), g, uuid.New(), uuid.New(), strings.NewReader("not an image"), "example.jpg"); err == nil {
+		t.Fatalf("upload of an invalid image should fail")
+	}
+
+	if len(storage.Files()) != 0 {
+		t.Fatalf("invalid image should be removed from storage; storage has %d files", len(storage.Files()))
+	}
+}
+
+func TestUploader_UploadNew_metadata(t *testing.T) {
+	xmp := []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/">
+  <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+    <rdf:Description xmlns:dc="http://purl.org/dc/elements/1.1/">
+      <dc:title><rdf:Alt><rdf:li xml:lang="x-default">Beach</rdf:li></rdf:Alt></dc:title>
+      <dc:description><rdf:Alt><rdf:li xml:lang="x-default">A sunny beach</rdf:li></rdf:Alt></dc:description>
+      <dc:subject><rdf:Bag><rdf:li>summer</rdf:li><rdf:li>sea</rdf:li></rdf:Bag></dc:subject>
+    </rdf:Description>
+  </rdf:RDF>
+</x:xmpmeta>`)
+
+	// Insert an APP1 segment with the XMP packet after the SOI marker.
+	payload := append([]byte("http://ns.adobe.com/xap/1.0/\x00"), xmp...)
+	segment := append([]byte{0xFF, 0xE1, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}, payload...)
+	file := append(append(append([]byte{}, example[:2]...), segment...), example[2:]...)
+
+	var storage esgallery.MemoryStorage
+	up := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage, esgallery.MetadataCaptions("en"))
+	g := NewTestGallery(uuid.New())
+
+	stack, err := up.UploadNew(context.Background(), g, uuid.New(), uuid.New(), bytes.NewReader(file), "beach.jpg")
+	if err != nil {
+		t.Fatalf("UploadNew() failed: %v", err)
+	}
+	original := stack.Original()
+
+	want := &image.Metadata{Title: "Beach", Caption: "A sunny beach", Keywords: []string{"summer", "sea"}}
+	if diff := cmp.Diff(want, original.Metadata); diff != "" {
+		t.Fatalf("metadata should be parsed from the uploaded image (-want +got):\n%s", diff)
+	}
+
+	if original.Names["en"] != "Beach" || original.Descriptions["en"] != "A sunny beach" {
+		t.Fatalf("names and descriptions should be seeded from the metadata; got %v and %v", original.Names, original.Descriptions)
+	}
+
+	stack, err = up.UploadNew(context.Background(), g, uuid.New(), uuid.New(), newExample(), "example.jpg")
+	if err != nil {
+		t.Fatalf("UploadNew() failed: %v", err)
+	}
+
+	if stack.Original().Metadata != nil {
+		t.Fatalf("image without metadata should have no metadata; got %+v", stack.Original().Metadata)
+	}
+}
+
+func TestUploader_UploadNew_metadataAfterHeader(t *testing.T) {
+	// The EXIF data is stored after the header that is buffered by the Uploader.
+	file := newPNGWithEXIF(t, 64, 32, newEXIF(6), 2<<20)
+
+	tests := []struct {
+		name string
+		opts []esgallery.UploaderOption
+		want *image.Metadata
+	}{
+		{
+			name: "default",
+			want: &image.Metadata{Orientation: 6, GPS: &image.GPS{Latitude: 48.5, Longitude: 11.25}},
+		},
+		{
+			name: "DiscardGPS",
+			opts: []esgallery.UploaderOption{esgallery.DiscardGPS()},
+			want: &image.Metadata{Orientation: 6},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var storage esgallery.MemoryStorage
+			up := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage, tt.opts...)
+			g := NewTestGallery(uuid.New())
+
+			stack, err := up.UploadNew(context.Background(), g, uuid.New(), uuid.New(), bytes.NewReader(file), "portrait.png")
+			if err != nil {
+				t.Fatalf("UploadNew() failed: %v", err)
+			}
+			original := stack.Original()
+
+			if diff := cmp.Diff(tt.want, original.Metadata); diff != "" {
+				t.Fatalf("metadata should be read from the whole image (-want +got):\n%s", diff)
+			}
+
+			if dim := original.Dimensions; dim.Width() != 32 || dim.Height() != 64 {
+				t.Fatalf("dimensions of the original should reflect its orientation; got %v", dim)
+			}
+		})
+	}
+}
+
+func TestUploader_UploadNew_metadataAfterHeader_policy(t *testing.T) {
+	// The orientation swaps the dimensions to 32x64, which is too high.
+	file := newPNGWithEXIF(t, 64, 32, newEXIF(6), 2<<20)
+
+	var storage esgallery.MemoryStorage
+	up := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage, esgallery.WithUploadPolicy(esgallery.UploadPolicy{MaxHeight: 32}))
+	g := NewTestGallery(uuid.New())
+
+	if _, err := up.UploadNew(context.Background(), g, uuid.New(), uuid.New(), bytes.NewReader(file), "portrait.png"); !errors.Is(err, esgallery.ErrImageTooLarge) {
+		t.Fatalf("UploadNew() should fail with %q; got %v", esgallery.ErrImageTooLarge, err)
+	}
+
+	if len(storage.Files()) != 0 {
+		t.Fatalf("rejected image should be removed from storage; storage has %d files", len(storage.Files()))
+	}
+}
+
+// putGetStorage hides the [esgallery.ManagedStorage] methods of a storage.
+type putGetStorage struct {
+	esgallery.Storage
+}
+
+func TestUploader_UploadNew_WithUploadPolicy(t *testing.T) {
+	large := newNoiseJPEG(t, 1000, 1000)
+	dims := exampleImg.Bounds().Size()
+
+	tests := []struct {
+		name   string
+		policy esgallery.UploadPolicy
+		r      io.Reader
+		want   error
+	}{
+		{
+			name:   "valid",
+			policy: esgallery.UploadPolicy{MaxFileSize: int64(len(example)), ContentTypes: []string{"image/*"}},
+			r:      newExample(),
+		},
+		{
+			name:   "MaxFileSize",
+			policy: esgallery.UploadPolicy{MaxFileSize: int64(len(example) - 1)},
+			r:      newExample(),
+			want:   esgallery.ErrFileTooLarge,
+		},
+		{
+			name:   "MaxFileSize (streamed)",
+			policy: esgallery.UploadPolicy{MaxFileSize: int64(len(large) - 1)},
+			r:      io.MultiReader(bytes.NewReader(large)),
+			want:   esgallery.ErrFileTooLarge,
+		},
+		{
+			name:   "ContentTypes",
+			policy: esgallery.UploadPolicy{ContentTypes: []string{"image/png", "image/webp"}},
+			r:      newExample(),
+			want:   esgallery.ErrUnsupportedType,
+		},
+		{
+			name: "not an image",
+			r:    strings.NewReader("not an image"),
+			want: esgallery.ErrUnsupportedType,
+		},
+		{
+			name:   "MinWidth",
+			policy: esgallery.UploadPolicy{MinWidth: dims.X + 1},
+			r:      newExample(),
+			want:   esgallery.ErrImageTooSmall,
+		},
+		{
+			name:   "MaxHeight",
+			policy: esgallery.UploadPolicy{MaxHeight: dims.Y - 1},
+			r:      newExample(),
+			want:   esgallery.ErrImageTooLarge,
+		},
+		{
+			name:   "MaxMegapixels",
+			policy: esgallery.UploadPolicy{MaxMegapixels: float64(dims.X*dims.Y-1) / 1e6},
+			r:      newExample(),
+			want:   esgallery.ErrImageTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var storage esgallery.MemoryStorage
+			up := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage, esgallery.WithUploadPolicy(tt.policy))
+			g := NewTestGallery(uuid.New())
+
+			_, err := up.UploadNew(context.Background(), g, uuid.New(), uuid.New(), tt.r, "example.jpg")
+
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("upload failed: %v", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("upload should fail with %q; got %v", tt.want, err)
+			}
+
+			if len(storage.Files()) != 0 {
+				t.Fatalf("rejected image should not be written to storage; storage has %d files", len(storage.Files()))
+			}
+		})
+	}
+}
+
+func BenchmarkUploader_UploadNew(b *testing.B) {
+	contents := newNoiseJPEG(b, 3000, 3000)
+
+	up := esgallery.NewUploader[uuid.UUID, uuid.UUID](discardStorage{})
+
+	b.SetBytes(int64(len(contents)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		g := NewTestGallery(uuid.New())
+		if _, err := up.UploadNew(context.Background(), g, uuid.New(), uuid.New(), bytes.NewReader(contents), "example.jpg"); err != nil {
+			b.Fatalf("upload failed: %v", err)
+		}
+	}
+}
+
+// newNoiseJPEG returns a noisy JPEG image that cannot be compressed well.
+func newNoiseJPEG(t testing.TB, width, height int) []byte {
+	img := stdimage.NewRGBA(stdimage.Rect(0, 0, width, height))
+	if _, err := rand.Read(img.Pix); err != nil {
+		t.Fatalf("generate image: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatalf("encode image: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+// newPNGWithEXIF returns a PNG image with the provided EXIF data, which is
+// stored after an ancillary chunk of the provided size.
+func newPNGWithEXIF(t testing.TB, width, height int, exif []byte, padding int) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, stdimage.NewGray(stdimage.Rect(0, 0, width, height))); err != nil {
+		t.Fatalf("encode image: %v", err)
+	}
+
+	// Insert the chunks before the IEND chunk.
+	b := buf.Bytes()
+	iend := b[len(b)-12:]
+	b = appendPNGChunk(b[:len(b)-12:len(b)-12], "paDd", make([]byte, padding))
+	b = appendPNGChunk(b, "eXIf", exif)
+
+	return append(b, iend...)
+}
+
+func appendPNGChunk(b []byte, typ string, data []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+	b = append(b, typ...)
+	b = append(b, data...)
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(append([]byte(typ), data...)))
+}
+
+// newEXIF returns big-endian EXIF data with the provided orientation, and the
+// GPS location 48.5, 11.25.
+func newEXIF(orientation uint16) []byte {
+	b := []byte("MM\x00*\x00\x00\x00\x08")
+
+	// IFD0 with the orientation and a pointer to the GPS IFD at offset 38.
+	b = binary.BigEndian.AppendUint16(b, 2)
+	b = appendTIFFEntry(b, 0x0112, 3, 1, uint32(orientation)<<16)
+	b = appendTIFFEntry(b, 0x8825, 4, 1, 38)
+	b = binary.BigEndian.AppendUint32(b, 0)
+
+	// GPS IFD with the latitude and longitude at offsets 68 and 92.
+	b = binary.BigEndian.AppendUint16(b, 2)
+	b = appendTIFFEntry(b, 0x0002, 5, 3, 68)
+	b = appendTIFFEntry(b, 0x0004, 5, 3, 92)
+	b = binary.BigEndian.AppendUint32(b, 0)
+
+	for _, v := range []uint32{48, 1, 30, 1, 0, 1, 11, 1, 15, 1, 0, 1} {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+
+	return b
+}
+
+func appendTIFFEntry(b []byte, tag, typ uint16, count, value uint32) []byte {
+	b = binary.BigEndian.AppendUint16(b, tag)
+	b = binary.BigEndian.AppendUint16(b, typ)
+	b = binary.BigEndian.AppendUint32(b, count)
+	return binary.BigEndian.AppendUint32(b, value)
+}
+
+// discardStorage is a [esgallery.Storage] that discards all files.
+type discardStorage struct{}
+
+func (discardStorage) Put(_ context.Context, path string, r io.Reader) (image.Storage, error) {
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return image.Storage{}, err
+	}
+	return image.Storage{Provider: "discard", Path: path}, nil
+}
+
+func (discardStorage) Get(_ context.Context, path string) (io.Reader, error) {
+	return nil, fmt.Errorf("%w: %q", esgallery.ErrNotFound, path)
+}
+
+func TestUploader_WithContentAddressing(t *testing.T) {
+	var storage esgallery.MemoryStorage
+	var refs esgallery.MemoryReferenceCounter
+	up := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage, esgallery.WithContentAddressing(&refs))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sum := sha256.Sum256(example)
+	wantHash := hex.EncodeToString(sum[:])
+	wantPath := fmt.Sprintf("sha256/%s/%s", wantHash[:2], wantHash)
+
+	var stacks []gallery.Stack[uuid.UUID, uuid.UUID]
+	for i := 0; i < 2; i++ {
+		stack, err := up.UploadNew(ctx, NewTestGallery(uuid.New()), uuid.New(), uuid.New(), newExample(), "example.jpg")
+		if err != nil {
+			t.Fatalf("upload failed: %v", err)
+		}
+		stacks = append(stacks, stack)
+
+		original := stack.Original()
+
+		if original.Hash != wantHash {
+			t.Fatalf("image should have hash %q; has %q", wantHash, original.Hash)
+		}
+
+		if original.Storage.Path != wantPath {
+			t.Fatalf("image should be uploaded to path %q; got %q", wantPath, original.Storage.Path)
+		}
+	}
+
+	if len(storage.Files()) != 1 {
+		t.Fatalf("identical images should be stored once; storage has %d files", len(storage.Files()))
+	}
+
+	if _, n, _ := refs.Lookup(ctx, wantPath); n != 2 {
+		t.Fatalf("file should have 2 references; has %d", n)
+	}
+
+	for _, stack := range stacks {
+		if err := up.DeleteStack(ctx, stack); err != nil {
+			t.Fatalf("delete stack: %v", err)
+		}
+	}
+
+	// Only the garbage collector removes references.
+	if len(storage.Files()) != 1 {
+		t.Fatalf("content-addressed file should not be deleted by DeleteStack()")
+	}
+
+	if _, n, _ := refs.Lookup(ctx, wantPath); n != 2 {
+		t.Fatalf("DeleteStack() should not remove references; file has %d references", n)
+	}
+}
+
+func TestUploader_WithContentAddressing_discard(t *testing.T) {
+	var storage esgallery.MemoryStorage
+	var refs esgallery.MemoryReferenceCounter
+	up := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage, esgallery.WithContentAddressing(&refs))
+
+	ctx := context.Background()
+
+	g := NewTestGallery(uuid.New())
+	stack, err := up.UploadNew(ctx, g, uuid.New(), uuid.New(), newExample(), "example.jpg")
+	if err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+
+	// Uploading the same file as a new stack with an existing id fails, and
+	// the reference of the failed upload is removed again.
+	if _, err := up.UploadNew(ctx, g, stack.ID, uuid.New(), newExample(), "example.jpg"); err == nil {
+		t.Fatalf("upload of a duplicate stack should fail")
+	}
+
+	if _, n, _ := refs.Lookup(ctx, stack.Original().Storage.Path); n != 1 {
+		t.Fatalf("file should have 1 reference; has %d", n)
+	}
+
+	if len(storage.Files()) != 1 {
+		t.Fatalf("file that is still referenced should not be deleted")
+	}
+}
+
+func TestUploader_ReplaceOriginal(t *testing.T) {
+	ctx := context.Background()
+
+	var storage esgallery.MemoryStorage
+	up := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
+	g := NewTestGallery(uuid.New())
+
+	stack, _ := uploadStackWithVariant(t, up, g)
+	if _, err := g.Tag(stack.ID, "foo"); err != nil {
+		t.Fatalf("tag stack: %v", err)
+	}
+	g.MarkAsProcessed(stack.ID)
+
+	previous := stack.Original()
+	replacement := newNoiseJPEG(t, 20, 10)
+
+	replaced, err := up.ReplaceOriginal(ctx, g, stack.ID, bytes.NewReader(replacement), "new.jpg")
+	if err != nil {
+		t.Fatalf("ReplaceOriginal() failed: %v", err)
+	}
+
+	original := replaced.Original()
+
+	if replaced.ID != stack.ID || original.ID != previous.ID {
+		t.Fatalf("stack and original ids should be kept; got stack %s with original %s", replaced.ID, original.ID)
+	}
+
+	if original.Filename != "new.jpg" || original.Filesize != len(replacement) || original.Dimensions != (image.Dimensions{20, 10}) {
+		t.Fatalf("original should be updated from the replacement; got %+v", original.Image)
+	}
+
+	if original.Storage.Path == previous.Storage.Path {
+		t.Fatalf("replacement should not be written to the path of the previous original")
+	}
+
+	if !replaced.Tags.Contains("foo") || len(replaced.Variants) != 2 {
+		t.Fatalf("tags and variants should be kept; got %+v", replaced)
+	}
+
+	if len(g.ProcessedStacks()) != 0 {
+		t.Fatalf("stack should be processed again after its original was replaced")
+	}
+
+	if _, err := storage.Get(ctx, previous.Storage.Path); err != nil {
+		t.Fatalf("previous original should not be deleted: %v", err)
+	}
+
+	cleared, err := up.ReplaceOriginal(ctx, g, stack.ID, newExample(), "", esgallery.ClearVariants())
+	if err != nil {
+		t.Fatalf("ReplaceOriginal() failed: %v", err)
+	}
+
+	if cleared.Original().Filename != "new.jpg" {
+		t.Fatalf("filename should be kept if empty; got %q", cleared.Original().Filename)
+	}
+
+	if cleared.Original().Storage.Path == original.Storage.Path {
+		t.Fatalf("replacement with the same filename should be written to a new path")
+	}
+
+	if len(cleared.Variants) != 1 {
+		t.Fatalf("variants should be cleared; stack has %d variants", len(cleared.Variants))
+	}
+}
+
+func TestUploader_ReplaceOriginal_WithUploadPolicy(t *testing.T) {
+	var storage esgallery.MemoryStorage
+	up := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage, esgallery.WithUploadPolicy(esgallery.UploadPolicy{
+		MaxFileSize: int64(len(example)),
+	}))
+	g := NewTestGallery(uuid.New())
+
+	stack, err := up.UploadNew(context.Background(), g, uuid.New(), uuid.New(), newExample(), "example.jpg")
+	if err != nil {
+		t.Fatalf("upload original: %v", err)
+	}
+
+	large := newNoiseJPEG(t, 1000, 1000)
+	if _, err := up.ReplaceOriginal(context.Background(), g, stack.ID, bytes.NewReader(large), "large.jpg"); !errors.Is(err, esgallery.ErrFileTooLarge) {
+		t.Fatalf("ReplaceOriginal() should fail with %q; got %v", esgallery.ErrFileTooLarge, err)
+	}
+
+	if s, _ := g.Stack(stack.ID); s.Original().Filename != "example.jpg" {
+		t.Fatalf("original should not be replaced; got %q", s.Original().Filename)
+	}
+
+	if len(storage.Files()) != 1 {
+		t.Fatalf("rejected replacement should not be written to storage; storage has %d files", len(storage.Files()))
+	}
+
+	if _, err := up.ReplaceOriginal(context.Background(), g, uuid.New(), newExample(), "foo.jpg"); !errors.Is(err, gallery.ErrStackNotFound) {
+		t.Fatalf("ReplaceOriginal() should fail with %q; got %v", gallery.ErrStackNotFound, err)
+	}
+}
@@ -0,0 +1,356 @@
+package esgallery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/modernice/goes/aggregate"
+	"github.com/modernice/goes/helper/pick"
+	"github.com/modernice/media-entity/gallery"
+	"github.com/modernice/media-entity/image"
+)
+
+// DefaultUploadField is the default name of the multipart form field that
+// contains the uploaded file.
+const DefaultUploadField = "file"
+
+// ErrGalleryNotFound is returned by an [*UploadHandler] if an image is uploaded
+// to a gallery that does not exist.
+var ErrGalleryNotFound = errors.New("gallery not found")
+
+var errMissingFile = errors.New("missing file")
+
+// UploadHandler is an [http.Handler] that uploads new images to galleries. It
+// accepts POST requests with either a multipart/form-data body, in which case
+// the file is read from the [DefaultUploadField] form field, or with the raw
+// image as the request body. The filename of a raw upload is read from the
+// Content-Disposition header, or from the "filename" query parameter.
+//
+// The gallery is identified by the path of the request URL, which must be the
+// id of the gallery. Use [http.StripPrefix] to mount the handler at a path
+// prefix, or [GalleryIDFrom] to extract the id from the request in another way:
+//
+//	galleries := repository.Typed(repo, NewGallery)
+//	h := esgallery.NewUploadHandler(uploader, galleries.Fetch, galleries.Save)
+//	http.Handle("/galleries/", http.StripPrefix("/galleries/", h))
+//
+// The image is streamed to storage using [*Uploader.UploadNew]. After the
+// gallery is saved, the created [gallery.Stack] is returned as JSON with status
+// 201 Created. If the gallery cannot be saved, the uploaded image is deleted
+// from storage. Uploads to galleries that have no events yet are rejected with
+// 404 Not Found, unless the [CreateGalleries] option is used.
+type UploadHandler[
+	Gallery ProcessableGallery[StackID, ImageID],
+	StackID, ImageID ID,
+] struct {
+	uploader     *Uploader[StackID, ImageID]
+	fetchGallery func(context.Context, uuid.UUID) (Gallery, error)
+	saveGallery  func(context.Context, Gallery) error
+	cfg          uploadHandlerConfig[StackID, ImageID]
+}
+
+// UploadHandlerOption is an option for [NewUploadHandler].
+type UploadHandlerOption[StackID, ImageID ID] func(*uploadHandlerConfig[StackID, ImageID])
+
+type uploadHandlerConfig[StackID, ImageID ID] struct {
+	newStackID func() StackID
+	newImageID func() ImageID
+	galleryID  func(*http.Request) (uuid.UUID, error)
+	field      string
+	urls       image.URLResolver
+	create     bool
+}
+
+// GenerateIDs returns an [UploadHandlerOption] that generates the ids of
+// uploaded stacks and images using the provided functions. If StackID or
+// ImageID is uuid.UUID, ids are generated using uuid.New by default. For other
+// id types, GenerateIDs must be provided.
+func GenerateIDs[StackID, ImageID ID](newStackID func() StackID, newImageID func() ImageID) UploadHandlerOption[StackID, ImageID] {
+	return func(cfg *uploadHandlerConfig[StackID, ImageID]) {
+		cfg.newStackID = newStackID
+		cfg.newImageID = newImageID
+	}
+}
+
+// GalleryIDFrom returns an [UploadHandlerOption] that extracts the id of the
+// gallery from a request using the provided function. If the function returns
+// an error, the request is rejected with 404 Not Found.
+func GalleryIDFrom[StackID, ImageID ID](fn func(*http.Request) (uuid.UUID, error)) UploadHandlerOption[StackID, ImageID] {
+	return func(cfg *uploadHandlerConfig[StackID, ImageID]) {
+		cfg.galleryID = fn
+	}
+}
+
+// CreateGalleries returns an [UploadHandlerOption] that allows uploads to
+// galleries that do not exist yet. By default, uploads are rejected with 404
+// Not Found if the fetched gallery has no events, because fetching a gallery
+// with an unknown id returns a new, empty gallery.
+func CreateGalleries[StackID, ImageID ID]() UploadHandlerOption[StackID, ImageID] {
+	return func(cfg *uploadHandlerConfig[StackID, ImageID]) {
+		cfg.create = true
+	}
+}
+
+// UploadField returns an [UploadHandlerOption] that reads multipart uploads
+// from the form field with the given name instead of [DefaultUploadField].
+func UploadField[StackID, ImageID ID](name string) UploadHandlerOption[StackID, ImageID] {
+	return func(cfg *uploadHandlerConfig[StackID, ImageID]) {
+		cfg.field = name
+	}
+}
+
+// ResolveUploadURLs returns an [UploadHandlerOption] that resolves the URLs of
+// the returned [gallery.Stack] using the provided [image.URLResolver].
+func ResolveUploadURLs[StackID, ImageID ID](r image.URLResolver) UploadHandlerOption[StackID, ImageID] {
+	return func(cfg *uploadHandlerConfig[StackID, ImageID]) {
+		cfg.urls = r
+	}
+}
+
+// NewUploadHandler returns an [*UploadHandler] that uploads images using the
+// provided [*Uploader]. Galleries are fetched and saved using the provided
+// functions, for example the Fetch and Save methods of a
+// [repository.TypedRepository]. Read the documentation of [UploadHandler] for
+// more information.
+func NewUploadHandler[
+	Gallery ProcessableGallery[StackID, ImageID],
+	StackID, ImageID ID,
+](
+	uploader *Uploader[StackID, ImageID],
+	fetchGallery func(context.Context, uuid.UUID) (Gallery, error),
+	saveGallery func(context.Context, Gallery) error,
+	opts ...UploadHandlerOption[StackID, ImageID],
+) *UploadHandler[Gallery, StackID, ImageID] {
+	h := &UploadHandler[Gallery, StackID, ImageID]{
+		uploader:     uploader,
+		fetchGallery: fetchGallery,
+		saveGallery:  saveGallery,
+		cfg: uploadHandlerConfig[StackID, ImageID]{
+			newStackID: newUUID[StackID](),
+			newImageID: newUUID[ImageID](),
+			galleryID:  galleryIDFromPath,
+			field:      DefaultUploadField,
+		},
+	}
+	for _, opt := range opts {
+		opt(&h.cfg)
+	}
+	return h
+}
+
+// ServeHTTP implements [http.Handler].
+func (h *UploadHandler[Gallery, StackID, ImageID]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	stack, err := h.upload(r)
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+
+	h.writeStack(w, stack)
+}
+
+// writeStack writes the created [gallery.Stack] of an upload as JSON.
+func (h *UploadHandler[Gallery, StackID, ImageID]) writeStack(w http.ResponseWriter, stack gallery.Stack[StackID, ImageID]) {
+	if h.cfg.urls != nil {
+		var err error
+		if stack, err = stack.ResolveURLs(h.cfg.urls); err != nil {
+			writeUploadError(w, fmt.Errorf("resolve urls: %w", err))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(stack)
+}
+
+func (h *UploadHandler[Gallery, StackID, ImageID]) upload(r *http.Request) (gallery.Stack[StackID, ImageID], error) {
+	var zero gallery.Stack[StackID, ImageID]
+
+	galleryID, err := h.cfg.galleryID(r)
+	if err != nil {
+		return zero, uploadError{http.StatusNotFound, fmt.Errorf("gallery id: %w", err)}
+	}
+
+	file, filename, err := uploadedFile(r, h.cfg.field)
+	if err != nil {
+		return zero, uploadError{http.StatusBadRequest, err}
+	}
+
+	return h.uploadFile(r.Context(), galleryID, file, filename)
+}
+
+// uploadFile uploads a new image to the given gallery and saves the gallery.
+// If the gallery cannot be saved, the uploaded image is deleted from storage.
+func (h *UploadHandler[Gallery, StackID, ImageID]) uploadFile(ctx context.Context, galleryID uuid.UUID, file io.Reader, filename string) (gallery.Stack[StackID, ImageID], error) {
+	var zero gallery.Stack[StackID, ImageID]
+
+	if h.cfg.newStackID == nil || h.cfg.newImageID == nil {
+		return zero, fmt.Errorf("no id generator configured; use the GenerateIDs option")
+	}
+
+	g, err := h.gallery(ctx, galleryID)
+	if err != nil {
+		return zero, err
+	}
+
+	stack, err := h.uploader.UploadNew(ctx, g, h.cfg.newStackID(), h.cfg.newImageID(), file, filename)
+	if err != nil {
+		return zero, err
+	}
+
+	if err := h.saveGallery(ctx, g); err != nil {
+		h.uploader.discardStack(ctx, stack)
+		if aggregate.IsConsistencyError(err) {
+			return zero, uploadError{http.StatusConflict, fmt.Errorf("save gallery: %w", err)}
+		}
+		return zero, fmt.Errorf("save gallery: %w", err)
+	}
+
+	return stack, nil
+}
+
+// gallery fetches the gallery with the given id. Galleries that have no events
+// are rejected, unless the [CreateGalleries] option is used.
+func (h *UploadHandler[Gallery, StackID, ImageID]) gallery(ctx context.Context, galleryID uuid.UUID) (Gallery, error) {
+	g, err := h.fetchGallery(ctx, galleryID)
+	if err != nil {
+		return g, fmt.Errorf("fetch gallery: %w", err)
+	}
+
+	if !h.cfg.create && pick.AggregateVersion(g) == 0 {
+		return g, fmt.Errorf("%w: %s", ErrGalleryNotFound, galleryID)
+	}
+
+	return g, nil
+}
+
+// uploadedFile returns the uploaded file of a request, and its filename.
+func uploadedFile(r *http.Request, field string) (io.Reader, string, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		var filename string
+		if _, params, err := mime.ParseMediaType(r.Header.Get("Content-Disposition")); err == nil {
+			filename = params["filename"]
+		}
+		if filename == "" {
+			filename = r.URL.Query().Get("filename")
+		}
+
+		if filename = cleanFilename(filename); filename == "" {
+			return nil, "", fmt.Errorf("missing filename")
+		}
+
+		return r.Body, filename, nil
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", fmt.Errorf("read multipart body: %w", err)
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, "", fmt.Errorf("%w in form field %q", errMissingFile, field)
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("read multipart body: %w", err)
+		}
+
+		if part.FormName() != field {
+			continue
+		}
+
+		filename := cleanFilename(part.FileName())
+		if filename == "" {
+			return nil, "", fmt.Errorf("missing filename in form field %q", field)
+		}
+
+		return part, filename, nil
+	}
+}
+
+// cleanFilename removes the directory from a client-provided filename.
+func cleanFilename(filename string) string {
+	filename = path.Base(strings.ReplaceAll(filename, "\\", "/"))
+	if filename == "." || filename == "/" {
+		return ""
+	}
+	return filename
+}
+
+func galleryIDFromPath(r *http.Request) (uuid.UUID, error) {
+	return uuid.Parse(strings.Trim(r.URL.Path, "/"))
+}
+
+// newUUID returns a function that generates random UUIDs if T is uuid.UUID,
+// or nil otherwise.
+func newUUID[T ID]() func() T {
+	var zero T
+	if _, ok := any(zero).(uuid.UUID); !ok {
+		return nil
+	}
+	return func() T {
+		return any(uuid.New()).(T)
+	}
+}
+
+// uploadError is an error with the HTTP status code of an upload request.
+type uploadError struct {
+	status int
+	err    error
+}
+
+func (err uploadError) Error() string { return err.err.Error() }
+
+func (err uploadError) Unwrap() error { return err.err }
+
+// uploadStatus returns the HTTP status code for an upload error.
+func uploadStatus(err error) int {
+	var uerr uploadError
+	switch {
+	case errors.As(err, &uerr):
+		return uerr.status
+	case errors.Is(err, ErrFileTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrUnsupportedType):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, ErrImageTooSmall), errors.Is(err, ErrImageTooLarge):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, gallery.ErrDuplicateID),
+		errors.Is(err, ErrOffsetMismatch),
+		errors.Is(err, ErrUploadIncomplete),
+		errors.Is(err, ErrSessionFinishing):
+		return http.StatusConflict
+	case errors.Is(err, ErrSessionNotFound), errors.Is(err, ErrGalleryNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeUploadError writes the error response of an upload request. The
+// messages of server errors are not exposed to the client.
+func writeUploadError(w http.ResponseWriter, err error) {
+	status := uploadStatus(err)
+	if status >= http.StatusInternalServerError {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	http.Error(w, err.Error(), status)
+}
@@ -0,0 +1,236 @@
+package esgallery_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/modernice/goes/aggregate/repository"
+	"github.com/modernice/goes/event/eventstore"
+	"github.com/modernice/media-entity/gallery"
+	"github.com/modernice/media-entity/goes/esgallery"
+)
+
+func TestUploadHandler(t *testing.T) {
+	var storage esgallery.MemoryStorage
+	galleries := repository.Typed(repository.New(eventstore.New()), NewTestGallery)
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
+
+	stackID := uuid.New()
+	imageID := uuid.New()
+	h := esgallery.NewUploadHandler(uploader, galleries.Fetch, galleries.Save, esgallery.GenerateIDs(
+		func() uuid.UUID { return stackID },
+		func() uuid.UUID { return imageID },
+	), esgallery.CreateGalleries[uuid.UUID, uuid.UUID]())
+
+	server := httptest.NewServer(http.StripPrefix("/galleries/", h))
+	defer server.Close()
+
+	galleryID := uuid.New()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("name", "foo")
+	part, _ := form.CreateFormFile(esgallery.DefaultUploadField, "foo.jpg")
+	part.Write(example)
+	form.Close()
+
+	resp, err := http.Post(server.URL+"/galleries/"+galleryID.String(), form.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatalf("POST: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		b, _ := io.ReadAll(resp.Body)
+		t.Fatalf("upload should be answered with status %d; got %d (%s)", http.StatusCreated, resp.StatusCode, b)
+	}
+
+	var stack gallery.Stack[uuid.UUID, uuid.UUID]
+	if err := json.NewDecoder(resp.Body).Decode(&stack); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+
+	if stack.ID != stackID || stack.Original().ID != imageID {
+		t.Fatalf("response should contain stack %s with image %s; got %+v", stackID, imageID, stack)
+	}
+
+	if stack.Original().Filename != "foo.jpg" || stack.Original().Filesize != len(example) {
+		t.Fatalf("original image should be %q with %d bytes; got %+v", "foo.jpg", len(example), stack.Original().Image)
+	}
+
+	g, err := galleries.Fetch(context.Background(), galleryID)
+	if err != nil {
+		t.Fatalf("fetch gallery: %v", err)
+	}
+
+	if _, ok := g.Stack(stackID); !ok {
+		t.Fatalf("gallery should have been saved with stack %s", stackID)
+	}
+
+	if _, err := storage.Get(context.Background(), stack.Original().Storage.Path); err != nil {
+		t.Fatalf("uploaded image should be in storage: %v", err)
+	}
+}
+
+func TestUploadHandler_raw(t *testing.T) {
+	var storage esgallery.MemoryStorage
+	galleries := repository.Typed(repository.New(eventstore.New()), NewTestGallery)
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
+
+	server := httptest.NewServer(esgallery.NewUploadHandler(uploader, galleries.Fetch, galleries.Save, esgallery.CreateGalleries[uuid.UUID, uuid.UUID]()))
+	defer server.Close()
+
+	galleryID := uuid.New()
+
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/"+galleryID.String(), newExample())
+	req.Header.Set("Content-Type", "image/jpeg")
+	req.Header.Set("Content-Disposition", `attachment; filename="../bar.jpg"`)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("upload should be answered with status %d; got %d", http.StatusCreated, resp.StatusCode)
+	}
+
+	var stack gallery.Stack[uuid.UUID, uuid.UUID]
+	if err := json.NewDecoder(resp.Body).Decode(&stack); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+
+	if stack.ID == uuid.Nil || stack.Original().ID == uuid.Nil {
+		t.Fatalf("stack and image ids should be generated; got %+v", stack)
+	}
+
+	if stack.Original().Filename != "bar.jpg" {
+		t.Fatalf("Filename should be %q; is %q", "bar.jpg", stack.Original().Filename)
+	}
+}
+
+func TestUploadHandler_unknownGallery(t *testing.T) {
+	var storage esgallery.MemoryStorage
+	galleries := repository.Typed(repository.New(eventstore.New()), NewTestGallery)
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
+
+	server := httptest.NewServer(esgallery.NewUploadHandler(uploader, galleries.Fetch, galleries.Save))
+	defer server.Close()
+
+	existing := NewTestGallery(uuid.New())
+	uploadStackWithVariant(t, uploader, existing)
+	if err := galleries.Save(context.Background(), existing); err != nil {
+		t.Fatalf("save gallery: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		gallery uuid.UUID
+		want    int
+	}{
+		{
+			name:    "unknown gallery",
+			gallery: uuid.New(),
+			want:    http.StatusNotFound,
+		},
+		{
+			name:    "existing gallery",
+			gallery: existing.ID,
+			want:    http.StatusCreated,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Post(server.URL+"/"+tt.gallery.String()+"?filename=foo.jpg", "image/jpeg", newExample())
+			if err != nil {
+				t.Fatalf("POST: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.want {
+				t.Fatalf("upload should be answered with status %d; got %d", tt.want, resp.StatusCode)
+			}
+		})
+	}
+
+	if files := storage.Files(); len(files) != 3 {
+		t.Fatalf("only the upload to the existing gallery should be stored; storage has %d files", len(files))
+	}
+}
+
+func TestUploadHandler_errors(t *testing.T) {
+	var storage esgallery.MemoryStorage
+	galleries := repository.Typed(repository.New(eventstore.New()), NewTestGallery)
+	uploader := esgallery.NewUploader[uuid.UUID, uuid.UUID](&storage)
+
+	server := httptest.NewServer(esgallery.NewUploadHandler(uploader, galleries.Fetch, galleries.Save, esgallery.CreateGalleries[uuid.UUID, uuid.UUID]()))
+	defer server.Close()
+
+	galleryURL := server.URL + "/" + uuid.NewString()
+
+	tests := []struct {
+		name   string
+		url    string
+		body   io.Reader
+		header http.Header
+		want   int
+	}{
+		{
+			name: "invalid gallery id",
+			url:  server.URL + "/foo?filename=foo.jpg",
+			body: newExample(),
+			want: http.StatusNotFound,
+		},
+		{
+			name: "missing filename",
+			url:  galleryURL,
+			body: newExample(),
+			want: http.StatusBadRequest,
+		},
+		{
+			name:   "missing file",
+			url:    galleryURL,
+			body:   strings.NewReader("--x--\r\n"),
+			header: http.Header{"Content-Type": {"multipart/form-data; boundary=x"}},
+			want:   http.StatusBadRequest,
+		},
+		{
+			name: "unsupported type",
+			url:  galleryURL + "?filename=foo.txt",
+			body: strings.NewReader("foo"),
+			want: http.StatusUnsupportedMediaType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodPost, tt.url, tt.body)
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("POST: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.want {
+				t.Fatalf("request should be answered with status %d; got %d", tt.want, resp.StatusCode)
+			}
+		})
+	}
+
+	if files := storage.Files(); len(files) != 0 {
+		t.Fatalf("no files should be uploaded; got %d", len(files))
+	}
+}
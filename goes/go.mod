@@ -5,6 +5,7 @@ go 1.19
 require github.com/modernice/goes v0.3.1
 
 require (
+	github.com/disintegration/imaging v1.6.2
 	github.com/google/go-cmp v0.5.9
 	github.com/google/uuid v1.3.0
 	github.com/modernice/media-tools v0.0.3
@@ -12,7 +13,6 @@ require (
 )
 
 require (
-	github.com/disintegration/imaging v1.6.2 // indirect
 	github.com/vitali-fedulov/images4 v1.1.3 // indirect
 	golang.org/x/image v0.0.0-20220902085622-e7cb96979f69 // indirect
 	google.golang.org/genproto v0.0.0-20230327215041-6ac7f18bb9d5 // indirect
@@ -0,0 +1,282 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EXIF tags that are parsed by parseEXIF.
+const (
+	tagImageDescription   = 0x010E
+	tagMake               = 0x010F
+	tagModel              = 0x0110
+	tagOrientation        = 0x0112
+	tagDateTime           = 0x0132
+	tagArtist             = 0x013B
+	tagCopyright          = 0x8298
+	tagExposureTime       = 0x829A
+	tagFNumber            = 0x829D
+	tagExifIFD            = 0x8769
+	tagGPSIFD             = 0x8825
+	tagISO                = 0x8827
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+	tagFocalLength        = 0x920A
+	tagLensModel          = 0xA434
+
+	tagGPSLatitudeRef  = 0x0001
+	tagGPSLatitude     = 0x0002
+	tagGPSLongitudeRef = 0x0003
+	tagGPSLongitude    = 0x0004
+	tagGPSAltitudeRef  = 0x0005
+	tagGPSAltitude     = 0x0006
+)
+
+// exifTypeSizes are the sizes in bytes of the EXIF field types.
+var exifTypeSizes = map[uint16]int{
+	1:  1, // BYTE
+	2:  1, // ASCII
+	3:  2, // SHORT
+	4:  4, // LONG
+	5:  8, // RATIONAL
+	7:  1, // UNDEFINED
+	9:  4, // SLONG
+	10: 8, // SRATIONAL
+}
+
+// parseEXIF parses the TIFF structure of EXIF data.
+func parseEXIF(data []byte) Metadata {
+	if len(data) < 8 {
+		return Metadata{}
+	}
+
+	var t tiff
+	switch {
+	case bytes.HasPrefix(data, []byte("II*\x00")):
+		t = tiff{data: data, order: binary.LittleEndian}
+	case bytes.HasPrefix(data, []byte("MM\x00*")):
+		t = tiff{data: data, order: binary.BigEndian}
+	default:
+		return Metadata{}
+	}
+
+	ifd0 := t.ifd(t.order.Uint32(data[4:]))
+
+	m := Metadata{
+		Make:        ifd0[tagMake].asString(),
+		Model:       ifd0[tagModel].asString(),
+		Orientation: int(ifd0[tagOrientation].asUint(t.order)),
+		Creator:     ifd0[tagArtist].asString(),
+		Copyright:   ifd0[tagCopyright].asString(),
+		Caption:     ifd0[tagImageDescription].asString(),
+	}
+
+	if m.Orientation < 1 || m.Orientation > 8 {
+		m.Orientation = 0
+	}
+
+	captured := ifd0[tagDateTime].asString()
+
+	if e, ok := ifd0[tagExifIFD]; ok {
+		exif := t.ifd(e.asUint(t.order))
+
+		if v := exif[tagDateTimeOriginal].asString(); v != "" {
+			captured = v
+			if offset := exif[tagOffsetTimeOriginal].asString(); offset != "" {
+				captured += offset
+			}
+		}
+
+		if r := exif[tagExposureTime].asRationals(t.order); len(r) > 0 {
+			m.ExposureTime = formatExposureTime(r[0])
+		}
+		if r := exif[tagFNumber].asRationals(t.order); len(r) > 0 {
+			m.FNumber = r[0].float()
+		}
+		if r := exif[tagFocalLength].asRationals(t.order); len(r) > 0 {
+			m.FocalLength = r[0].float()
+		}
+		m.ISO = int(exif[tagISO].asUint(t.order))
+		m.Lens = exif[tagLensModel].asString()
+	}
+
+	if captured, ok := parseEXIFTime(captured); ok {
+		m.CapturedAt = &captured
+	}
+
+	if e, ok := ifd0[tagGPSIFD]; ok {
+		m.GPS = parseGPS(t, t.ifd(e.asUint(t.order)))
+	}
+
+	return m
+}
+
+func parseGPS(t tiff, ifd map[uint16]exifEntry) *GPS {
+	lat, latOK := degrees(ifd[tagGPSLatitude].asRationals(t.order))
+	lon, lonOK := degrees(ifd[tagGPSLongitude].asRationals(t.order))
+	if !latOK || !lonOK {
+		return nil
+	}
+
+	if ifd[tagGPSLatitudeRef].asString() == "S" {
+		lat = -lat
+	}
+	if ifd[tagGPSLongitudeRef].asString() == "W" {
+		lon = -lon
+	}
+
+	gps := GPS{Latitude: lat, Longitude: lon}
+	if r := ifd[tagGPSAltitude].asRationals(t.order); len(r) > 0 {
+		gps.Altitude = r[0].float()
+		if ifd[tagGPSAltitudeRef].asUint(t.order) == 1 {
+			gps.Altitude = -gps.Altitude
+		}
+	}
+
+	return &gps
+}
+
+// degrees converts degrees, minutes, and seconds to decimal degrees.
+func degrees(dms []rational) (float64, bool) {
+	if len(dms) != 3 || dms[0].den == 0 || dms[1].den == 0 || dms[2].den == 0 {
+		return 0, false
+	}
+	return dms[0].float() + dms[1].float()/60 + dms[2].float()/3600, true
+}
+
+// parseEXIFTime parses an EXIF date and time, with an optional time zone
+// offset, for example "2023:08:15 14:30:00+02:00".
+func parseEXIFTime(v string) (time.Time, bool) {
+	if t, err := time.Parse("2006:01:02 15:04:05-07:00", v); err == nil {
+		return t, true
+	}
+	if t, err := time.Parse("2006:01:02 15:04:05", v); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// formatExposureTime formats an exposure time in seconds as a fraction, for
+// example "1/250", or as a decimal number if it is at least one second.
+func formatExposureTime(r rational) string {
+	v := r.float()
+	if v <= 0 {
+		return ""
+	}
+	if v >= 1 {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return "1/" + strconv.FormatFloat(math.Round(1/v), 'f', -1, 64)
+}
+
+type tiff struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+type exifEntry struct {
+	typ   uint16
+	count int
+	value []byte
+}
+
+type rational struct {
+	num, den float64
+}
+
+func (r rational) float() float64 {
+	if r.den == 0 {
+		return 0
+	}
+	return r.num / r.den
+}
+
+// ifd returns the entries of the image file directory at the given offset.
+// Entries whose values are out of bounds are skipped.
+func (t tiff) ifd(offset uint32) map[uint16]exifEntry {
+	entries := make(map[uint16]exifEntry)
+
+	start := int(offset)
+	if start < 8 || start+2 > len(t.data) {
+		return entries
+	}
+
+	n := int(t.order.Uint16(t.data[start:]))
+	for i := 0; i < n; i++ {
+		pos := start + 2 + i*12
+		if pos+12 > len(t.data) {
+			break
+		}
+
+		typ := t.order.Uint16(t.data[pos+2:])
+		size, ok := exifTypeSizes[typ]
+		if !ok {
+			continue
+		}
+
+		count := int(t.order.Uint32(t.data[pos+4:]))
+		length := size * count
+		if count < 0 || length < 0 || length > len(t.data) {
+			continue
+		}
+
+		value := t.data[pos+8 : pos+12]
+		if length > 4 {
+			valueOffset := int(t.order.Uint32(t.data[pos+8:]))
+			if valueOffset < 0 || valueOffset+length > len(t.data) {
+				continue
+			}
+			value = t.data[valueOffset : valueOffset+length]
+		}
+
+		entries[t.order.Uint16(t.data[pos:])] = exifEntry{typ: typ, count: count, value: value[:length]}
+	}
+
+	return entries
+}
+
+func (e exifEntry) asString() string {
+	if e.typ != 2 {
+		return ""
+	}
+	if i := bytes.IndexByte(e.value, 0); i >= 0 {
+		return strings.TrimSpace(string(e.value[:i]))
+	}
+	return strings.TrimSpace(string(e.value))
+}
+
+func (e exifEntry) asUint(order binary.ByteOrder) uint32 {
+	if e.count < 1 {
+		return 0
+	}
+	switch e.typ {
+	case 1, 7:
+		return uint32(e.value[0])
+	case 3:
+		return uint32(order.Uint16(e.value))
+	case 4:
+		return order.Uint32(e.value)
+	}
+	return 0
+}
+
+func (e exifEntry) asRationals(order binary.ByteOrder) []rational {
+	if e.typ != 5 && e.typ != 10 {
+		return nil
+	}
+
+	out := make([]rational, e.count)
+	for i := range out {
+		num, den := order.Uint32(e.value[i*8:]), order.Uint32(e.value[i*8+4:])
+		if e.typ == 10 {
+			out[i] = rational{float64(int32(num)), float64(int32(den))}
+		} else {
+			out[i] = rational{float64(num), float64(den)}
+		}
+	}
+	return out
+}
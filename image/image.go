@@ -1,6 +1,8 @@
 package image
 
 import (
+	"fmt"
+
 	"github.com/modernice/media-entity/file"
 	"github.com/modernice/media-entity/internal/maps"
 	"github.com/modernice/media-tools/image"
@@ -15,6 +17,22 @@ type Image struct {
 	Names        map[string]string `json:"names"`
 	Descriptions map[string]string `json:"descriptions"`
 	Tags         Tags              `json:"tags"`
+
+	// Hash is the hex-encoded SHA-256 hash of the file contents. Hash is only
+	// set for images that are stored at content-addressed storage paths.
+	Hash string `json:"hash,omitempty"`
+
+	// URL is the public URL of the image. URL is not recorded when the image
+	// is stored; it is filled in by a [URLResolver] when the image is served.
+	URL string `json:"url,omitempty"`
+
+	// Source is the URL that the image was imported from, if the image was
+	// imported from a URL instead of being uploaded.
+	Source string `json:"source,omitempty"`
+
+	// Metadata is the EXIF, IPTC, and XMP metadata of the image file, if the
+	// file contains any.
+	Metadata *Metadata `json:"metadata,omitempty"`
 }
 
 // Tags are the tags of an [Image].
@@ -27,6 +45,9 @@ func NewTags(tags ...string) Tags {
 
 type Storage = file.Storage
 
+// URLResolver resolves the public URL of an image from its [Storage] location.
+type URLResolver = file.URLResolver
+
 // Dimensions are the width and height of an image, in pixels.
 type Dimensions = image.Dimensions
 
@@ -49,5 +70,20 @@ func (img Image) Normalize() Image {
 func (img Image) Clone() Image {
 	img.Names = maps.Clone(img.Names)
 	img.Descriptions = maps.Clone(img.Descriptions)
+	if img.Metadata != nil {
+		metadata := img.Metadata.Clone()
+		img.Metadata = &metadata
+	}
 	return img
 }
+
+// ResolveURL returns a copy of the image with its URL set to the URL that is
+// resolved from its [Storage] location by the provided [URLResolver].
+func (img Image) ResolveURL(r URLResolver) (Image, error) {
+	u, err := r.ResolveURL(img.Storage)
+	if err != nil {
+		return img, fmt.Errorf("resolve url of %q: %w", img.Storage.Path, err)
+	}
+	img.URL = u
+	return img, nil
+}
@@ -0,0 +1,75 @@
+package image
+
+import (
+	"encoding/binary"
+	"strings"
+	"unicode/utf8"
+)
+
+// IPTC-IIM datasets of the application record that are parsed by parseIPTC.
+const (
+	iptcObjectName = 5
+	iptcKeywords   = 25
+	iptcByline     = 80
+	iptcHeadline   = 105
+	iptcCopyright  = 116
+	iptcCaption    = 120
+)
+
+// parseIPTC parses IPTC-IIM records.
+func parseIPTC(data []byte) Metadata {
+	var m Metadata
+	var headline string
+
+	for i := 0; i+5 <= len(data); {
+		if data[i] != 0x1C {
+			break
+		}
+		record, dataset := data[i+1], data[i+2]
+		size := int(binary.BigEndian.Uint16(data[i+3:]))
+
+		// Extended datasets are not used by the parsed fields.
+		if size&0x8000 != 0 || i+5+size > len(data) {
+			break
+		}
+		value := iptcString(data[i+5 : i+5+size])
+		i += 5 + size
+
+		if record != 2 || value == "" {
+			continue
+		}
+
+		switch dataset {
+		case iptcObjectName:
+			m.Title = value
+		case iptcKeywords:
+			m.Keywords = append(m.Keywords, value)
+		case iptcByline:
+			setString(&m.Creator, value)
+		case iptcHeadline:
+			headline = value
+		case iptcCopyright:
+			m.Copyright = value
+		case iptcCaption:
+			m.Caption = value
+		}
+	}
+
+	setString(&m.Title, headline)
+
+	return m
+}
+
+// iptcString decodes an IPTC string. Strings are expected to be UTF-8 encoded,
+// but strings that are not valid UTF-8 are decoded as ISO-8859-1.
+func iptcString(b []byte) string {
+	if utf8.Valid(b) {
+		return strings.TrimSpace(string(b))
+	}
+
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return strings.TrimSpace(string(runes))
+}
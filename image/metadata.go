@@ -0,0 +1,541 @@
+package image
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"time"
+)
+
+// ErrUnknownFormat is returned by [ParseMetadata] if the format of an image is
+// not supported.
+var ErrUnknownFormat = errors.New("unknown image format")
+
+// Metadata is the metadata that is embedded in an image file as EXIF, IPTC,
+// and/or XMP. All fields are optional.
+type Metadata struct {
+	// Make is the manufacturer of the camera.
+	Make string `json:"make,omitempty"`
+
+	// Model is the model of the camera.
+	Model string `json:"model,omitempty"`
+
+	// Lens is the model of the lens.
+	Lens string `json:"lens,omitempty"`
+
+	// Orientation is the EXIF orientation of the image, from 1 to 8. Zero
+	// means the orientation is unknown, which is the same as 1 (upright).
+	Orientation int `json:"orientation,omitempty"`
+
+	// CapturedAt is the date and time at which the image was captured. If the
+	// image does not specify a time zone offset, the time is in UTC.
+	CapturedAt *time.Time `json:"capturedAt,omitempty"`
+
+	// ExposureTime is the exposure time in seconds, for example "1/250".
+	ExposureTime string `json:"exposureTime,omitempty"`
+
+	// FNumber is the f-number of the aperture.
+	FNumber float64 `json:"fNumber,omitempty"`
+
+	// ISO is the ISO speed rating.
+	ISO int `json:"iso,omitempty"`
+
+	// FocalLength is the focal length of the lens in millimeters.
+	FocalLength float64 `json:"focalLength,omitempty"`
+
+	// GPS is the location at which the image was captured.
+	GPS *GPS `json:"gps,omitempty"`
+
+	// Creator is the name of the photographer.
+	Creator string `json:"creator,omitempty"`
+
+	// Copyright is the copyright notice.
+	Copyright string `json:"copyright,omitempty"`
+
+	// Title is the title of the image.
+	Title string `json:"title,omitempty"`
+
+	// Caption is the caption or description of the image.
+	Caption string `json:"caption,omitempty"`
+
+	// Keywords are the keywords of the image.
+	Keywords []string `json:"keywords,omitempty"`
+}
+
+// GPS is a location in decimal degrees. Southern latitudes and western
+// longitudes are negative.
+type GPS struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+
+	// Altitude is the altitude in meters above sea level.
+	Altitude float64 `json:"altitude,omitempty"`
+}
+
+// IsZero returns whether the metadata is empty.
+func (m Metadata) IsZero() bool {
+	return reflect.ValueOf(m).IsZero()
+}
+
+// Clone returns a deep copy of the metadata.
+func (m Metadata) Clone() Metadata {
+	if m.CapturedAt != nil {
+		t := *m.CapturedAt
+		m.CapturedAt = &t
+	}
+	if m.GPS != nil {
+		gps := *m.GPS
+		m.GPS = &gps
+	}
+	if m.Keywords != nil {
+		m.Keywords = append([]string(nil), m.Keywords...)
+	}
+	return m
+}
+
+// fill sets the empty fields of m to the fields of other.
+func (m *Metadata) fill(other Metadata) {
+	setString(&m.Make, other.Make)
+	setString(&m.Model, other.Model)
+	setString(&m.Lens, other.Lens)
+	setString(&m.ExposureTime, other.ExposureTime)
+	setString(&m.Creator, other.Creator)
+	setString(&m.Copyright, other.Copyright)
+	setString(&m.Title, other.Title)
+	setString(&m.Caption, other.Caption)
+	if m.Orientation == 0 {
+		m.Orientation = other.Orientation
+	}
+	if m.CapturedAt == nil {
+		m.CapturedAt = other.CapturedAt
+	}
+	if m.FNumber == 0 {
+		m.FNumber = other.FNumber
+	}
+	if m.ISO == 0 {
+		m.ISO = other.ISO
+	}
+	if m.FocalLength == 0 {
+		m.FocalLength = other.FocalLength
+	}
+	if m.GPS == nil {
+		m.GPS = other.GPS
+	}
+	if len(m.Keywords) == 0 {
+		m.Keywords = other.Keywords
+	}
+}
+
+func setString(s *string, v string) {
+	if *s == "" {
+		*s = v
+	}
+}
+
+// ParseMetadata parses the EXIF, IPTC, and XMP metadata of a JPEG, PNG, or
+// WebP image. If the same field is provided by multiple sources, XMP takes
+// precedence over IPTC, and IPTC takes precedence over EXIF.
+//
+// data may be truncated, for example if only the start of a file was read;
+// metadata that is stored after the end of data is ignored. PNG and WebP
+// images may store their metadata after the image data; use [ReadMetadata] to
+// read the metadata of such images without buffering them. Malformed metadata
+// is skipped. If data is not a JPEG, PNG, or WebP image, an error that
+// satisfies errors.Is(err, ErrUnknownFormat) is returned.
+func ParseMetadata(data []byte) (Metadata, error) {
+	var segments metadataSegments
+
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		segments = jpegSegments(data)
+	case bytes.HasPrefix(data, pngSignature):
+		segments = pngSegments(data)
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		segments = webpSegments(data)
+	default:
+		return Metadata{}, ErrUnknownFormat
+	}
+
+	return segments.metadata(), nil
+}
+
+// ReadMetadata reads the EXIF, IPTC, and XMP metadata of a JPEG, PNG, or WebP
+// image from r, like [ParseMetadata]. Unlike ParseMetadata, ReadMetadata reads
+// the image until the end of its metadata, which may be stored after the image
+// data, but only buffers the segments that contain metadata. Segments that are
+// larger than 16 MiB are skipped.
+//
+// If r ends before the end of the image, the metadata that was read is
+// returned. If the image is not a JPEG, PNG, or WebP image, an error that
+// satisfies errors.Is(err, ErrUnknownFormat) is returned.
+func ReadMetadata(r io.Reader) (Metadata, error) {
+	br := bufio.NewReader(r)
+
+	header, err := br.Peek(12)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return Metadata{}, err
+	}
+
+	var segments metadataSegments
+
+	switch {
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8}):
+		err = readJPEGSegments(br, &segments)
+	case bytes.HasPrefix(header, pngSignature):
+		err = readPNGSegments(br, &segments)
+	case len(header) >= 12 && string(header[:4]) == "RIFF" && string(header[8:12]) == "WEBP":
+		err = readWebPSegments(br, &segments)
+	default:
+		return Metadata{}, ErrUnknownFormat
+	}
+
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return Metadata{}, err
+	}
+
+	return segments.metadata(), nil
+}
+
+// metadataSegments are the raw metadata segments of an image file. exif is
+// the TIFF structure of the EXIF data, iptc contains IPTC-IIM records, and xmp
+// is the XMP packet.
+type metadataSegments struct {
+	exif []byte
+	iptc []byte
+	xmp  []byte
+}
+
+// metadata parses the metadata segments. XMP takes precedence over IPTC, and
+// IPTC takes precedence over EXIF.
+func (s metadataSegments) metadata() Metadata {
+	var m Metadata
+	if s.xmp != nil {
+		m = parseXMP(s.xmp)
+	}
+	if s.iptc != nil {
+		m.fill(parseIPTC(s.iptc))
+	}
+	if s.exif != nil {
+		m.fill(parseEXIF(s.exif))
+	}
+	return m
+}
+
+// maxSegmentSize is the maximum size of a metadata segment that is buffered
+// by [ReadMetadata].
+const maxSegmentSize = 16 << 20
+
+var (
+	exifHeader      = []byte("Exif\x00\x00")
+	xmpHeader       = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	photoshopHeader = []byte("Photoshop 3.0\x00")
+	pngSignature    = []byte("\x89PNG\r\n\x1a\n")
+)
+
+func jpegSegments(data []byte) metadataSegments {
+	var segments metadataSegments
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			break
+		}
+
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF:
+			// Fill byte.
+			i++
+			continue
+		case marker == 0x01 || marker >= 0xD0 && marker <= 0xD8:
+			// Markers without a payload.
+			i += 2
+			continue
+		case marker == 0xD9 || marker == 0xDA:
+			// The metadata segments are before the start of the scan.
+			return segments
+		}
+
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			break
+		}
+		payload := data[i+4 : i+2+length]
+		i += 2 + length
+
+		segments.jpegSegment(marker, payload)
+	}
+
+	return segments
+}
+
+func readJPEGSegments(r *bufio.Reader, segments *metadataSegments) error {
+	if _, err := r.Discard(2); err != nil {
+		return err
+	}
+
+	for {
+		b, err := r.ReadByte()
+		if err != nil || b != 0xFF {
+			return err
+		}
+
+		marker, err := r.ReadByte()
+		for err == nil && marker == 0xFF {
+			// Fill bytes.
+			marker, err = r.ReadByte()
+		}
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case marker == 0x01 || marker >= 0xD0 && marker <= 0xD8:
+			// Markers without a payload.
+			continue
+		case marker == 0xD9 || marker == 0xDA:
+			// The metadata segments are before the start of the scan.
+			return nil
+		}
+
+		var length [2]byte
+		if _, err := io.ReadFull(r, length[:]); err != nil {
+			return err
+		}
+		size := int64(binary.BigEndian.Uint16(length[:])) - 2
+		if size < 0 {
+			return nil
+		}
+
+		payload, err := readSegment(r, size, marker == 0xE1 || marker == 0xED)
+		if err != nil {
+			return err
+		}
+		segments.jpegSegment(marker, payload)
+	}
+}
+
+func (s *metadataSegments) jpegSegment(marker byte, payload []byte) {
+	switch {
+	case marker == 0xE1 && bytes.HasPrefix(payload, exifHeader):
+		s.exif = payload[len(exifHeader):]
+	case marker == 0xE1 && bytes.HasPrefix(payload, xmpHeader):
+		s.xmp = payload[len(xmpHeader):]
+	case marker == 0xED && bytes.HasPrefix(payload, photoshopHeader):
+		s.iptc = photoshopIPTC(payload[len(photoshopHeader):])
+	}
+}
+
+// photoshopIPTC returns the IPTC-IIM records of Photoshop image resources.
+func photoshopIPTC(data []byte) []byte {
+	for i := 0; i+7 <= len(data); {
+		if string(data[i:i+4]) != "8BIM" {
+			return nil
+		}
+		id := binary.BigEndian.Uint16(data[i+4:])
+
+		// The name is a Pascal string that is padded to an even length.
+		nameLength := int(data[i+6]) + 1
+		if nameLength%2 != 0 {
+			nameLength++
+		}
+		i += 6 + nameLength
+
+		if i+4 > len(data) {
+			return nil
+		}
+		size := int(binary.BigEndian.Uint32(data[i:]))
+		i += 4
+		if size < 0 || i+size > len(data) {
+			return nil
+		}
+
+		if id == 0x0404 {
+			return data[i : i+size]
+		}
+
+		i += size
+		if size%2 != 0 {
+			i++
+		}
+	}
+	return nil
+}
+
+func pngSegments(data []byte) metadataSegments {
+	var segments metadataSegments
+
+	for i := len(pngSignature); i+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		typ := string(data[i+4 : i+8])
+		if length < 0 || i+8+length > len(data) {
+			break
+		}
+		chunk := data[i+8 : i+8+length]
+		i += 12 + length
+
+		if typ == "IEND" {
+			return segments
+		}
+		segments.pngChunk(typ, chunk)
+	}
+
+	return segments
+}
+
+func readPNGSegments(r *bufio.Reader, segments *metadataSegments) error {
+	if _, err := r.Discard(len(pngSignature)); err != nil {
+		return err
+	}
+
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return err
+		}
+		length := int64(binary.BigEndian.Uint32(header[:]))
+		typ := string(header[4:])
+
+		if typ == "IEND" {
+			return nil
+		}
+
+		chunk, err := readSegment(r, length, typ == "eXIf" || typ == "iTXt")
+		if err != nil {
+			return err
+		}
+
+		// Skip the CRC.
+		if _, err := r.Discard(4); err != nil {
+			return err
+		}
+
+		segments.pngChunk(typ, chunk)
+	}
+}
+
+func (s *metadataSegments) pngChunk(typ string, chunk []byte) {
+	switch typ {
+	case "eXIf":
+		s.exif = bytes.TrimPrefix(chunk, exifHeader)
+	case "iTXt":
+		if xmp, ok := pngXMP(chunk); ok {
+			s.xmp = xmp
+		}
+	}
+}
+
+// pngXMP returns the XMP packet of an iTXt chunk.
+func pngXMP(chunk []byte) ([]byte, bool) {
+	fields := bytes.SplitN(chunk, []byte{0}, 2)
+	if len(fields) != 2 || string(fields[0]) != "XML:com.adobe.xmp" || len(fields[1]) < 2 {
+		return nil, false
+	}
+	compressed := fields[1][0] == 1
+
+	// Skip the language tag and the translated keyword.
+	text := fields[1][2:]
+	for i := 0; i < 2; i++ {
+		n := bytes.IndexByte(text, 0)
+		if n < 0 {
+			return nil, false
+		}
+		text = text[n+1:]
+	}
+
+	if !compressed {
+		return text, true
+	}
+
+	r, err := zlib.NewReader(bytes.NewReader(text))
+	if err != nil {
+		return nil, false
+	}
+	defer r.Close()
+
+	xmp, err := io.ReadAll(io.LimitReader(r, 1<<20))
+	if err != nil {
+		return nil, false
+	}
+
+	return xmp, true
+}
+
+func webpSegments(data []byte) metadataSegments {
+	var segments metadataSegments
+
+	for i := 12; i+8 <= len(data); {
+		typ := string(data[i : i+4])
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		if size < 0 || i+8+size > len(data) {
+			break
+		}
+		chunk := data[i+8 : i+8+size]
+		i += 8 + size + size%2
+
+		segments.webpChunk(typ, chunk)
+	}
+
+	return segments
+}
+
+func readWebPSegments(r *bufio.Reader, segments *metadataSegments) error {
+	if _, err := r.Discard(12); err != nil {
+		return err
+	}
+
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return err
+		}
+		typ := string(header[:4])
+		size := int64(binary.LittleEndian.Uint32(header[4:]))
+
+		chunk, err := readSegment(r, size, typ == "EXIF" || typ == "XMP ")
+		if err != nil {
+			return err
+		}
+
+		// Chunks are padded to an even size.
+		if size%2 != 0 {
+			if _, err := r.Discard(1); err != nil {
+				return err
+			}
+		}
+
+		segments.webpChunk(typ, chunk)
+	}
+}
+
+func (s *metadataSegments) webpChunk(typ string, chunk []byte) {
+	switch typ {
+	case "EXIF":
+		s.exif = bytes.TrimPrefix(chunk, exifHeader)
+	case "XMP ":
+		s.xmp = chunk
+	}
+}
+
+// readSegment reads a segment of the given size from r. If keep is false, or
+// if the segment is larger than [maxSegmentSize], the segment is skipped and
+// nil is returned.
+func readSegment(r io.Reader, size int64, keep bool) ([]byte, error) {
+	if !keep || size > maxSegmentSize {
+		n, err := io.CopyN(io.Discard, r, size)
+		if err == nil && n < size {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	segment := make([]byte, size)
+	if _, err := io.ReadFull(r, segment); err != nil {
+		return nil, err
+	}
+
+	return segment, nil
+}
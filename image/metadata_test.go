@@ -0,0 +1,371 @@
+package image_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/modernice/media-entity/image"
+)
+
+func TestParseMetadata_jpeg(t *testing.T) {
+	exif := newTIFF(
+		[]tiffEntry{
+			ascii(0x010E, "EXIF description"),
+			ascii(0x010F, "Canon"),
+			ascii(0x0110, "EOS R5"),
+			short(0x0112, 6),
+			ascii(0x013B, "EXIF Artist"),
+		},
+		[]tiffEntry{
+			rationals(0x829A, 1, 250),
+			rationals(0x829D, 28, 10),
+			short(0x8827, 100),
+			ascii(0x9003, "2023:08:15 14:30:00"),
+			ascii(0x9011, "+02:00"),
+			rationals(0x920A, 50, 1),
+			ascii(0xA434, "RF50mm F1.8 STM"),
+		},
+		[]tiffEntry{
+			ascii(0x0001, "S"),
+			rationals(0x0002, 33, 1, 51, 1, 36, 1),
+			ascii(0x0003, "E"),
+			rationals(0x0004, 151, 1, 12, 1, 54, 1),
+			{tag: 0x0005, typ: 1, count: 1, value: []byte{0}},
+			rationals(0x0006, 69, 2),
+		},
+	)
+
+	iptc := newIPTC(map[byte][]string{
+		5:   {"IPTC Title"},
+		25:  {"beach", "summer"},
+		80:  {"IPTC Creator"},
+		116: {"© 2023 Jane Doe"},
+		120: {"IPTC caption"},
+	})
+
+	xmp := []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/">
+  <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+    <rdf:Description xmlns:dc="http://purl.org/dc/elements/1.1/">
+      <dc:title>
+        <rdf:Alt>
+          <rdf:li xml:lang="de">Strand</rdf:li>
+          <rdf:li xml:lang="x-default">Beach</rdf:li>
+        </rdf:Alt>
+      </dc:title>
+    </rdf:Description>
+  </rdf:RDF>
+</x:xmpmeta>`)
+
+	data := newJPEG(
+		jpegSegment{0xE1, append([]byte("Exif\x00\x00"), exif...)},
+		jpegSegment{0xE1, append([]byte("http://ns.adobe.com/xap/1.0/\x00"), xmp...)},
+		jpegSegment{0xED, photoshopResource(0x0404, iptc)},
+	)
+
+	got, err := image.ParseMetadata(data)
+	if err != nil {
+		t.Fatalf("ParseMetadata() failed: %v", err)
+	}
+
+	capturedAt := time.Date(2023, 8, 15, 14, 30, 0, 0, time.FixedZone("", 2*60*60))
+	want := image.Metadata{
+		Make:         "Canon",
+		Model:        "EOS R5",
+		Lens:         "RF50mm F1.8 STM",
+		Orientation:  6,
+		CapturedAt:   &capturedAt,
+		ExposureTime: "1/250",
+		FNumber:      2.8,
+		ISO:          100,
+		FocalLength:  50,
+		GPS:          &image.GPS{Latitude: -33.86, Longitude: 151.215, Altitude: 34.5},
+		Creator:      "IPTC Creator",
+		Copyright:    "© 2023 Jane Doe",
+		Title:        "Beach",
+		Caption:      "IPTC caption",
+		Keywords:     []string{"beach", "summer"},
+	}
+
+	if diff := cmp.Diff(want, got, cmpopts.EquateApprox(0, 1e-9), cmp.Comparer(time.Time.Equal)); diff != "" {
+		t.Fatalf("ParseMetadata() returned unexpected metadata (-want +got):\n%s", diff)
+	}
+
+	for n := 0; n < len(data); n += 7 {
+		image.ParseMetadata(data[:n])
+	}
+}
+
+func TestParseMetadata_formats(t *testing.T) {
+	exif := newTIFF([]tiffEntry{short(0x0112, 3)}, nil, nil)
+	xmp := []byte(`<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns:dc="http://purl.org/dc/elements/1.1/">
+  <rdf:Description><dc:description>A sunny beach</dc:description></rdf:Description>
+</rdf:RDF>`)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{
+			name: "png",
+			data: newPNG(
+				pngChunk{"eXIf", exif},
+				pngChunk{"iTXt", append([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), xmp...)},
+			),
+		},
+		{
+			name: "webp",
+			data: newWebP(
+				webpChunk{"EXIF", exif},
+				webpChunk{"XMP ", xmp},
+			),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := image.ParseMetadata(tt.data)
+			if err != nil {
+				t.Fatalf("ParseMetadata() failed: %v", err)
+			}
+
+			want := image.Metadata{Orientation: 3, Caption: "A sunny beach"}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Fatalf("ParseMetadata() returned unexpected metadata (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestReadMetadata(t *testing.T) {
+	exif := newTIFF([]tiffEntry{short(0x0112, 6)}, nil, nil)
+	xmp := []byte(`<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns:dc="http://purl.org/dc/elements/1.1/">
+  <rdf:Description><dc:description>A sunny beach</dc:description></rdf:Description>
+</rdf:RDF>`)
+
+	// The metadata is stored after 2 MiB of image data.
+	pixels := make([]byte, 2<<20+1)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{
+			name: "jpeg",
+			data: newJPEG(
+				jpegSegment{0xE1, append([]byte("Exif\x00\x00"), exif...)},
+				jpegSegment{0xE2, pixels[:60000]},
+				jpegSegment{0xE1, append([]byte("http://ns.adobe.com/xap/1.0/\x00"), xmp...)},
+			),
+		},
+		{
+			name: "png",
+			data: newPNG(
+				pngChunk{"IDAT", pixels},
+				pngChunk{"eXIf", exif},
+				pngChunk{"iTXt", append([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), xmp...)},
+			),
+		},
+		{
+			name: "webp",
+			data: newWebP(
+				webpChunk{"VP8 ", pixels},
+				webpChunk{"EXIF", exif},
+				webpChunk{"XMP ", xmp},
+			),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := image.ReadMetadata(bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatalf("ReadMetadata() failed: %v", err)
+			}
+
+			want := image.Metadata{Orientation: 6, Caption: "A sunny beach"}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Fatalf("ReadMetadata() returned unexpected metadata (-want +got):\n%s", diff)
+			}
+
+			for _, n := range []int{0, 1, 12, len(tt.data) / 2, len(tt.data) - 1} {
+				if _, err := image.ReadMetadata(bytes.NewReader(tt.data[:n])); err != nil && !errors.Is(err, image.ErrUnknownFormat) {
+					t.Fatalf("ReadMetadata() should not fail for truncated data; got %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestReadMetadata_readError(t *testing.T) {
+	data := newPNG(pngChunk{"IDAT", make([]byte, 1024)})
+	r := io.MultiReader(bytes.NewReader(data[:100]), iotest.ErrReader(errMockRead))
+
+	if _, err := image.ReadMetadata(r); !errors.Is(err, errMockRead) {
+		t.Fatalf("ReadMetadata() should fail with %q; got %v", errMockRead, err)
+	}
+}
+
+var errMockRead = errors.New("mock read error")
+
+func TestParseMetadata_unknownFormat(t *testing.T) {
+	if _, err := image.ParseMetadata([]byte("GIF89a")); !errors.Is(err, image.ErrUnknownFormat) {
+		t.Fatalf("ParseMetadata() should fail with %q; got %v", image.ErrUnknownFormat, err)
+	}
+}
+
+type tiffEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+func ascii(tag uint16, v string) tiffEntry {
+	return tiffEntry{tag: tag, typ: 2, count: uint32(len(v) + 1), value: append([]byte(v), 0)}
+}
+
+func short(tag uint16, v uint16) tiffEntry {
+	return tiffEntry{tag: tag, typ: 3, count: 1, value: binary.BigEndian.AppendUint16(nil, v)}
+}
+
+func long(tag uint16, v uint32) tiffEntry {
+	return tiffEntry{tag: tag, typ: 4, count: 1, value: binary.BigEndian.AppendUint32(nil, v)}
+}
+
+func rationals(tag uint16, v ...uint32) tiffEntry {
+	var value []byte
+	for _, n := range v {
+		value = binary.BigEndian.AppendUint32(value, n)
+	}
+	return tiffEntry{tag: tag, typ: 5, count: uint32(len(v) / 2), value: value}
+}
+
+// newTIFF returns big-endian TIFF data with the given IFD0, Exif IFD, and GPS
+// IFD entries.
+func newTIFF(ifd0, exif, gps []tiffEntry) []byte {
+	b := []byte("MM\x00*\x00\x00\x00\x00")
+
+	if len(exif) > 0 {
+		var offset uint32
+		b, offset = appendIFD(b, exif)
+		ifd0 = append(ifd0, long(0x8769, offset))
+	}
+
+	if len(gps) > 0 {
+		var offset uint32
+		b, offset = appendIFD(b, gps)
+		ifd0 = append(ifd0, long(0x8825, offset))
+	}
+
+	b, offset := appendIFD(b, ifd0)
+	binary.BigEndian.PutUint32(b[4:], offset)
+
+	return b
+}
+
+func appendIFD(b []byte, entries []tiffEntry) ([]byte, uint32) {
+	offset := uint32(len(b))
+	dataOffset := offset + 2 + uint32(len(entries))*12 + 4
+
+	var data []byte
+	b = binary.BigEndian.AppendUint16(b, uint16(len(entries)))
+	for _, e := range entries {
+		b = binary.BigEndian.AppendUint16(b, e.tag)
+		b = binary.BigEndian.AppendUint16(b, e.typ)
+		b = binary.BigEndian.AppendUint32(b, e.count)
+		if len(e.value) <= 4 {
+			b = append(b, append(e.value, make([]byte, 4-len(e.value))...)...)
+			continue
+		}
+		b = binary.BigEndian.AppendUint32(b, dataOffset+uint32(len(data)))
+		data = append(data, e.value...)
+		if len(data)%2 != 0 {
+			data = append(data, 0)
+		}
+	}
+	b = binary.BigEndian.AppendUint32(b, 0)
+
+	return append(b, data...), offset
+}
+
+func newIPTC(datasets map[byte][]string) []byte {
+	var b []byte
+	for _, dataset := range []byte{5, 25, 80, 116, 120} {
+		for _, v := range datasets[dataset] {
+			b = append(b, 0x1C, 2, dataset)
+			b = binary.BigEndian.AppendUint16(b, uint16(len(v)))
+			b = append(b, v...)
+		}
+	}
+	return b
+}
+
+func photoshopResource(id uint16, data []byte) []byte {
+	b := []byte("Photoshop 3.0\x008BIM")
+	b = binary.BigEndian.AppendUint16(b, id)
+	b = append(b, 0, 0)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+	return append(b, data...)
+}
+
+type jpegSegment struct {
+	marker  byte
+	payload []byte
+}
+
+func newJPEG(segments ...jpegSegment) []byte {
+	b := []byte{0xFF, 0xD8}
+	for _, s := range segments {
+		b = append(b, 0xFF, s.marker)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(s.payload)+2))
+		b = append(b, s.payload...)
+	}
+	return append(b, 0xFF, 0xD9)
+}
+
+type pngChunk struct {
+	typ  string
+	data []byte
+}
+
+func newPNG(chunks ...pngChunk) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	for _, c := range append(chunks, pngChunk{typ: "IEND"}) {
+		binary.Write(&buf, binary.BigEndian, uint32(len(c.data)))
+		buf.WriteString(c.typ)
+		buf.Write(c.data)
+		binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(append([]byte(c.typ), c.data...)))
+	}
+	return buf.Bytes()
+}
+
+type webpChunk struct {
+	typ  string
+	data []byte
+}
+
+func newWebP(chunks ...webpChunk) []byte {
+	var body []byte
+	for _, c := range chunks {
+		body = append(body, c.typ...)
+		body = binary.LittleEndian.AppendUint32(body, uint32(len(c.data)))
+		body = append(body, c.data...)
+		if len(c.data)%2 != 0 {
+			body = append(body, 0)
+		}
+	}
+
+	b := []byte("RIFF")
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(body)+4))
+	b = append(b, "WEBP"...)
+	return append(b, body...)
+}
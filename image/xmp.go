@@ -0,0 +1,97 @@
+package image
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+)
+
+const (
+	xmpNamespaceDC  = "http://purl.org/dc/elements/1.1/"
+	xmpNamespaceRDF = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	xmpNamespaceXML = "http://www.w3.org/XML/1998/namespace"
+)
+
+// parseXMP parses the Dublin Core properties of an XMP packet. Language
+// alternatives prefer the "x-default" language.
+func parseXMP(data []byte) Metadata {
+	var m Metadata
+
+	dec := xml.NewDecoder(bytes.NewReader(data))
+
+	var (
+		property string
+		values   []string
+		text     strings.Builder
+	)
+
+	setAlt := func(field *string, value, lang string) {
+		if lang == "x-default" || *field == "" {
+			*field = value
+		}
+	}
+
+	add := func(value, lang string) {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			return
+		}
+		values = append(values, value)
+
+		switch property {
+		case "title":
+			setAlt(&m.Title, value, lang)
+		case "description":
+			setAlt(&m.Caption, value, lang)
+		case "rights":
+			setAlt(&m.Copyright, value, lang)
+		case "creator":
+			setString(&m.Creator, value)
+		case "subject":
+			m.Keywords = append(m.Keywords, value)
+		}
+	}
+
+	var lang string
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			switch {
+			case property == "" && tok.Name.Space == xmpNamespaceDC:
+				property, values = tok.Name.Local, nil
+				text.Reset()
+			case property != "" && tok.Name.Space == xmpNamespaceRDF && tok.Name.Local == "li":
+				lang = ""
+				for _, attr := range tok.Attr {
+					if attr.Name.Space == xmpNamespaceXML && attr.Name.Local == "lang" {
+						lang = attr.Value
+					}
+				}
+				text.Reset()
+			}
+		case xml.CharData:
+			if property != "" {
+				text.Write(tok)
+			}
+		case xml.EndElement:
+			switch {
+			case property != "" && tok.Name.Space == xmpNamespaceRDF && tok.Name.Local == "li":
+				add(text.String(), lang)
+				text.Reset()
+			case tok.Name.Space == xmpNamespaceDC && tok.Name.Local == property:
+				// Simple properties are not wrapped in an rdf:li element.
+				if len(values) == 0 {
+					add(text.String(), "x-default")
+				}
+				property = ""
+			}
+		}
+	}
+
+	return m
+}
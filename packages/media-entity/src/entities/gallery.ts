@@ -21,6 +21,12 @@ export interface Stack<Languages extends string = string> {
   id: string
   variants: Image<Languages>[]
   tags: string[]
+
+  /**
+   * Post-processing status of the Stack. Only provided by APIs that serve the
+   * status of their stacks.
+   */
+  processing?: ProcessingStatus
 }
 
 /**
@@ -33,6 +39,29 @@ export interface Image<Languages extends string = string>
   original: boolean
 }
 
+/**
+ * ProcessingState is the post-processing state of a {@link Stack}. An empty
+ * string means that the Stack was not processed yet.
+ */
+export type ProcessingState = '' | 'processing' | 'failed' | 'processed'
+
+/**
+ * ProcessingStatus is the post-processing status of a {@link Stack}.
+ */
+export interface ProcessingStatus {
+  state: ProcessingState
+
+  /**
+   * Number of the current or last processing attempt, starting at 1.
+   */
+  attempt: number
+
+  /**
+   * Error message of the last failed attempt.
+   */
+  reason?: string
+}
+
 /**
  * Hydrate a {@link Gallery} from an API response.
  */
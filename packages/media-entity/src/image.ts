@@ -36,6 +36,27 @@ export interface Image<Languages extends string = string> {
   descriptions: { [lang in Languages]?: string }
 
   tags: string[]
+
+  /**
+   * SHA-256 hash of the image contents, if the image is stored at a
+   * content-addressed path.
+   */
+  hash?: string
+
+  /**
+   * Public URL of the image, if it was resolved by the server.
+   */
+  url?: string
+
+  /**
+   * URL that the image was imported from, if it was imported from a URL.
+   */
+  source?: string
+
+  /**
+   * EXIF, IPTC, and XMP metadata of the image file, if it contains any.
+   */
+  metadata?: ImageMetadata
 }
 
 /**
@@ -46,6 +67,59 @@ export interface ImageDimensions {
   height: number
 }
 
+/**
+ * ImageMetadata provides the EXIF, IPTC, and XMP metadata of an {@link Image}.
+ */
+export interface ImageMetadata {
+  make?: string
+  model?: string
+  lens?: string
+
+  /**
+   * EXIF orientation of the image, from 1 to 8.
+   */
+  orientation?: number
+
+  /**
+   * RFC 3339 date and time at which the image was captured.
+   */
+  capturedAt?: string
+
+  /**
+   * Exposure time in seconds, for example "1/250".
+   */
+  exposureTime?: string
+
+  fNumber?: number
+  iso?: number
+
+  /**
+   * Focal length in millimeters.
+   */
+  focalLength?: number
+
+  gps?: ImageGPS
+  creator?: string
+  copyright?: string
+  title?: string
+  caption?: string
+  keywords?: string[]
+}
+
+/**
+ * ImageGPS is a location in decimal degrees. Southern latitudes and western
+ * longitudes are negative.
+ */
+export interface ImageGPS {
+  latitude: number
+  longitude: number
+
+  /**
+   * Altitude in meters above sea level.
+   */
+  altitude?: number
+}
+
 /**
  * Hydrates an {@link Image} from an API response.
  */